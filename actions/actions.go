@@ -2,20 +2,15 @@ package actions
 
 import (
 	"archive/zip"
-	"bytes"
 	"encoding/json"
-	"fmt"
 	"github.com/google/uuid"
-	"github.com/pdfcpu/pdfcpu/pkg/api"
-	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
 	"github.com/skius/rm-pdf-tools/document"
 	"io"
-	"os"
 	"sort"
-	"strconv"
 	"strings"
 )
 
+// Sort sorts actions by their page.
 func Sort(actions []Action) {
 	sort.Slice(actions, func(i, j int) bool {
 		iP := actions[i].Page()
@@ -25,204 +20,289 @@ func Sort(actions []Action) {
 	})
 }
 
-// RunFile processes fileNameOriginal to fileNameProcessed after applying acts.
-func RunFile(uuidOriginal, fileNameOriginal, fileNameProcessed string, acts []Action) {
-	r, err := zip.OpenReader(fileNameOriginal)
-	if err != nil {
-		panic(err)
-	}
-
-	outFile, err := os.Create(fileNameProcessed)
-	if err != nil {
-		panic(err)
+// RunZip writes the entries of the document uuidOriginal in r after applying acts to w. The processed document gets a
+// fresh UUID.
+func RunZip(env *document.Env, uuidOriginal string, r *zip.Reader, w *zip.Writer, acts []Action) error {
+	if document.DetectFormat(r) == document.FormatRmdoc {
+		return runRmdoc(env, uuidOriginal, r, w, acts)
 	}
-	w := zip.NewWriter(outFile)
 
 	// Use a fresh UUID to avoid collisions when uploading the document
 	uuidNew := uuid.New().String()
-	innerFiles := []*zip.File{}
-	innerFilesStrs := []string{}
+	pageFiles := []*zip.File{}
+	pageFileNames := []document.PageFile{}
+	var pageUuids []string
 
 	for _, f := range r.File {
-		// Handle inner files (annotations)
-		if strings.Contains(f.Name, "/") {
-			innerFiles = append(innerFiles, f)
-			innerFilesStrs = append(innerFilesStrs, f.FileInfo().Name())
+		if err := env.Err(); err != nil {
+			return err
+		}
+		entry := document.ClassifyEntry(f.Name, uuidOriginal)
+
+		// Handle per-page files (annotations, highlights, thumbnails, ...) once the page UUIDs are known
+		if entry.Kind == document.EntryPageFile {
+			pageFiles = append(pageFiles, f)
+			pageFileNames = append(pageFileNames, entry.PageFile)
 			continue
 		}
 
-		// Handle files in top-level (should only be uuid.pagedata, uuid.content and uuid.pdf)
+		// Everything else only gets renamed to the new UUID
 		newName := strings.ReplaceAll(f.Name, uuidOriginal, uuidNew)
-		fw, err := w.Create(newName)
-		if err != nil {
-			panic(err)
-		}
 
-		fb := new(bytes.Buffer)
-		rc, err := f.Open()
-		_, err = io.Copy(fb, rc)
-		if err != nil {
-			panic(err)
+		var err error
+		switch entry.Kind {
+		case document.EntryContent:
+			err = runContentEntry(f, w, newName, uuidOriginal, acts, &pageUuids)
+		case document.EntryPagedata:
+			var data []byte
+			data, err = readZipEntry(f)
+			if err == nil {
+				err = writeZipEntry(w, newName, []byte(RunPagedata(string(data), acts)))
+			}
+		case document.EntryPdf:
+			err = runPdfEntry(env, f, w, newName, acts)
+		default:
+			env.Logf("Passing through file: %s", f.Name)
+			err = copyZipEntry(w, f, newName)
 		}
-		err = rc.Close()
 		if err != nil {
-			panic(err)
+			return err
 		}
+	}
 
-		var data []byte
-
-		if strings.HasSuffix(f.Name, ".content") {
-			newContent := RunContent(string(fb.Bytes()), acts)
-			data = []byte(newContent)
-		} else if strings.HasSuffix(f.Name, ".pagedata") {
-			newPagedata := RunPagedata(string(fb.Bytes()), acts)
-			data = []byte(newPagedata)
-		} else if strings.HasSuffix(f.Name, ".pdf") {
-			reader := bytes.NewReader(fb.Bytes())
-			buf := new(bytes.Buffer)
+	// Handle all per-page files, e.g. "uuid/*" and "uuid.highlights/*"
+	repls := RunPageFiles(pageFileNames, pageUuids, acts)
+	for i, f := range pageFiles {
+		pr := repls[i]
 
-			RunPdf(reader, buf, acts)
-			data = buf.Bytes()
-		} else {
-			panic("unexpected file: " + f.Name)
+		env.Logf("Processing replacement for: %s new: %s deleted: %t", f.Name, pr.New.Path(uuidNew), pr.Deleted)
+		if pr.Deleted {
+			continue
 		}
 
-		_, err = fw.Write(data)
+		err := env.Err()
+		if err == nil {
+			err = copyZipEntry(w, f, pr.New.Path(uuidNew))
+		}
 		if err != nil {
-			panic(err)
+			return err
 		}
 	}
+	return nil
+}
 
-	// Handle all files in "uuid/*"
-	repl := RunLines(innerFilesStrs, acts)
-	for _, f := range innerFiles {
-		innerName := f.FileInfo().Name()
-		pr := repl[innerName]
+// runRmdoc is RunZip for documents in document.FormatRmdoc. Their pages refer to PDF pages instead of matching them one to
+// one, so the document is read into the page model of document.FormatZip, edited, and written back.
+func runRmdoc(env *document.Env, uuidOriginal string, r *zip.Reader, w *zip.Writer, acts []Action) error {
+	doc, err := document.FromZipReader(env, r, uuidOriginal)
+	if err != nil {
+		return err
+	}
+	defer doc.Close()
 
-		fmt.Println("Processing replacement for:", innerName, "orig:", pr.OriginalIdx, "new:", pr.NewIdx, "deleted:", pr.Deleted)
-		if pr.Deleted {
-			continue
-		}
+	res, err := RunDoc(env, doc, acts)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	return res.WriteZipEntries(w)
+}
 
-		newName := uuidNew + "/" + strings.ReplaceAll(innerName, strconv.Itoa(pr.OriginalIdx), strconv.Itoa(pr.NewIdx))
-		fw, err := w.Create(newName)
-		if err != nil {
-			panic(err)
+// RunDoc applies acts to doc and returns the result, which gets a fresh UUID like with RunZip.
+func RunDoc(env *document.Env, doc document.PdfDocument, acts []Action) (document.PdfDocument, error) {
+	pageCount := len(doc.Content.Pages)
+	err := checkPageRange(acts, pageCount)
+	if err != nil {
+		return document.PdfDocument{}, err
+	}
+
+	res := doc
+	res.Uuid = uuid.New().String()
+
+	// Without the cPages, which parseContent would take the pages from, so the edit applies to the pages
+	content := doc.Content
+	content.CPages = nil
+	contentData, err := json.Marshal(content)
+	if err != nil {
+		return document.PdfDocument{}, err
+	}
+	newContent, err := RunContent(string(contentData), acts)
+	if err != nil {
+		return document.PdfDocument{}, err
+	}
+	newContent, err = recordEdit(newContent, doc.Uuid, pageCount, acts)
+	if err != nil {
+		return document.PdfDocument{}, err
+	}
+	res.Content = document.Content{}
+	err = json.Unmarshal([]byte(newContent), &res.Content)
+	if err != nil {
+		return document.PdfDocument{}, err
+	}
+	// The cPages are stale now and the pages are authoritative, they only keep what else is known about each page
+	res.Content.CPages = doc.Content.CPages
+
+	res.Pagedata = strings.Split(RunPagedata(strings.Join(doc.Pagedata, "\n"), acts), "\n")
+
+	pageFiles := make([]document.PageFile, 0, len(doc.PageFiles))
+	for pf := range doc.PageFiles {
+		pageFiles = append(pageFiles, pf)
+	}
+	res.PageFiles = make(map[document.PageFile][]byte)
+	for _, repl := range RunPageFiles(pageFiles, doc.Content.Pages, acts) {
+		if !repl.Deleted {
+			res.PageFiles[repl.New] = doc.PageFiles[repl.Original]
 		}
+	}
 
-		fb := new(bytes.Buffer)
-		rc, err := f.Open()
-		_, err = io.Copy(fb, rc)
-		if err != nil {
-			panic(err)
+	res.Pdf = nil
+	if doc.Pdf != nil {
+		if err := env.Err(); err != nil {
+			return document.PdfDocument{}, err
 		}
-		err = rc.Close()
+		res.Pdf = env.NewBlob()
+		err = RunPdf(doc.Pdf.Reader(), res.Pdf, acts)
 		if err != nil {
-			panic(err)
+			res.Close()
+			return document.PdfDocument{}, err
 		}
+	}
+	return res, nil
+}
 
-		_, err = fw.Write(fb.Bytes())
-		if err != nil {
-			panic(err)
-		}
+// runContentEntry writes the content f after applying acts to w as name, and stores the original page UUIDs in pageUuids.
+func runContentEntry(f *zip.File, w *zip.Writer, name, uuidOriginal string, acts []Action, pageUuids *[]string) error {
+	data, err := readZipEntry(f)
+	if err != nil {
+		return err
 	}
+	content, err := parseContent(data)
+	if err != nil {
+		return err
+	}
+	*pageUuids = content.Pages
+	err = checkPageRange(acts, len(content.Pages))
+	if err != nil {
+		return err
+	}
+
+	newContent, err := RunContent(string(data), acts)
+	if err != nil {
+		return err
+	}
+	// Make the edit undoable
+	newContent, err = recordEdit(newContent, uuidOriginal, len(content.Pages), acts)
+	if err != nil {
+		return err
+	}
+	return writeZipEntry(w, name, []byte(newContent))
+}
 
-	err = w.Close()
+// runPdfEntry writes the PDF f after applying acts to w as name.
+func runPdfEntry(env *document.Env, f *zip.File, w *zip.Writer, name string, acts []Action) error {
+	// pdfcpu needs to seek, so the PDF is spooled to a Blob, but the result is written directly to the .zip
+	rc, err := f.Open()
 	if err != nil {
-		panic(err)
+		return &document.DocumentError{Op: "read " + f.Name, Err: err}
 	}
-	err = outFile.Close()
+	pdf, err := env.ReadBlob(rc)
+	rc.Close()
 	if err != nil {
-		panic(err)
+		return &document.DocumentError{Op: "read " + f.Name, Err: err}
 	}
-	err = r.Close()
+	defer pdf.Close()
+
+	fw, err := w.Create(name)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	return RunPdf(pdf.Reader(), fw, acts)
 }
 
 // RunPdf takes a PDF as input and writes the resulting PDF after applying actions to outW.
-func RunPdf(pdf io.ReadSeeker, outW io.Writer, actions []Action) {
-	Sort(actions)
-
-	conf := pdfcpu.NewDefaultConfiguration()
-	currReader := pdf
+// The PDF is read and written only once, no matter how many pages are inserted or deleted.
+func RunPdf(pdf io.ReadSeeker, outW io.Writer, actions []Action) error {
+	return document.RearrangePdf(pdf, outW, func(pageCount int) ([]document.PdfPage, error) {
+		return RunPdfPages(pageCount, actions)
+	})
+}
 
-	for i := len(actions)-1; i >= 0; i-- {
-		currActionI := actions[i]
+// RunPdfPages computes the pages of the resulting PDF when applying actions to a PDF with pageCount pages.
+// Inserted pages are blank pages of the same size as the page they are inserted before or after.
+func RunPdfPages(pageCount int, actions []Action) ([]document.PdfPage, error) {
+	err := checkPageRange(actions, pageCount)
+	if err != nil {
+		return nil, err
+	}
 
-		switch currAction := currActionI.(type) {
-		case Delete:
-			writer := new(bytes.Buffer)
-			err := api.RemovePages(currReader, writer, []string{ fmt.Sprintf("%d-%d", currAction.PageNo, currAction.PageNo + currAction.Count - 1) }, conf)
-			if err != nil {
-				panic(err)
-			}
-			currReader = bytes.NewReader(writer.Bytes())
-		case Insert:
-			for c := 0; c < currAction.Count; c++ {
-				writer := new(bytes.Buffer)
-				err := api.InsertPages(currReader, writer, []string{ fmt.Sprintf("%d", currAction.PageNo) }, !currAction.InsertAfter, conf)
-				if err != nil {
-					panic(err)
-				}
-				currReader = bytes.NewReader(writer.Bytes())
-			}
+	pages := Run(pageCount, actions)
+	res := make([]document.PdfPage, len(pages))
+	for i, p := range pages {
+		if p.Original == -1 {
+			res[i] = document.PdfPage{SourcePage: p.New.Like + 1, Blank: true}
+		} else {
+			res[i] = document.PdfPage{SourcePage: p.Original + 1}
 		}
 	}
+	return res, nil
+}
 
-	buf, err := io.ReadAll(currReader)
-	if err != nil {
-		panic(err)
+// RunPageFiles computes the new name of each per-page file and whether it gets deleted or not.
+// pageUuids are the page UUIDs of the original document, used to find the page of files named after the page UUID.
+func RunPageFiles(files []document.PageFile, pageUuids []string, actions []Action) []PageFileReplacement {
+	uuidIdx := make(map[string]int)
+	for i, pageUuid := range pageUuids {
+		uuidIdx[pageUuid] = i
 	}
 
-	_, err = outW.Write(buf)
-	if err != nil {
-		panic(err)
+	indices := make([]int, 0, len(files))
+	for _, pf := range files {
+		if !pf.Key.IsUuid() {
+			indices = append(indices, pf.Key.Index)
+		} else if idx, ok := uuidIdx[pf.Key.Uuid]; ok {
+			indices = append(indices, idx)
+		}
+	}
+	repls := RunPageIndices(indices, actions)
+
+	res := make([]PageFileReplacement, len(files))
+	for i, pf := range files {
+		res[i] = PageFileReplacement{Original: pf, New: pf}
+		if !pf.Key.IsUuid() {
+			pr := repls[pf.Key.Index]
+			res[i].New = pf.WithKey(document.PageKey{Index: pr.NewIdx})
+			res[i].Deleted = pr.Deleted
+		} else if idx, ok := uuidIdx[pf.Key.Uuid]; ok {
+			// Page UUIDs are kept, so only deletions matter
+			res[i].Deleted = repls[idx].Deleted
+		}
 	}
+	return res
 }
 
-// RunLines takes a slice of all filenames in the uuid/ directory and computes their respective new index and whether they get deleted or not.
-func RunLines(files []string, actions []Action) map[string]PageReplacement {
-	Sort(actions)
-
-	res := make(map[string]PageReplacement)
-	for _, f := range files {
-		idx := getIdxFromFileName(f)
-		res[f] = PageReplacement{OriginalIdx: idx, NewIdx: idx, Deleted: false}
-	}
-
-	for i := len(actions)-1; i >= 0; i-- {
-		currActionI := actions[i]
-		switch currAction := currActionI.(type) {
-		case Delete:
-			pageIdx := currAction.PageNo - 1
-			for k, pr := range res {
-				if pr.OriginalIdx >= pageIdx && pr.OriginalIdx < pageIdx + currAction.Count {
-					// This is one of the pages that the user wants to delete
-					pr.Deleted = true
-					res[k] = pr
-				} else if pr.OriginalIdx >= pageIdx + currAction.Count {
-					// we need to reduce that page's index
-					pr.NewIdx -= currAction.Count
-					res[k] = pr
-				}
-			}
+// RunPageIndices takes a slice of page indices and computes their respective new index and whether they get deleted or not.
+func RunPageIndices(indices []int, actions []Action) map[int]PageReplacement {
+	pageCount := 0
+	for _, idx := range indices {
+		if idx >= pageCount {
+			pageCount = idx + 1
+		}
+	}
+	newIdx := make(map[int]int)
+	for i, p := range Run(pageCount, actions) {
+		if p.Original != -1 {
+			newIdx[p.Original] = i
+		}
+	}
 
-		case Insert:
-			pageIdx := currAction.PageNo - 1
-			if !currAction.InsertAfter {
-				// Inserting after the previous page is equivalent to inserting before the page
-				pageIdx--
-			}
-			for k, pr := range res {
-				if pr.OriginalIdx > pageIdx {
-					// we need to increase that page's index by Count
-					pr.NewIdx += currAction.Count
-					res[k] = pr
-				}
-			}
+	res := make(map[int]PageReplacement)
+	for _, idx := range indices {
+		pr := PageReplacement{OriginalIdx: idx, NewIdx: idx, Deleted: true}
+		if i, ok := newIdx[idx]; ok {
+			pr.NewIdx = i
+			pr.Deleted = false
 		}
+		res[idx] = pr
 	}
 
 	return res
@@ -230,58 +310,53 @@ func RunLines(files []string, actions []Action) map[string]PageReplacement {
 
 // RunPagedata takes pagedata and returns the pagedata after applying actions.
 func RunPagedata(pagedata string, actions []Action) string {
-	Sort(actions)
-
 	lines := strings.Split(pagedata, "\n")
-	linesI := stringSliceToAnySlice(lines)
-	linesProcI := runSlice(linesI, actions, func() interface{} { return "Blank" })
-	linesProc := anySliceToStringSlice(linesProcI)
+	pages := Run(len(lines), actions)
+	linesProc := make([]string, len(pages))
+	for i, p := range pages {
+		if p.Original == -1 {
+			linesProc[i] = p.New.Template
+		} else {
+			linesProc[i] = lines[p.Original]
+		}
+	}
 
 	return strings.Join(linesProc, "\n")
 }
 
 // RunContent takes a content JSON string and returns the content JSON string after applying actions.
-func RunContent(contentStr string, actions []Action) string {
-	Sort(actions)
-
-	content := document.Content{}
-	err := json.Unmarshal([]byte(contentStr), &content)
+func RunContent(contentStr string, actions []Action) (string, error) {
+	content, err := parseContent([]byte(contentStr))
 	if err != nil {
-		panic(err)
-	}
-
-	pageCnt := content.PageCount
-	newPageCnt := pageCnt
-	for i := len(actions)-1; i >= 0; i-- {
-		currActionI := actions[i]
-		switch currAction := currActionI.(type) {
-		case Delete:
-			newPageCnt -= currAction.Count
-		case Insert:
-			newPageCnt += currAction.Count
-		}
+		return "", err
 	}
 
-	content.PageCount = newPageCnt
-	pages := content.Pages
-	// Keeping old page UUIDs for now
-	//for i := range pages {
-	//	pages[i] = uuid.New().String()
-	//}
-	pagesI := stringSliceToAnySlice(pages)
-	pagesProcI := runSlice(pagesI, actions, func() interface{} {
-		randomUuid := uuid.New()
-		return randomUuid.String()
-	})
-	pagesProc := anySliceToStringSlice(pagesProcI)
-
+	pages := Run(len(content.Pages), actions)
+	// Keeping old page UUIDs, new pages get random ones
+	pagesProc := make([]string, len(pages))
+	for i, p := range pages {
+		if p.Original == -1 {
+			pagesProc[i] = uuid.New().String()
+		} else {
+			pagesProc[i] = content.Pages[p.Original]
+		}
+	}
 
-	//content.Pages = []string{}
+	content.PageCount += len(pagesProc) - len(content.Pages)
 	content.Pages = pagesProc
 
+	// The MergeLog of a merged document refers to its pages by index
+	if log, ok := MergeLogOf(content); ok {
+		if log, ok = log.mapPages(pages); ok {
+			content.DocumentMetadata[mergeLogKey] = log
+		} else {
+			delete(content.DocumentMetadata, mergeLogKey)
+		}
+	}
+
 	res, err := json.Marshal(&content)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return string(res)
+	return string(res), nil
 }
@@ -0,0 +1,281 @@
+package actions
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/skius/rm-pdf-tools/document"
+	"github.com/skius/rm-pdf-tools/document/rm"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DeletedPage is a page of the original document that gets deleted, with the annotations that are lost.
+type DeletedPage struct {
+	Idx        int
+	Strokes    int
+	Highlights int
+}
+
+// DryRunReport describes what applying actions to a document would do, without doing it.
+type DryRunReport struct {
+	PageCount    int
+	NewPageCount int
+	// Pages maps every page of the original document to its new index, or marks it deleted.
+	Pages   []PageReplacement
+	Deleted []DeletedPage
+	// Inserted are the new indices of the inserted blank pages.
+	Inserted []int
+	// Renamed are the page files which get renamed or deleted.
+	Renamed []PageFileReplacement
+}
+
+func (r DryRunReport) String() string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "%d pages -> %d pages\n", r.PageCount, r.NewPageCount)
+
+	sb.WriteString("Page map:\n")
+	// Condense runs of pages which keep their relative order, e.g. "4-200 -> 5-201"
+	for i := 0; i < len(r.Pages); {
+		pr := r.Pages[i]
+		if pr.Deleted {
+			fmt.Fprintf(&sb, "  %d deleted\n", pr.OriginalIdx+1)
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(r.Pages) && !r.Pages[j].Deleted && r.Pages[j].NewIdx == pr.NewIdx+(j-i) {
+			j++
+		}
+		if j-i == 1 {
+			fmt.Fprintf(&sb, "  %d -> %d\n", pr.OriginalIdx+1, pr.NewIdx+1)
+		} else {
+			fmt.Fprintf(&sb, "  %d-%d -> %d-%d\n", pr.OriginalIdx+1, pr.OriginalIdx+j-i, pr.NewIdx+1, pr.NewIdx+j-i)
+		}
+		i = j
+	}
+
+	if len(r.Deleted) > 0 {
+		sb.WriteString("Deleted pages:\n")
+		for _, d := range r.Deleted {
+			fmt.Fprintf(&sb, "  %d (%d strokes, %d highlights)\n", d.Idx+1, d.Strokes, d.Highlights)
+		}
+	}
+
+	if len(r.Inserted) > 0 {
+		inserted := make([]string, len(r.Inserted))
+		for i, idx := range r.Inserted {
+			inserted[i] = fmt.Sprint(idx + 1)
+		}
+		fmt.Fprintf(&sb, "Inserted blank pages: %s\n", strings.Join(inserted, ", "))
+	}
+
+	if len(r.Renamed) > 0 {
+		sb.WriteString("Page files:\n")
+		for _, repl := range r.Renamed {
+			if repl.Deleted {
+				fmt.Fprintf(&sb, "  %s deleted\n", repl.Original.Dir()+repl.Original.Name())
+			} else {
+				fmt.Fprintf(&sb, "  %s -> %s\n", repl.Original.Dir()+repl.Original.Name(), repl.New.Dir()+repl.New.Name())
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+// DryRunFile computes what RunZip would do to the document in fileNameOriginal, without writing anything.
+func DryRunFile(uuidOriginal, fileNameOriginal string, acts []Action) (DryRunReport, error) {
+	r, err := zip.OpenReader(fileNameOriginal)
+	if err != nil {
+		return DryRunReport{}, &document.DocumentError{Op: "open document", Err: err}
+	}
+	defer r.Close()
+
+	var content document.Content
+	pageFiles := make([]document.PageFile, 0)
+	pageFileEntries := make(map[document.PageFile]*zip.File)
+	for _, f := range r.File {
+		entry := document.ClassifyEntry(f.Name, uuidOriginal)
+		switch entry.Kind {
+		case document.EntryContent:
+			data, err := readZipEntry(f)
+			if err != nil {
+				return DryRunReport{}, err
+			}
+			content, err = parseContent(data)
+			if err != nil {
+				return DryRunReport{}, err
+			}
+		case document.EntryPageFile:
+			pageFiles = append(pageFiles, entry.PageFile)
+			pageFileEntries[entry.PageFile] = f
+		}
+	}
+	sort.Slice(pageFiles, func(i, j int) bool {
+		if pageFiles[i].Kind != pageFiles[j].Kind {
+			return pageFiles[i].Kind < pageFiles[j].Kind
+		}
+		return pageIdxOf(pageFiles[i], content.Pages) < pageIdxOf(pageFiles[j], content.Pages)
+	})
+
+	report := DryRunReport{PageCount: content.PageCount}
+
+	newPages, err := RunPdfPages(content.PageCount, acts)
+	if err != nil {
+		return report, err
+	}
+	report.NewPageCount = len(newPages)
+	for i, p := range newPages {
+		if p.Blank {
+			report.Inserted = append(report.Inserted, i)
+		}
+	}
+
+	indices := make([]int, content.PageCount)
+	for i := range indices {
+		indices[i] = i
+	}
+	repls := RunPageIndices(indices, acts)
+	report.Pages = make([]PageReplacement, content.PageCount)
+	for i := range indices {
+		report.Pages[i] = repls[i]
+		if repls[i].Deleted {
+			report.Deleted = append(report.Deleted, DeletedPage{Idx: i})
+		}
+	}
+
+	for _, repl := range RunPageFiles(pageFiles, content.Pages, acts) {
+		if repl.Deleted || repl.New != repl.Original {
+			report.Renamed = append(report.Renamed, repl)
+		}
+		if !repl.Deleted {
+			continue
+		}
+
+		idx := pageIdxOf(repl.Original, content.Pages)
+		for i := range report.Deleted {
+			if report.Deleted[i].Idx != idx {
+				continue
+			}
+			if repl.Original.Kind != document.PageFileRm && repl.Original.Kind != document.PageFileHighlights {
+				continue
+			}
+			data, err := readZipEntry(pageFileEntries[repl.Original])
+			if err != nil {
+				return report, err
+			}
+			if repl.Original.Kind == document.PageFileRm {
+				report.Deleted[i].Strokes += countStrokes(data)
+			} else {
+				report.Deleted[i].Highlights += countHighlights(data)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// countStrokes counts the strokes of a .rm file, or returns 0 if it cannot be decoded.
+func countStrokes(data []byte) int {
+	page, err := rm.Decode(data)
+	if err != nil {
+		return 0
+	}
+	return len(page.Lines())
+}
+
+// countHighlights counts the highlights of a .highlights JSON file, or returns 0 if it cannot be parsed.
+func countHighlights(data []byte) int {
+	highlights := struct {
+		Highlights [][]interface{} `json:"highlights"`
+	}{}
+	err := json.Unmarshal(data, &highlights)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, hs := range highlights.Highlights {
+		count += len(hs)
+	}
+	return count
+}
+
+// PreviewFile writes a preview PDF of what RunZip would do to the document in fileNameOriginal to w, without changing
+// the document. The preview starts with the dry run report below title, followed by thumbnails of the resulting pages
+// with the deleted pages marked at their original position.
+func PreviewFile(env *document.Env, uuidOriginal, fileNameOriginal string, acts []Action, title string, w io.Writer) (DryRunReport, error) {
+	report, err := DryRunFile(uuidOriginal, fileNameOriginal, acts)
+	if err != nil {
+		return report, err
+	}
+
+	// Notebooks have no PDF, FromZipFilePdf creates blank pages for them
+	pdfDoc, err := document.FromZipFilePdf(env, fileNameOriginal, uuidOriginal)
+	if err != nil {
+		return report, err
+	}
+	defer pdfDoc.Close()
+
+	thumbnails := env.NewBlob()
+	defer thumbnails.Close()
+	err = document.WritePreviewPdf(env, pdfDoc.Pdf.Reader(), thumbnails, func(pageCount int) ([]document.PreviewPage, error) {
+		return PreviewPages(pageCount, acts)
+	})
+	if err != nil {
+		return report, err
+	}
+
+	text := env.NewBlob()
+	defer text.Close()
+	lines := append([]string{title, ""}, strings.Split(strings.TrimSuffix(report.String(), "\n"), "\n")...)
+	err = document.WriteTextPdf(text, lines)
+	if err != nil {
+		return report, err
+	}
+
+	err = api.Merge([]io.ReadSeeker{text.Reader(), thumbnails.Reader()}, w, pdfcpu.NewDefaultConfiguration())
+	if err != nil {
+		return report, &document.DocumentError{Op: "merge preview", Err: err}
+	}
+	return report, nil
+}
+
+// PreviewPages computes the pages shown in the preview of applying actions to a PDF with pageCount pages: the pages of
+// the result, with each deleted page shown where it used to be.
+func PreviewPages(pageCount int, actions []Action) ([]document.PreviewPage, error) {
+	newPages, err := RunPdfPages(pageCount, actions)
+	if err != nil {
+		return nil, err
+	}
+	indices := make([]int, pageCount)
+	for i := range indices {
+		indices[i] = i
+	}
+	repls := RunPageIndices(indices, actions)
+
+	res := make([]document.PreviewPage, 0, len(newPages))
+	next := 0
+	emitUpTo := func(newIdx int) {
+		for ; next <= newIdx; next++ {
+			mark := document.PreviewKept
+			if newPages[next].Blank {
+				mark = document.PreviewInserted
+			}
+			res = append(res, document.PreviewPage{PdfPage: newPages[next], Mark: mark})
+		}
+	}
+	for i := 0; i < pageCount; i++ {
+		if repls[i].Deleted {
+			res = append(res, document.PreviewPage{PdfPage: document.PdfPage{SourcePage: i + 1}, Mark: document.PreviewDeleted})
+			continue
+		}
+		emitUpTo(repls[i].NewIdx)
+	}
+	emitUpTo(len(newPages) - 1)
+
+	return res, nil
+}
@@ -0,0 +1,16 @@
+package actions
+
+import (
+	"fmt"
+)
+
+// ActionError is returned for invalid actions, e.g. a malformed action string or a page which does not exist.
+// The user needs to fix the actions.
+type ActionError struct {
+	Action string
+	Msg    string
+}
+
+func (e *ActionError) Error() string {
+	return fmt.Sprintf("invalid action %q: %s", e.Action, e.Msg)
+}
@@ -0,0 +1,149 @@
+package actions
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"github.com/skius/rm-pdf-tools/document"
+	"strings"
+)
+
+// PageInfo describes a page of a document.
+type PageInfo struct {
+	Uuid string
+	// Template is the pagedata of the page, i.e. the name of its template.
+	Template   string
+	Strokes    int
+	Highlights int
+}
+
+// Info describes a document, see InspectFile.
+type Info struct {
+	Uuid string
+	// Name is the name of the document on the tablet.
+	Name      string
+	FileType  string
+	PageCount int
+	Pages     []PageInfo
+	// PageFiles is the number of per-page files, e.g. annotations and thumbnails.
+	PageFiles int
+	// Edit is the edit which produced the document, or nil if it is not the result of an edit.
+	Edit *EditLog
+	// Merge lists the documents the document was merged from, or is nil if it is not the result of a merge.
+	Merge *MergeLog
+}
+
+func (info Info) String() string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "Name: %s\n", info.Name)
+	fmt.Fprintf(&sb, "UUID: %s\n", info.Uuid)
+	fmt.Fprintf(&sb, "File type: %s\n", info.FileType)
+	fmt.Fprintf(&sb, "Pages: %d\n", info.PageCount)
+	fmt.Fprintf(&sb, "Page files: %d\n", info.PageFiles)
+	if info.Edit != nil {
+		fmt.Fprintf(&sb, "Edited with %s from %s\n", info.Edit.Actions, info.Edit.Original)
+	}
+	if info.Merge != nil {
+		sb.WriteString("Merged from:\n")
+		for _, src := range info.Merge.Sources {
+			fmt.Fprintf(&sb, "  %s (%s): pages %d-%d\n", src.Name, src.Uuid, src.Start+1, src.Start+src.PageCount)
+		}
+	}
+	for i, p := range info.Pages {
+		fmt.Fprintf(&sb, "  %d: %s", i+1, p.Template)
+		if p.Strokes > 0 || p.Highlights > 0 {
+			fmt.Fprintf(&sb, " (%d strokes, %d highlights)", p.Strokes, p.Highlights)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// InspectFile describes the document in fileName without processing it.
+func InspectFile(uuid, fileName string) (Info, error) {
+	r, err := zip.OpenReader(fileName)
+	if err != nil {
+		return Info{}, &document.DocumentError{Op: "open document", Err: err}
+	}
+	defer r.Close()
+
+	info := Info{Uuid: uuid}
+	var pagedata []string
+	pageFileEntries := make(map[document.PageFile]*zip.File)
+	for _, f := range r.File {
+		entry := document.ClassifyEntry(f.Name, uuid)
+		switch entry.Kind {
+		case document.EntryContent:
+			data, err := readZipEntry(f)
+			if err != nil {
+				return info, err
+			}
+			content, err := parseContent(data)
+			if err != nil {
+				return info, err
+			}
+			info.FileType = content.FileType
+			info.PageCount = content.PageCount
+			info.Pages = make([]PageInfo, len(content.Pages))
+			for i, pageUuid := range content.Pages {
+				info.Pages[i].Uuid = pageUuid
+			}
+			if content.CPages != nil {
+				pagedata = content.CPages.Templates()
+			}
+			if log, ok := EditLogOf(content); ok {
+				info.Edit = &log
+			}
+			if log, ok := MergeLogOf(content); ok {
+				info.Merge = &log
+			}
+		case document.EntryMetadata:
+			data, err := readZipEntry(f)
+			if err != nil {
+				return info, err
+			}
+			metadata := struct {
+				VisibleName string `json:"visibleName"`
+			}{}
+			if err := json.Unmarshal(data, &metadata); err != nil {
+				return info, &document.DocumentError{Op: "parse metadata", Err: err}
+			}
+			info.Name = metadata.VisibleName
+		case document.EntryPagedata:
+			data, err := readZipEntry(f)
+			if err != nil {
+				return info, err
+			}
+			pagedata = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		case document.EntryPageFile:
+			pageFileEntries[entry.PageFile] = f
+		}
+	}
+
+	pageUuids := make([]string, len(info.Pages))
+	for i := range info.Pages {
+		info.Pages[i].Template = pagedataLine(pagedata, i)
+		pageUuids[i] = info.Pages[i].Uuid
+	}
+	info.PageFiles = len(pageFileEntries)
+	for pf, f := range pageFileEntries {
+		idx := pageIdxOf(pf, pageUuids)
+		if idx < 0 || idx >= len(info.Pages) {
+			continue
+		}
+		if pf.Kind != document.PageFileRm && pf.Kind != document.PageFileHighlights {
+			continue
+		}
+		data, err := readZipEntry(f)
+		if err != nil {
+			return info, err
+		}
+		if pf.Kind == document.PageFileRm {
+			info.Pages[idx].Strokes += countStrokes(data)
+		} else {
+			info.Pages[idx].Highlights += countHighlights(data)
+		}
+	}
+
+	return info, nil
+}
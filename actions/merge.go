@@ -1,38 +1,186 @@
 package actions
 
 import (
-	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
 	"github.com/skius/rm-pdf-tools/document"
 	"io"
-	"strings"
+	"math"
+	"reflect"
 )
 
 
-// MergeFiles merges the documents stored in fileNames and writes the merged document to outFileName.
-// TODO: Decide if this belongs in a different package
-func MergeFiles(fileNames []string, uuids []string, outFileName string) {
-	pdfDocs := getPdfDocsFromFiles(fileNames, uuids)
+// mergeLogKey is the key of the MergeLog in the documentMetadata of the content of a merged document.
+const mergeLogKey = "rmPdfToolsMerge"
 
+// MergeLog records the documents a merged document was merged from.
+type MergeLog struct {
+	Sources []MergeSource `json:"sources"`
+}
+
+// MergeSource is a document a merged document was merged from.
+type MergeSource struct {
+	Uuid string `json:"uuid"`
+	Name string `json:"name"`
+	// Start is the index of the first page of the document in the merged document. Once the merged document is
+	// edited, the pages from Start on include the pages inserted between those of the document.
+	Start     int `json:"start"`
+	PageCount int `json:"pageCount"`
+}
+
+// mapPages returns the MergeLog of the merged document after its pages were edited to pages, see Run. Sources whose
+// pages were all deleted are dropped. It returns false if no source is left or the pages of the sources were mixed up,
+// e.g. by moving a page from one source to another, so their pages are no longer ranges.
+func (log MergeLog) mapPages(pages []MappedPage) (MergeLog, bool) {
+	res := MergeLog{Sources: make([]MergeSource, 0, len(log.Sources))}
+	for _, source := range log.Sources {
+		first, last := -1, -1
+		for i, p := range pages {
+			if p.Original >= source.Start && p.Original < source.Start+source.PageCount {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+		if first < 0 {
+			continue
+		}
+		if n := len(res.Sources); n > 0 && res.Sources[n-1].Start+res.Sources[n-1].PageCount > first {
+			return MergeLog{}, false
+		}
+		source.Start, source.PageCount = first, last-first+1
+		res.Sources = append(res.Sources, source)
+	}
+	return res, len(res.Sources) > 0
+}
+
+// MergeLogOf returns the MergeLog of content, or false if content is not the result of a merge.
+func MergeLogOf(content document.Content) (MergeLog, bool) {
+	log := MergeLog{}
+	v, ok := content.DocumentMetadata[mergeLogKey]
+	if !ok {
+		return log, false
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return log, false
+	}
+	err = json.Unmarshal(data, &log)
+	return log, err == nil && len(log.Sources) > 0
+}
+
+// MergeOptions configures Merge.
+type MergeOptions struct {
+	// TitlePages adds a page showing the name of each document before its pages.
+	TitlePages bool
+	// Contents adds a table of contents at the front, listing the name and first page of each document with links.
+	Contents bool
+	// Names are the names of the documents for TitlePages and Contents, used for documents without a .metadata file.
+	Names []string
+	// Name is the name of the merged document, stored in its .metadata file. It is "Merged" if empty.
+	Name string
+}
+
+// Merge merges pdfDocs in order into a new document with a fresh UUID. If all of pdfDocs are notebooks and opts adds
+// no pages, the result is a notebook, otherwise it is a PDF and the notebooks among pdfDocs are converted in place, see
+// document.ToPdfDoc. The content of the result is combined from those of pdfDocs, see mergeContent, and records the
+// sources in a MergeLog. The page UUIDs and pagedata of pdfDocs may get renewed.
+func Merge(env *document.Env, pdfDocs []document.PdfDocument, opts MergeOptions) (document.PdfDocument, error) {
+	notebooks := !opts.TitlePages && !opts.Contents
 	for _, pdfDoc := range pdfDocs {
-		fmt.Println(pdfDoc)
+		env.Logf("%s", pdfDoc)
+		if pdfDoc.Content.FileType != "notebook" {
+			notebooks = false
+		}
+	}
+	content := mergeContent(pdfDocs)
+	if notebooks {
+		content.FileType = "notebook"
+	} else {
+		content.FileType = "pdf"
 	}
 
-	mergedDoc := &document.PdfDocument{}
+	// The orientation applies to the whole document, pages of landscape notebooks in a portrait document show their
+	// templates sideways instead
+	for i, pdfDoc := range pdfDocs {
+		if pdfDoc.Content.FileType != "notebook" || pdfDoc.Content.Orientation != "landscape" || content.Orientation == "landscape" {
+			continue
+		}
+		pagedata := make([]string, pdfDoc.Content.PageCount)
+		for j := range pagedata {
+			pagedata[j] = document.LandscapeTemplate(pagedataLine(pdfDoc.Pagedata, j))
+		}
+		pdfDocs[i].Pagedata = pagedata
+	}
+
+	names := make([]string, len(pdfDocs))
+	for i, pdfDoc := range pdfDocs {
+		names[i] = docName(pdfDoc, opts.Names, i)
+	}
 
-	// Copy general information, then overwrite more specific information
-	mergedDoc.Content = pdfDocs[0].Content
+	if !notebooks {
+		for i, pdfDoc := range pdfDocs {
+			if pdfDoc.Content.FileType == "pdf" {
+				continue
+			}
+			if err := env.Err(); err != nil {
+				return document.PdfDocument{}, err
+			}
+			env.Logf("Filetype is not PDF! Creating PDF pages")
+			converted, err := pdfDoc.ToPdfDoc(env)
+			if err != nil {
+				return document.PdfDocument{}, err
+			}
+			pdfDoc.Close()
+			pdfDocs[i] = converted
+		}
+	}
 
+	mergedDoc := &document.PdfDocument{}
+	mergedDoc.Content = content
 	mergedDoc.Uuid = uuid.New().String()
+	mergedDoc.Format = pdfDocs[0].Format
+	mergedDoc.Metadata = mergedMetadata(opts.Name)
+
+	// Documents may share page UUIDs, e.g. a document and an edited copy of it
+	seenPages := make(map[string]bool)
+	for i := range pdfDocs {
+		renewDuplicatePageUuids(&pdfDocs[i], seenPages)
+	}
+
+	n := len(pdfDocs)
+	parts, links, err := addGeneratedPages(env, pdfDocs, names, opts)
+	defer func() {
+		for _, part := range parts[n:] {
+			part.Close()
+		}
+	}()
+	if err != nil {
+		return document.PdfDocument{}, err
+	}
+	pdfDocs = orderParts(parts, n, opts)
 
 	totalPageCount := 0
+	log := MergeLog{Sources: make([]MergeSource, 0, n)}
 	for _, pdfDoc := range pdfDocs {
+		// Generated pages have no UUID
+		if pdfDoc.Uuid != "" {
+			log.Sources = append(log.Sources, MergeSource{
+				Uuid:      pdfDoc.Uuid,
+				Name:      names[len(log.Sources)],
+				Start:     totalPageCount,
+				PageCount: pdfDoc.Content.PageCount,
+			})
+		}
 		totalPageCount += pdfDoc.Content.PageCount
 	}
 	mergedDoc.Content.PageCount = totalPageCount
+	mergedDoc.Content.DocumentMetadata[mergeLogKey] = log
 
 	allPages := make([][]string, totalPageCount)
 	for i, pdfDoc := range pdfDocs {
@@ -42,73 +190,326 @@ func MergeFiles(fileNames []string, uuids []string, outFileName string) {
 
 	allPagedata := make([][]string, totalPageCount)
 	for i, pdfDoc := range pdfDocs {
-		allPagedata[i] = pdfDoc.Pagedata
+		// One line per page, even if the pagedata is short
+		allPagedata[i] = make([]string, pdfDoc.Content.PageCount)
+		for j := range allPagedata[i] {
+			allPagedata[i][j] = pagedataLine(pdfDoc.Pagedata, j)
+		}
 	}
 	mergedDoc.Pagedata = mergeSlices(allPagedata)
 
-	allPdfs := make([][]byte, len(fileNames))
-	for i, pdfDoc := range pdfDocs {
-		//w, _ := os.Create(fmt.Sprint("Tempfile", i, ".pdf"))
-		//w.Write(pdfDoc.Pdf)
-		allPdfs[i] = pdfDoc.Pdf
+	// Notebooks have no PDF, their templates are rendered by the tablet
+	if !notebooks {
+		allPdfs := make([]*document.Blob, len(pdfDocs))
+		for i, pdfDoc := range pdfDocs {
+			allPdfs[i] = pdfDoc.Pdf
+		}
+		mergedDoc.Pdf, err = mergePdfs(env, allPdfs)
+		if err != nil {
+			return document.PdfDocument{}, err
+		}
+		if err = env.Err(); err != nil {
+			mergedDoc.Close()
+			return document.PdfDocument{}, err
+		}
+		if len(links) > 0 {
+			linked := env.NewBlob()
+			err = document.AddPageLinks(mergedDoc.Pdf.Reader(), linked, links)
+			mergedDoc.Pdf.Close()
+			mergedDoc.Pdf = linked
+			if err != nil {
+				mergedDoc.Close()
+				return document.PdfDocument{}, err
+			}
+		}
 	}
-	mergedDoc.Pdf = mergePdfs(allPdfs)
 
-	// To compute the new .rm filenames, simply keep track of a rolling page sum and run the "<pagesum>b1" action
+	// To compute the new page file names, simply keep track of a rolling page sum and run the "<pagesum>b1" action
 	// to shift all the names back by <pagesum>.
 	rollingPageCount := 0
-	mergedDoc.RmFiles = make(map[string][]byte)
+	mergedDoc.PageFiles = make(map[document.PageFile][]byte)
 	for _, pdfDoc := range pdfDocs {
-		rmFiles := pdfDoc.RmFiles
-		rmFileNames := make([]string, 0, len(rmFiles))
-		for fn, _ := range rmFiles {
-			rmFileNames = append(rmFileNames, fn)
+		pageFiles := make([]document.PageFile, 0, len(pdfDoc.PageFiles))
+		for pf := range pdfDoc.PageFiles {
+			pageFiles = append(pageFiles, pf)
 		}
 
-		repls := RunLines(rmFileNames, []Action{Insert{
+		repls := RunPageFiles(pageFiles, pdfDoc.Content.Pages, []Action{Insert{
 			Count: rollingPageCount,
 			PageNo: 1,
 			InsertAfter: false,
 		}})
 
-		for fn, content := range rmFiles {
-			repl := repls[fn]
-			newFn := strings.ReplaceAll(fn, fmt.Sprint(repl.OriginalIdx), fmt.Sprint(repl.NewIdx))
-			mergedDoc.RmFiles[newFn] = content
+		for _, repl := range repls {
+			mergedDoc.PageFiles[repl.New] = pdfDoc.PageFiles[repl.Original]
 		}
 
 		rollingPageCount += pdfDoc.Content.PageCount
 	}
 
-	mergedDoc.WriteToFile(outFileName)
+	return *mergedDoc, nil
+}
+
+// mergeContent returns the content of the merge of pdfDocs, without its file type and pages:
+//   - The orientation is that of all of pdfDocs if they agree, and portrait otherwise.
+//   - The documentMetadata, e.g. the title and authors of PDFs, keeps the entries all of pdfDocs agree on, except for
+//     the EditLog and MergeLog, which only describe the sources.
+//   - The cover page, the settings of the tools and the settings for typed text and reflowable documents (font, line
+//     height, margins, text alignment and scale) are those of the first document, which the merged document starts with.
+func mergeContent(pdfDocs []document.PdfDocument) document.Content {
+	content := pdfDocs[0].Content
+	content.Pages = nil
+	content.CPages = nil
+	content.DummyDocument = false
+
+	content.DocumentMetadata = make(map[string]interface{})
+	for key, v := range pdfDocs[0].Content.DocumentMetadata {
+		if key == editLogKey || key == mergeLogKey {
+			continue
+		}
+		shared := true
+		for _, pdfDoc := range pdfDocs[1:] {
+			if w, ok := pdfDoc.Content.DocumentMetadata[key]; !ok || !reflect.DeepEqual(v, w) {
+				shared = false
+				break
+			}
+		}
+		if shared {
+			content.DocumentMetadata[key] = v
+		}
+	}
+
+	for _, pdfDoc := range pdfDocs {
+		if pdfDoc.Content.Orientation != content.Orientation {
+			content.Orientation = "portrait"
+			break
+		}
+	}
+	return content
+}
+
+// addGeneratedPages returns pdfDocs followed by the generated documents opts asks for: the title page of each of
+// pdfDocs if opts.TitlePages, then the table of contents if opts.Contents, see orderParts. The returned links of the
+// contents refer to the pages of the merged document. The generated pages are as large as the first page of the first
+// document.
+func addGeneratedPages(env *document.Env, pdfDocs []document.PdfDocument, names []string, opts MergeOptions) ([]document.PdfDocument, []document.PageLink, error) {
+	parts := append([]document.PdfDocument{}, pdfDocs...)
+	if !opts.TitlePages && !opts.Contents {
+		return parts, nil, nil
+	}
+
+	dims, err := document.PdfPageDims(pdfDocs[0].Pdf.Reader())
+	if err != nil {
+		return parts, nil, err
+	}
+	if len(dims) == 0 {
+		return parts, nil, &document.DocumentError{Op: "merge", Err: errors.New("first document has no pages")}
+	}
+	width, height := dims[0].Width, dims[0].Height
+
+	if opts.TitlePages {
+		for _, name := range names {
+			b := env.NewBlob()
+			err = document.WriteTitlePdf(b, name, width, height)
+			parts = append(parts, generatedDoc(b, 1))
+			if err != nil {
+				return parts, nil, err
+			}
+		}
+	}
+
+	var links []document.PageLink
+	if opts.Contents {
+		contentsPages := document.ContentsPageCount(len(pdfDocs), width, height)
+		entries := make([]document.TocEntry, len(pdfDocs))
+		page := contentsPages + 1
+		for i, pdfDoc := range pdfDocs {
+			entries[i] = document.TocEntry{Title: names[i], Page: page}
+			if opts.TitlePages {
+				page++
+			}
+			page += pdfDoc.Content.PageCount
+		}
+		b := env.NewBlob()
+		links, err = document.WriteContentsPdf(b, entries, width, height, 1)
+		parts = append(parts, generatedDoc(b, contentsPages))
+		if err != nil {
+			return parts, nil, err
+		}
+	}
+	return parts, links, nil
+}
+
+// orderParts returns the parts returned by addGeneratedPages for n documents in the order they are merged in: the
+// table of contents, then each document preceded by its title page.
+func orderParts(parts []document.PdfDocument, n int, opts MergeOptions) []document.PdfDocument {
+	res := make([]document.PdfDocument, 0, len(parts))
+	if opts.Contents {
+		res = append(res, parts[len(parts)-1])
+	}
+	for i := 0; i < n; i++ {
+		if opts.TitlePages {
+			res = append(res, parts[n+i])
+		}
+		res = append(res, parts[i])
+	}
+	return res
+}
+
+// generatedDoc returns a PDF document without annotations for the generated pdf of pageCount pages.
+func generatedDoc(pdf *document.Blob, pageCount int) document.PdfDocument {
+	pdfDoc := document.PdfDocument{Pdf: pdf}
+	pdfDoc.Content.FileType = "pdf"
+	pdfDoc.Content.PageCount = pageCount
+	pdfDoc.Content.Pages = make([]string, pageCount)
+	for i := range pdfDoc.Content.Pages {
+		pdfDoc.Content.Pages[i] = uuid.New().String()
+	}
+	pdfDoc.Pagedata = make([]string, pageCount)
+	for i := range pdfDoc.Pagedata {
+		pdfDoc.Pagedata[i] = "Blank"
+	}
+	return pdfDoc
+}
+
+// mergedMetadata returns the .metadata of a merged document called name. As a new document, it is in no folder yet.
+func mergedMetadata(name string) []byte {
+	if name == "" {
+		name = "Merged"
+	}
+	metadata := struct {
+		VisibleName string `json:"visibleName"`
+		Type        string `json:"type"`
+		Parent      string `json:"parent"`
+	}{VisibleName: name, Type: "DocumentType"}
+	data, _ := json.Marshal(metadata)
+	return data
 }
 
-func getPdfDocsFromFiles(fileNames []string, uuids []string) []document.PdfDocument {
-	pdfDocs := make([]document.PdfDocument, len(fileNames))
+// docName returns the name of document i of a merge: its visible name, or names[i] if it has no .metadata.
+func docName(pdfDoc document.PdfDocument, names []string, i int) string {
+	metadata := struct {
+		VisibleName string `json:"visibleName"`
+	}{}
+	if pdfDoc.Metadata != nil && json.Unmarshal(pdfDoc.Metadata, &metadata) == nil && metadata.VisibleName != "" {
+		return metadata.VisibleName
+	}
+	if i < len(names) && names[i] != "" {
+		return names[i]
+	}
+	return fmt.Sprintf("Document %d", i+1)
+}
 
-	for i, fileName := range fileNames {
-		pdfDocs[i] = document.FromZipFilePdf(fileName, uuids[i])
+// renewDuplicatePageUuids gives the pages of pdfDoc whose UUID is in seen a fresh UUID, renaming their page files
+// accordingly, and adds the page UUIDs of pdfDoc to seen.
+func renewDuplicatePageUuids(pdfDoc *document.PdfDocument, seen map[string]bool) {
+	renamed := make(map[string]string)
+	pages := make([]string, len(pdfDoc.Content.Pages))
+	for i, pageUuid := range pdfDoc.Content.Pages {
+		if seen[pageUuid] {
+			renamed[pageUuid] = uuid.New().String()
+			pageUuid = renamed[pageUuid]
+		}
+		pages[i] = pageUuid
+		seen[pageUuid] = true
 	}
+	pdfDoc.Content.Pages = pages
 
-	return pdfDocs
+	for pf, data := range pdfDoc.PageFiles {
+		if newUuid, ok := renamed[pf.Key.Uuid]; ok && pf.Key.IsUuid() {
+			delete(pdfDoc.PageFiles, pf)
+			pdfDoc.PageFiles[pf.WithKey(document.PageKey{Uuid: newUuid})] = data
+		}
+	}
+}
+
+// mergePdfs concatenates pdfs, see normalisePageSizes.
+func mergePdfs(env *document.Env, pdfs []*document.Blob) (*document.Blob, error) {
+	pdfs, scaled, err := normalisePageSizes(env, pdfs)
+	defer func() {
+		for _, pdf := range scaled {
+			pdf.Close()
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+	return concatPdfs(env, pdfs)
 }
 
-func mergePdfs(pdfs [][]byte) []byte {
+// concatPdfs concatenates pdfs as they are.
+func concatPdfs(env *document.Env, pdfs []*document.Blob) (*document.Blob, error) {
 	readers := make([]io.ReadSeeker, len(pdfs))
 	for i := range pdfs {
-		//fmt.Println(len(pdfs[i]))
-		readers[i] = bytes.NewReader(pdfs[i])
+		readers[i] = pdfs[i].Reader()
 	}
 
 	conf := pdfcpu.NewDefaultConfiguration()
-	writer := new(bytes.Buffer)
+	writer := env.NewBlob()
 
 	err := api.Merge(readers, writer, conf)
 	if err != nil {
-		panic(err)
+		writer.Close()
+		return nil, &document.DocumentError{Op: "merge PDFs", Err: err}
 	}
 
-	return writer.Bytes()
+	return writer, nil
+}
+
+// pageWidthTolerance is the relative difference of page widths up to which pages are considered to be equally wide.
+const pageWidthTolerance = 0.001
+
+// normalisePageSizes scales the pages of pdfs so that their shorter side is as long as that of the first page of the
+// first of pdfs, e.g. Letter pages merged into an A4 document become as wide as the A4 pages. Each page is scaled on
+// its own, so sources with pages of different sizes end up with pages of the same width, and comparing the shorter
+// sides keeps landscape pages as wide as the portrait pages are high. It returns the PDFs to merge and those of them
+// which were newly created and must be closed.
+func normalisePageSizes(env *document.Env, pdfs []*document.Blob) ([]*document.Blob, []*document.Blob, error) {
+	res := make([]*document.Blob, len(pdfs))
+	copy(res, pdfs)
+	scaled := make([]*document.Blob, 0)
+
+	refSide := 0.0
+	for i, pdf := range pdfs {
+		if err := env.Err(); err != nil {
+			return res, scaled, err
+		}
+		dims, err := document.PdfPageDims(pdf.Reader())
+		if err != nil {
+			return res, scaled, err
+		}
+
+		factors := make([]float64, len(dims))
+		scale := false
+		for p, dim := range dims {
+			factors[p] = 1
+			side := math.Min(dim.Width, dim.Height)
+			if side <= 0 {
+				continue
+			}
+			if refSide == 0 {
+				refSide = side
+				continue
+			}
+			if factor := refSide / side; math.Abs(factor-1) > pageWidthTolerance {
+				factors[p] = factor
+				scale = true
+			}
+		}
+		if !scale {
+			continue
+		}
+
+		env.Logf("Scaling pages of document %d to a width of %.0f points", i+1, refSide)
+		b := env.NewBlob()
+		scaled = append(scaled, b)
+		err = document.ScalePdf(pdf.Reader(), b, factors)
+		if err != nil {
+			return res, scaled, err
+		}
+		res[i] = b
+	}
+	return res, scaled, nil
 }
 
 func mergeSlices(slices [][]string) []string {
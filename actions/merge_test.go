@@ -0,0 +1,217 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/skius/rm-pdf-tools/document"
+)
+
+func TestMergeMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Week 1", "Week 1"},
+		{"", "Merged"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			first := testPdfDoc(t, "one")
+			first.Format = document.FormatRmdoc
+			first.Metadata = []byte(`{"visibleName":"Day 1","type":"DocumentType","parent":"folder"}`)
+			second := testPdfDoc(t, "two")
+			res, err := Merge(nil, []document.PdfDocument{first, second}, MergeOptions{Name: tt.name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Close()
+
+			var metadata struct {
+				VisibleName string `json:"visibleName"`
+				Type        string `json:"type"`
+				Parent      string `json:"parent"`
+			}
+			if err := json.Unmarshal(res.Metadata, &metadata); err != nil {
+				t.Fatal(err)
+			}
+			if metadata.VisibleName != tt.want || metadata.Type != "DocumentType" || metadata.Parent != "" {
+				t.Errorf("got metadata %s, want %q in no folder", res.Metadata, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeContent(t *testing.T) {
+	first := testPdfDoc(t, "one")
+	first.Content.CoverPageNumber = 1
+	first.Content.Orientation = "landscape"
+	first.Content.DocumentMetadata = map[string]interface{}{
+		"title":     "Slides",
+		"authors":   []interface{}{"Ada"},
+		editLogKey:  EditLog{Actions: "-2", Original: "a", Pages: []int{0, -1}},
+		mergeLogKey: MergeLog{Sources: []MergeSource{{Uuid: "b", PageCount: 1}}},
+	}
+	second := testPdfDoc(t, "two")
+	second.Content.Orientation = "landscape"
+	second.Content.DocumentMetadata = map[string]interface{}{
+		"title":   "Notes",
+		"authors": []interface{}{"Ada"},
+	}
+
+	content := mergeContent([]document.PdfDocument{first, second})
+	want := map[string]interface{}{"authors": []interface{}{"Ada"}}
+	if !reflect.DeepEqual(content.DocumentMetadata, want) {
+		t.Errorf("documentMetadata %v, want %v", content.DocumentMetadata, want)
+	}
+	if content.Orientation != "landscape" || content.CoverPageNumber != 1 {
+		t.Errorf("orientation %q and cover page %d, want those of the first document", content.Orientation, content.CoverPageNumber)
+	}
+	if content.Pages != nil {
+		t.Errorf("pages %v, want none", content.Pages)
+	}
+
+	second.Content.Orientation = "portrait"
+	if content := mergeContent([]document.PdfDocument{first, second}); content.Orientation != "portrait" {
+		t.Errorf("orientation %q, want portrait for documents of mixed orientation", content.Orientation)
+	}
+}
+
+func TestMergeLogRoundTrip(t *testing.T) {
+	first := testPdfDoc(t, "one", "two")
+	first.Metadata = []byte(`{"visibleName":"Slides"}`)
+	second := testPdfDoc(t, "three", "four", "five")
+	res, err := Merge(nil, []document.PdfDocument{first, second}, MergeOptions{TitlePages: true, Names: []string{"", "Notes"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	// The log is read back from the content file
+	data, err := json.Marshal(&res.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := parseContent(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, ok := MergeLogOf(content)
+	if !ok {
+		t.Fatal("no merge log")
+	}
+	// Each document follows its title page
+	want := []MergeSource{
+		{Uuid: first.Uuid, Name: "Slides", Start: 1, PageCount: 2},
+		{Uuid: second.Uuid, Name: "Notes", Start: 4, PageCount: 3},
+	}
+	if !reflect.DeepEqual(log.Sources, want) {
+		t.Errorf("sources %+v, want %+v", log.Sources, want)
+	}
+
+	if _, ok := MergeLogOf(first.Content); ok {
+		t.Errorf("a document which is not merged has a merge log")
+	}
+}
+
+func TestMergeLogMapPages(t *testing.T) {
+	log := MergeLog{Sources: []MergeSource{{Uuid: "a", Start: 0, PageCount: 2}, {Uuid: "b", Start: 2, PageCount: 2}}}
+	tests := []struct {
+		name string
+		// pages are the original indices of the pages after the edit, -1 for new pages
+		pages []int
+		// want are the start and page count of each source kept, nil if the log is dropped
+		want [][2]int
+	}{
+		{"unchanged", []int{0, 1, 2, 3}, [][2]int{{0, 2}, {2, 2}}},
+		{"deleted", []int{1, 2, 3}, [][2]int{{0, 1}, {1, 2}}},
+		{"inserted between documents", []int{0, 1, -1, 2, 3}, [][2]int{{0, 2}, {3, 2}}},
+		{"inserted into document", []int{0, -1, 1, 2, 3}, [][2]int{{0, 3}, {3, 2}}},
+		{"document deleted", []int{2, 3}, [][2]int{{0, 2}}},
+		{"moved within document", []int{1, 0, 2, 3}, [][2]int{{0, 2}, {2, 2}}},
+		{"moved to other document", []int{0, 2, 1, 3}, nil},
+		{"all deleted", []int{-1}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages := make([]MappedPage, len(tt.pages))
+			for i, original := range tt.pages {
+				pages[i] = MappedPage{Original: original}
+			}
+			res, ok := log.mapPages(pages)
+			if ok != (tt.want != nil) {
+				t.Fatalf("got ok %v, want %v", ok, tt.want != nil)
+			}
+			got := make([][2]int, 0, len(res.Sources))
+			for _, source := range res.Sources {
+				got = append(got, [2]int{source.Start, source.PageCount})
+			}
+			if ok && fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("sources at %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunContentMergeLog(t *testing.T) {
+	content := document.Content{
+		Pages:     []string{"p1", "p2", "p3"},
+		PageCount: 3,
+		DocumentMetadata: map[string]interface{}{
+			mergeLogKey: MergeLog{Sources: []MergeSource{{Uuid: "a", Start: 0, PageCount: 1}, {Uuid: "b", Start: 1, PageCount: 2}}},
+		},
+	}
+	data, err := json.Marshal(&content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited, err := RunContent(string(data), []Action{Delete{Count: 1, PageNo: 1}, Insert{Count: 1, PageNo: 2, InsertAfter: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := parseContent([]byte(edited))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, ok := MergeLogOf(res)
+	want := []MergeSource{{Uuid: "b", Start: 0, PageCount: 3}}
+	if !ok || !reflect.DeepEqual(log.Sources, want) {
+		t.Errorf("sources %+v, want %+v", log.Sources, want)
+	}
+}
+
+func TestEditAndUndoMergeLog(t *testing.T) {
+	first := testPdfDoc(t, "one", "two")
+	second := testPdfDoc(t, "three")
+	merged, err := Merge(nil, []document.PdfDocument{first, second}, MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer merged.Close()
+	mergeLog, _ := MergeLogOf(merged.Content)
+
+	edited, err := RunDoc(nil, merged, []Action{Delete{Count: 1, PageNo: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edited.Close()
+	log, ok := MergeLogOf(edited.Content)
+	want := []MergeSource{mergeLog.Sources[0], mergeLog.Sources[1]}
+	want[0].PageCount, want[1].Start = 1, 1
+	if !ok || !reflect.DeepEqual(log.Sources, want) {
+		t.Errorf("sources after the edit %+v, want %+v", log.Sources, want)
+	}
+
+	editLog, _ := EditLogOf(edited.Content)
+	undone, _, err := Undo(nil, edited, merged, editLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer undone.Close()
+	if log, ok := MergeLogOf(undone.Content); !ok || !reflect.DeepEqual(log, mergeLog) {
+		t.Errorf("sources after undo %+v, want %+v", log.Sources, mergeLog.Sources)
+	}
+}
@@ -0,0 +1,269 @@
+package actions
+
+import (
+	"archive/zip"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/skius/rm-pdf-tools/document"
+	"strings"
+)
+
+// minRebaseSimilarity is the minimum similarity for two pages to be considered the same page.
+const minRebaseSimilarity = 0.5
+
+// PageMatch is an old page that was matched to a page of the new PDF.
+type PageMatch struct {
+	OldIdx     int
+	NewIdx     int
+	Similarity float64
+}
+
+// RebaseReport describes how the pages of the old document were carried over to the new PDF.
+type RebaseReport struct {
+	Matches []PageMatch
+	// Unmatched are the indices of old pages without a match, their annotations are dropped.
+	Unmatched []int
+	// UnmatchedAnnotated are the indices of Unmatched pages which had annotations.
+	UnmatchedAnnotated []int
+	// Inserted are the indices of new pages without a match, they are inserted blank.
+	Inserted []int
+}
+
+func (r RebaseReport) String() string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "%d pages matched, %d new pages inserted blank\n", len(r.Matches), len(r.Inserted))
+	for _, m := range r.Matches {
+		if m.OldIdx != m.NewIdx {
+			fmt.Fprintf(&sb, "page %d moved to page %d (similarity %.2f)\n", m.OldIdx+1, m.NewIdx+1, m.Similarity)
+		}
+	}
+	for _, idx := range r.UnmatchedAnnotated {
+		fmt.Fprintf(&sb, "annotated page %d could not be matched, its annotations were dropped\n", idx+1)
+	}
+	return sb.String()
+}
+
+// RebaseFile replaces the PDF of the annotated document in fileNameOriginal by the PDF in fileNameNewPdf and writes
+// the result to fileNameProcessed. Old pages are matched to new pages by their content, and the annotations,
+// pagedata and page UUID of each old page are carried over to its match.
+// The rebased document is validated, a *document.ValidationError is returned if it is invalid.
+func RebaseFile(env *document.Env, uuidOriginal, fileNameOriginal, uuidNewPdf, fileNameNewPdf, fileNameProcessed string) (RebaseReport, error) {
+	oldDoc, err := document.FromZipFilePdf(env, fileNameOriginal, uuidOriginal)
+	if err != nil {
+		return RebaseReport{}, err
+	}
+	defer oldDoc.Close()
+	newDoc, err := document.FromZipFilePdf(env, fileNameNewPdf, uuidNewPdf)
+	if err != nil {
+		return RebaseReport{}, err
+	}
+	defer newDoc.Close()
+
+	rebased, report, err := Rebase(oldDoc, newDoc.Pdf)
+	if err != nil {
+		return report, err
+	}
+	err = rebased.WriteToFile(fileNameProcessed)
+	if err != nil {
+		return report, err
+	}
+	return report, document.Validate(env, fileNameProcessed)
+}
+
+// IsPristine returns whether the document uuid stored in fileName is a PDF as uploaded, without annotations and not
+// the result of an edit or merge. Of the two documents of a rebase, the pristine one is the new PDF.
+func IsPristine(fileName, uuid string) (bool, error) {
+	r, err := zip.OpenReader(fileName)
+	if err != nil {
+		return false, &document.DocumentError{Op: "open document", Err: err}
+	}
+	defer r.Close()
+
+	pristine := true
+	for _, f := range r.File {
+		entry := document.ClassifyEntry(f.Name, uuid)
+		switch {
+		case entry.Kind == document.EntryPageFile && entry.PageFile.Kind == document.PageFileRm:
+			pristine = false
+		case entry.Kind == document.EntryContent:
+			data, err := readZipEntry(f)
+			if err != nil {
+				return false, err
+			}
+			content, err := parseContent(data)
+			if err != nil {
+				return false, err
+			}
+			if content.FileType != "pdf" {
+				return false, nil
+			}
+			_, edited := EditLogOf(content)
+			_, merged := MergeLogOf(content)
+			pristine = pristine && !edited && !merged
+		}
+	}
+	return pristine, nil
+}
+
+// Rebase carries the annotations of oldDoc over to newPdf, see RebaseFile. The result shares newPdf. The EditLog and
+// MergeLog of oldDoc are dropped, so a rebased document can't be undone.
+func Rebase(oldDoc document.PdfDocument, newPdf *document.Blob) (document.PdfDocument, RebaseReport, error) {
+	oldTokens, err := document.PageTokens(oldDoc.Pdf.Reader())
+	if err != nil {
+		return document.PdfDocument{}, RebaseReport{}, err
+	}
+	newTokens, err := document.PageTokens(newPdf.Reader())
+	if err != nil {
+		return document.PdfDocument{}, RebaseReport{}, err
+	}
+	matches := matchPages(oldTokens, newTokens)
+
+	report := RebaseReport{Matches: matches}
+	oldToNew := make(map[int]int)
+	newToOld := make(map[int]int)
+	for _, m := range matches {
+		oldToNew[m.OldIdx] = m.NewIdx
+		newToOld[m.NewIdx] = m.OldIdx
+	}
+
+	annotated := make(map[int]bool)
+	for pf := range oldDoc.PageFiles {
+		annotated[pageIdxOf(pf, oldDoc.Content.Pages)] = true
+	}
+	for i := range oldTokens {
+		if _, ok := oldToNew[i]; !ok {
+			report.Unmatched = append(report.Unmatched, i)
+			if annotated[i] {
+				report.UnmatchedAnnotated = append(report.UnmatchedAnnotated, i)
+			}
+		}
+	}
+
+	res := document.PdfDocument{}
+	res.Uuid = uuid.New().String()
+	res.Format = oldDoc.Format
+	res.Metadata = oldDoc.Metadata
+	res.Content = oldDoc.Content
+	// The EditLog and MergeLog describe the pages of the old PDF
+	res.Content.DocumentMetadata = make(map[string]interface{})
+	for k, v := range oldDoc.Content.DocumentMetadata {
+		if k != editLogKey && k != mergeLogKey {
+			res.Content.DocumentMetadata[k] = v
+		}
+	}
+	res.Pdf = newPdf
+	res.Content.PageCount = len(newTokens)
+	res.Content.Pages = make([]string, len(newTokens))
+	res.Pagedata = make([]string, len(newTokens))
+	for i := range newTokens {
+		oldIdx, ok := newToOld[i]
+		if !ok {
+			report.Inserted = append(report.Inserted, i)
+			res.Content.Pages[i] = uuid.New().String()
+			res.Pagedata[i] = "Blank"
+			continue
+		}
+		res.Content.Pages[i] = oldDoc.Content.Pages[oldIdx]
+		res.Pagedata[i] = "Blank"
+		if oldIdx < len(oldDoc.Pagedata) {
+			res.Pagedata[i] = oldDoc.Pagedata[oldIdx]
+		}
+	}
+
+	res.PageFiles = make(map[document.PageFile][]byte)
+	for pf, data := range oldDoc.PageFiles {
+		switch pf.Kind {
+		case document.PageFileHighlights, document.PageFileThumbnail:
+			// Both refer to the old PDF's rendering
+			continue
+		}
+		newIdx, ok := oldToNew[pageIdxOf(pf, oldDoc.Content.Pages)]
+		if !ok {
+			continue
+		}
+		if !pf.Key.IsUuid() {
+			pf = pf.WithKey(document.PageKey{Index: newIdx})
+		}
+		res.PageFiles[pf] = data
+	}
+
+	return res, report, nil
+}
+
+// pageIdxOf returns the index of the page pf belongs to, or -1 if it belongs to no page of pages.
+func pageIdxOf(pf document.PageFile, pages []string) int {
+	if !pf.Key.IsUuid() {
+		return pf.Key.Index
+	}
+	for i, pageUuid := range pages {
+		if pageUuid == pf.Key.Uuid {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchPages finds the order-preserving matching of old to new pages with the highest total similarity,
+// like a diff does for lines.
+func matchPages(oldTokens, newTokens []map[string]struct{}) []PageMatch {
+	n, m := len(oldTokens), len(newTokens)
+	sim := make([][]float64, n)
+	for i := range sim {
+		sim[i] = make([]float64, m)
+		for j := range sim[i] {
+			sim[i][j] = similarity(oldTokens[i], newTokens[j])
+		}
+	}
+
+	// best[i][j] is the best total similarity matching the first i old pages with the first j new pages
+	best := make([][]float64, n+1)
+	for i := range best {
+		best[i] = make([]float64, m+1)
+	}
+	for i := 1; i <= n; i++ {
+		for j := 1; j <= m; j++ {
+			best[i][j] = best[i-1][j]
+			if best[i][j-1] > best[i][j] {
+				best[i][j] = best[i][j-1]
+			}
+			if s := sim[i-1][j-1]; s >= minRebaseSimilarity && best[i-1][j-1]+s > best[i][j] {
+				best[i][j] = best[i-1][j-1] + s
+			}
+		}
+	}
+
+	matches := make([]PageMatch, 0)
+	for i, j := n, m; i > 0 && j > 0; {
+		s := sim[i-1][j-1]
+		switch {
+		case s >= minRebaseSimilarity && best[i][j] == best[i-1][j-1]+s:
+			matches = append(matches, PageMatch{OldIdx: i - 1, NewIdx: j - 1, Similarity: s})
+			i--
+			j--
+		case best[i][j] == best[i-1][j]:
+			i--
+		default:
+			j--
+		}
+	}
+
+	// Reverse, as we backtracked from the end
+	for l, r := 0, len(matches)-1; l < r; l, r = l+1, r-1 {
+		matches[l], matches[r] = matches[r], matches[l]
+	}
+	return matches
+}
+
+// similarity is the Jaccard index of two token sets.
+func similarity(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for t := range a {
+		if _, ok := b[t]; ok {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
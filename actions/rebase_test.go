@@ -0,0 +1,166 @@
+package actions
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/skius/rm-pdf-tools/document"
+)
+
+// tokens returns the token sets of pages given as space separated words.
+func tokens(pages ...string) []map[string]struct{} {
+	res := make([]map[string]struct{}, len(pages))
+	for i, page := range pages {
+		res[i] = make(map[string]struct{})
+		for _, word := range strings.Fields(page) {
+			res[i][word] = struct{}{}
+		}
+	}
+	return res
+}
+
+func TestMatchPages(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new []string
+		want     [][2]int
+	}{
+		{"identical", []string{"a b", "c d", "e f"}, []string{"a b", "c d", "e f"}, [][2]int{{0, 0}, {1, 1}, {2, 2}}},
+		{"inserted", []string{"a b", "c d"}, []string{"a b", "x y", "c d", "z w"}, [][2]int{{0, 0}, {1, 2}}},
+		{"deleted", []string{"a b", "c d", "e f", "g h"}, []string{"c d", "g h"}, [][2]int{{1, 0}, {3, 1}}},
+		{"changed", []string{"a b c d", "e f"}, []string{"a b c x", "e f"}, [][2]int{{0, 0}, {1, 1}}},
+		{"reordered", []string{"a b", "c d", "e f"}, []string{"e f", "a b", "c d"}, [][2]int{{0, 1}, {1, 2}}},
+		{"no overlap", []string{"a b", "c d"}, []string{"x y", "z w"}, nil},
+		{"below similarity", []string{"a b c"}, []string{"a x y"}, nil},
+		{"empty pages", []string{"", "a b"}, []string{"", "a b"}, [][2]int{{1, 1}}},
+		{"no new pages", []string{"a b"}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := matchPages(tokens(tt.old...), tokens(tt.new...))
+			got := make([][2]int, 0)
+			for _, m := range matches {
+				got = append(got, [2]int{m.OldIdx, m.NewIdx})
+				if m.Similarity < minRebaseSimilarity {
+					t.Errorf("page %d matched to page %d with similarity %.2f", m.OldIdx, m.NewIdx, m.Similarity)
+				}
+			}
+			if len(got) != len(tt.want) || len(got) > 0 && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got matches %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testPdfDoc returns a PDF document with a page showing each of pages.
+func testPdfDoc(t *testing.T, pages ...string) document.PdfDocument {
+	t.Helper()
+	pdfs := make([]io.ReadSeeker, len(pages))
+	for i, page := range pages {
+		buf := bytes.Buffer{}
+		if err := document.WriteTextPdf(&buf, []string{page}); err != nil {
+			t.Fatal(err)
+		}
+		pdfs[i] = bytes.NewReader(buf.Bytes())
+	}
+	buf := bytes.Buffer{}
+	if err := api.Merge(pdfs, &buf, pdfcpu.NewDefaultConfiguration()); err != nil {
+		t.Fatal(err)
+	}
+	pdfDoc, err := document.FromPdfReader(nil, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pdfDoc.Close() })
+	return pdfDoc
+}
+
+func TestRebase(t *testing.T) {
+	oldDoc := testPdfDoc(t, "one alpha", "two beta", "three gamma")
+	oldDoc.PageFiles[document.RmFile(document.PageKey{Index: 1})] = []byte("two")
+	oldDoc.PageFiles[document.RmFile(document.PageKey{Uuid: oldDoc.Content.Pages[2]})] = []byte("three")
+	oldDoc.Content.DocumentMetadata = map[string]interface{}{
+		"title":     "Slides",
+		editLogKey:  EditLog{Actions: "-2", Original: "a", Pages: []int{0, -1}},
+		mergeLogKey: MergeLog{Sources: []MergeSource{{Uuid: "b", Start: 0, PageCount: 2}}},
+	}
+	newDoc := testPdfDoc(t, "one alpha", "new delta", "two beta", "three gamma")
+
+	res, report, err := Rebase(oldDoc, newDoc.Pdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Inserted, []int{1}) || len(report.Matches) != 3 || len(report.Unmatched) > 0 {
+		t.Errorf("got report %+v", report)
+	}
+	if res.Content.PageCount != 4 || len(res.Content.Pages) != 4 || len(res.Pagedata) != 4 {
+		t.Errorf("got %d pages, want 4", res.Content.PageCount)
+	}
+	if res.Content.Pages[0] != oldDoc.Content.Pages[0] || res.Content.Pages[3] != oldDoc.Content.Pages[2] {
+		t.Errorf("page UUIDs %v not carried over from %v", res.Content.Pages, oldDoc.Content.Pages)
+	}
+	if data := res.PageFiles[document.RmFile(document.PageKey{Index: 2})]; string(data) != "two" {
+		t.Errorf("annotations of page 2 not moved to page 3")
+	}
+	if data := res.PageFiles[document.RmFile(document.PageKey{Uuid: oldDoc.Content.Pages[2]})]; string(data) != "three" {
+		t.Errorf("annotations of page 3 not kept by UUID")
+	}
+
+	if _, ok := EditLogOf(res.Content); ok {
+		t.Errorf("rebased document has the EditLog of the old document")
+	}
+	if _, ok := MergeLogOf(res.Content); ok {
+		t.Errorf("rebased document has the MergeLog of the old document")
+	}
+	if res.Content.DocumentMetadata["title"] != "Slides" {
+		t.Errorf("got documentMetadata %v, want the title kept", res.Content.DocumentMetadata)
+	}
+	if _, ok := EditLogOf(oldDoc.Content); !ok {
+		t.Errorf("documentMetadata of the old document was changed")
+	}
+}
+
+func TestIsPristine(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(pdfDoc *document.PdfDocument)
+		want   bool
+	}{
+		{"uploaded", func(pdfDoc *document.PdfDocument) {}, true},
+		{"annotated", func(pdfDoc *document.PdfDocument) {
+			pdfDoc.PageFiles[document.RmFile(document.PageKey{Index: 0})] = []byte("rm")
+		}, false},
+		{"edited", func(pdfDoc *document.PdfDocument) {
+			pdfDoc.Content.DocumentMetadata = map[string]interface{}{editLogKey: EditLog{Original: "a"}}
+		}, false},
+		{"merged", func(pdfDoc *document.PdfDocument) {
+			pdfDoc.Content.DocumentMetadata = map[string]interface{}{mergeLogKey: MergeLog{Sources: []MergeSource{{Uuid: "a"}}}}
+		}, false},
+		{"notebook", func(pdfDoc *document.PdfDocument) {
+			pdfDoc.Content.FileType = "notebook"
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pdfDoc := testPdfDoc(t, "one")
+			tt.modify(&pdfDoc)
+			fileName := filepath.Join(t.TempDir(), "doc.zip")
+			if err := pdfDoc.WriteToFile(fileName); err != nil {
+				t.Fatal(err)
+			}
+			pristine, err := IsPristine(fileName, pdfDoc.Uuid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pristine != tt.want {
+				t.Errorf("got %v, want %v", pristine, tt.want)
+			}
+		})
+	}
+}
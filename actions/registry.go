@@ -0,0 +1,99 @@
+package actions
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Parser parses a single action of a comma-separated list of actions. It returns false if s doesn't have its syntax,
+// and an *ActionError if s has its syntax but is malformed. A Parser must not claim tokens of other syntaxes, so that
+// parsers registered after it get to parse them.
+type Parser func(s string) (Action, bool, error)
+
+type registeredParser struct {
+	syntax string
+	parse  Parser
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []registeredParser
+)
+
+// Register makes an action available to FromString. syntax describes the action's syntax, e.g. "XaY", and is shown
+// when an action can't be parsed. Parsers are tried in the order they were registered, so packages adding actions
+// should register them in an init function.
+func Register(syntax string, parse Parser) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, registeredParser{syntax: syntax, parse: parse})
+}
+
+// parse parses s with the first registered parser accepting its syntax.
+func parse(s string) (Action, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	syntaxes := make([]string, len(registry))
+	for i, p := range registry {
+		a, ok, err := p.parse(s)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return a, nil
+		}
+		syntaxes[i] = p.syntax
+	}
+
+	msg := "expected " + syntaxes[len(syntaxes)-1]
+	if len(syntaxes) > 1 {
+		msg = "expected " + strings.Join(syntaxes[:len(syntaxes)-1], ", ") + " or " + syntaxes[len(syntaxes)-1]
+	}
+	return nil, &ActionError{Action: s, Msg: msg}
+}
+
+func init() {
+	Register("XaY", parseInsert("a", true))
+	Register("XbY", parseInsert("b", false))
+	Register("-Y", parseDelete)
+}
+
+var (
+	insertSyntax = regexp.MustCompile(`^(\d+)([ab])(\d+)$`)
+	deleteSyntax = regexp.MustCompile(`^-(\d+)$`)
+)
+
+// parseInsert returns the Parser of XaY (insert X pages after page Y) if sep is "a", or XbY if sep is "b".
+func parseInsert(sep string, insertAfter bool) Parser {
+	return func(s string) (Action, bool, error) {
+		args := insertSyntax.FindStringSubmatch(s)
+		if args == nil || args[2] != sep {
+			return nil, false, nil
+		}
+		count, err := strconv.Atoi(args[1])
+		if err != nil {
+			return nil, true, &ActionError{Action: s, Msg: "page count is out of range"}
+		}
+		pageNo, err := strconv.Atoi(args[3])
+		if err != nil {
+			return nil, true, &ActionError{Action: s, Msg: "page number is out of range"}
+		}
+		return Insert{Count: count, PageNo: pageNo, InsertAfter: insertAfter}, true, nil
+	}
+}
+
+// parseDelete parses -Y (delete page Y).
+func parseDelete(s string) (Action, bool, error) {
+	args := deleteSyntax.FindStringSubmatch(s)
+	if args == nil {
+		return nil, false, nil
+	}
+	pageNo, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, true, &ActionError{Action: s, Msg: "page number is out of range"}
+	}
+	return Delete{Count: 1, PageNo: pageNo}, true, nil
+}
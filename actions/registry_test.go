@@ -0,0 +1,139 @@
+package actions_test
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/skius/rm-pdf-tools/actions"
+)
+
+// swap swaps page PageNo with the page after it, it is registered like an action of another package would be.
+type swap struct {
+	PageNo int
+}
+
+func (s swap) Page() int {
+	return s.PageNo
+}
+
+func (s swap) Apply(pages *actions.PageMap) {
+	idx := pages.Index(s.PageNo - 1)
+	if idx == -1 {
+		return
+	}
+	pages.Move(idx, idx+1)
+}
+
+func (s swap) String() string {
+	return fmt.Sprintf("swap%d", s.PageNo)
+}
+
+var swapSyntax = regexp.MustCompile(`^swap(\d+)$`)
+
+func init() {
+	// "swap" contains an "a", it must not be taken for XaY
+	actions.Register("swapY", func(s string) (actions.Action, bool, error) {
+		args := swapSyntax.FindStringSubmatch(s)
+		if args == nil {
+			return nil, false, nil
+		}
+		pageNo, err := strconv.Atoi(args[1])
+		if err != nil {
+			return nil, true, &actions.ActionError{Action: s, Msg: "page number is out of range"}
+		}
+		return swap{PageNo: pageNo}, true, nil
+	})
+}
+
+func TestFromString(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		// pages are the original page numbers of the resulting pages, 0 for new pages, of a document with 4 pages
+		pages []int
+	}{
+		{"2a1", "2a1", []int{1, 0, 0, 2, 3, 4}},
+		{"1b3", "1b3", []int{1, 2, 0, 3, 4}},
+		{"-2", "-2", []int{1, 3, 4}},
+		{"1a1,-3", "1a1,-3", []int{1, 0, 2, 4}},
+		{"swap2", "swap2", []int{1, 3, 2, 4}},
+		{"swap4", "swap4", []int{1, 2, 3, 4}},
+		{"swap1,-4,1b3", "swap1,-4,1b3", []int{2, 1, 0, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			acts, err := actions.FromString(tt.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := actions.ToString(acts); got != tt.want {
+				t.Errorf("ToString is %q, want %q", got, tt.want)
+			}
+			pages := actions.Run(4, acts)
+			got := make([]int, len(pages))
+			for i, p := range pages {
+				got[i] = p.Original + 1
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.pages) {
+				t.Errorf("pages %v, want %v", got, tt.pages)
+			}
+		})
+	}
+}
+
+func TestFromStringInvalid(t *testing.T) {
+	tests := []struct {
+		s   string
+		msg string
+	}{
+		{"2a", "expected XaY, XbY, -Y or swapY"},
+		{"a1", "expected XaY, XbY, -Y or swapY"},
+		{"2a1a3", "expected XaY, XbY, -Y or swapY"},
+		{"-x", "expected XaY, XbY, -Y or swapY"},
+		{"swap", "expected XaY, XbY, -Y or swapY"},
+		{"1a99999999999999999999", "page number is out of range"},
+		{"-99999999999999999999", "page number is out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			_, err := actions.FromString(tt.s)
+			var actionErr *actions.ActionError
+			if !errors.As(err, &actionErr) {
+				t.Fatalf("got error %v, want an *actions.ActionError", err)
+			}
+			if actionErr.Msg != tt.msg {
+				t.Errorf("got message %q, want %q", actionErr.Msg, tt.msg)
+			}
+		})
+	}
+}
+
+func TestPageMapMove(t *testing.T) {
+	tests := []struct {
+		from, to int
+		// pages are the original page numbers of the pages of a document with 4 pages afterwards
+		pages []int
+	}{
+		{0, 2, []int{2, 3, 1, 4}},
+		{3, 0, []int{4, 1, 2, 3}},
+		{1, 1, []int{1, 2, 3, 4}},
+		{2, 4, []int{1, 2, 3, 4}},
+		{-1, 0, []int{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d to %d", tt.from, tt.to), func(t *testing.T) {
+			m := actions.NewPageMap(4)
+			m.Move(tt.from, tt.to)
+			got := make([]int, 0)
+			for _, p := range m.Pages() {
+				got = append(got, p.Original+1)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.pages) {
+				t.Errorf("pages %v, want %v", got, tt.pages)
+			}
+		})
+	}
+}
@@ -1,7 +1,114 @@
 package actions
 
+import (
+	"fmt"
+	"github.com/skius/rm-pdf-tools/document"
+	"strings"
+)
+
+// Action is an edit of the pages of a document. Actions are applied in descending order of their page, so that the
+// pages before an action's page are still at their original index when it is applied. Register an action's syntax with
+// Register to make it available to FromString.
 type Action interface {
+	// Page is the page number of the original document the action refers to. No two actions may refer to the same page.
 	Page() int
+	// Apply applies the action to the pages of the resulting document.
+	Apply(pages *PageMap)
+	// String is the syntax of the action as parsed by FromString.
+	String() string
+}
+
+// MappedPage is a page of the resulting document.
+type MappedPage struct {
+	// Original is the index of the page in the original document, or -1 if the page is new.
+	Original int
+	// New describes the page if it is new.
+	New NewPage
+}
+
+// NewPage describes a page created by an action, it has no annotations.
+type NewPage struct {
+	// Like is the index of the original page whose size the new, blank PDF page takes.
+	Like int
+	// Template is the pagedata of the new page, i.e. the name of its template.
+	Template string
+}
+
+// PageMap maps the pages of the resulting document to the pages of the original document. Actions edit it, and
+// the PDF, pagedata, content and page files of the result are derived from it.
+type PageMap struct {
+	pages []MappedPage
+}
+
+// NewPageMap returns the PageMap of a document with pageCount pages which are all kept.
+func NewPageMap(pageCount int) *PageMap {
+	pages := make([]MappedPage, pageCount)
+	for i := range pages {
+		pages[i] = MappedPage{Original: i}
+	}
+	return &PageMap{pages: pages}
+}
+
+// Pages returns the pages of the resulting document.
+func (m *PageMap) Pages() []MappedPage {
+	return m.pages
+}
+
+// Index returns the current index of the original page with index original, or -1 if it is deleted or doesn't exist.
+func (m *PageMap) Index(original int) int {
+	for i, p := range m.pages {
+		if p.Original == original {
+			return i
+		}
+	}
+	return -1
+}
+
+// Delete deletes count pages starting at index idx.
+func (m *PageMap) Delete(idx, count int) {
+	if idx < 0 || idx >= len(m.pages) {
+		return
+	}
+	if idx+count > len(m.pages) {
+		count = len(m.pages) - idx
+	}
+	m.pages = append(m.pages[:idx], m.pages[idx+count:]...)
+}
+
+// Insert inserts new pages at index idx, i.e. before the page currently at idx.
+func (m *PageMap) Insert(idx int, newPages ...NewPage) {
+	if idx < 0 || idx > len(m.pages) {
+		return
+	}
+	inserted := make([]MappedPage, len(newPages))
+	for i, np := range newPages {
+		inserted[i] = MappedPage{Original: -1, New: np}
+	}
+	res := make([]MappedPage, 0, len(m.pages)+len(inserted))
+	res = append(res, m.pages[:idx]...)
+	res = append(res, inserted...)
+	m.pages = append(res, m.pages[idx:]...)
+}
+
+// Move moves the page at index from to index to, the pages in between shift by one.
+func (m *PageMap) Move(from, to int) {
+	if from < 0 || from >= len(m.pages) || to < 0 || to >= len(m.pages) {
+		return
+	}
+	p := m.pages[from]
+	m.pages = append(m.pages[:from], m.pages[from+1:]...)
+	m.pages = append(m.pages[:to], append([]MappedPage{p}, m.pages[to:]...)...)
+}
+
+// Run returns the pages of the result of applying actions to a document with pageCount pages.
+func Run(pageCount int, actions []Action) []MappedPage {
+	Sort(actions)
+
+	m := NewPageMap(pageCount)
+	for i := len(actions)-1; i >= 0; i-- {
+		actions[i].Apply(m)
+	}
+	return m.Pages()
 }
 
 type T []Action
@@ -13,6 +120,16 @@ type Delete struct {
 func (d Delete) Page() int {
 	return d.PageNo
 }
+func (d Delete) Apply(pages *PageMap) {
+	pages.Delete(pages.Index(d.PageNo - 1), d.Count)
+}
+func (d Delete) String() string {
+	pages := make([]string, d.Count)
+	for i := range pages {
+		pages[i] = fmt.Sprintf("-%d", d.PageNo+i)
+	}
+	return strings.Join(pages, ",")
+}
 
 type Insert struct {
 	Count int
@@ -22,6 +139,32 @@ type Insert struct {
 func (i Insert) Page() int {
 	return i.PageNo
 }
+func (i Insert) Apply(pages *PageMap) {
+	idx := pages.Index(i.PageNo - 1)
+	if idx == -1 {
+		return
+	}
+	if i.InsertAfter {
+		idx++
+	}
+	newPages := make([]NewPage, i.Count)
+	for k := range newPages {
+		newPages[k] = NewPage{Like: i.PageNo - 1, Template: "Blank"}
+	}
+	pages.Insert(idx, newPages...)
+}
+func (i Insert) String() string {
+	if i.InsertAfter {
+		return fmt.Sprintf("%da%d", i.Count, i.PageNo)
+	}
+	return fmt.Sprintf("%db%d", i.Count, i.PageNo)
+}
+
+type PageFileReplacement struct {
+	Original document.PageFile
+	New document.PageFile
+	Deleted bool
+}
 
 type PageReplacement struct {
 	OriginalIdx int
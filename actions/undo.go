@@ -0,0 +1,275 @@
+package actions
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/skius/rm-pdf-tools/document"
+	"strings"
+)
+
+// editLogKey is the key of the EditLog in the documentMetadata of the content of a processed document.
+const editLogKey = "rmPdfToolsEdit"
+
+// EditLog records the edit RunZip applied to a document, so that it can be undone.
+type EditLog struct {
+	Actions string `json:"actions"`
+	// Original is the UUID of the document the edit was applied to.
+	Original string `json:"original"`
+	// Pages holds the new index of every page of the original document, or -1 if the page was deleted.
+	Pages []int `json:"pages"`
+}
+
+// UndoReport describes how an edit was undone.
+type UndoReport struct {
+	// Restored are the indices of the deleted pages of the original document which were restored.
+	Restored []int
+	// Dropped are the indices of the pages of the edited document which are not part of the original, e.g. inserted pages.
+	Dropped []int
+	// DroppedAnnotated are the indices of Dropped pages which had annotations.
+	DroppedAnnotated []int
+}
+
+func (r UndoReport) String() string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "%d pages restored, %d pages dropped\n", len(r.Restored), len(r.Dropped))
+	for _, idx := range r.DroppedAnnotated {
+		fmt.Fprintf(&sb, "annotated page %d was dropped\n", idx+1)
+	}
+	return sb.String()
+}
+
+// recordEdit adds the EditLog of applying actions to the document uuidOriginal with pageCount pages to contentStr.
+func recordEdit(contentStr, uuidOriginal string, pageCount int, actions []Action) (string, error) {
+	content, err := parseContent([]byte(contentStr))
+	if err != nil {
+		return "", err
+	}
+
+	indices := make([]int, pageCount)
+	for i := range indices {
+		indices[i] = i
+	}
+	repls := RunPageIndices(indices, actions)
+	log := EditLog{Actions: ToString(actions), Original: uuidOriginal, Pages: make([]int, pageCount)}
+	for i := range log.Pages {
+		log.Pages[i] = repls[i].NewIdx
+		if repls[i].Deleted {
+			log.Pages[i] = -1
+		}
+	}
+
+	if content.DocumentMetadata == nil {
+		content.DocumentMetadata = make(map[string]interface{})
+	}
+	content.DocumentMetadata[editLogKey] = log
+
+	res, err := json.Marshal(&content)
+	if err != nil {
+		return "", err
+	}
+	return string(res), nil
+}
+
+// EditLogOf returns the EditLog of content, or false if content is not the result of an edit.
+func EditLogOf(content document.Content) (EditLog, bool) {
+	log := EditLog{}
+	v, ok := content.DocumentMetadata[editLogKey]
+	if !ok {
+		return log, false
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return log, false
+	}
+	err = json.Unmarshal(data, &log)
+	return log, err == nil && log.Original != ""
+}
+
+// ReadEditLog reads the EditLog of the document uuid stored in fileName, or returns false if it is not the result of an edit.
+func ReadEditLog(fileName, uuid string) (EditLog, bool, error) {
+	r, err := zip.OpenReader(fileName)
+	if err != nil {
+		return EditLog{}, false, &document.DocumentError{Op: "open document", Err: err}
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if document.ClassifyEntry(f.Name, uuid).Kind == document.EntryContent {
+			data, err := readZipEntry(f)
+			if err != nil {
+				return EditLog{}, false, err
+			}
+			content, err := parseContent(data)
+			if err != nil {
+				return EditLog{}, false, err
+			}
+			log, ok := EditLogOf(content)
+			return log, ok, nil
+		}
+	}
+	return EditLog{}, false, nil
+}
+
+// UndoFile undoes the edit which produced the document in fileNameEdited, using the original document in
+// fileNameOriginal, and writes the result to fileNameProcessed. See Undo.
+// The result is validated, a *document.ValidationError is returned if it is invalid.
+func UndoFile(env *document.Env, uuidEdited, fileNameEdited, uuidOriginal, fileNameOriginal, fileNameProcessed string) (UndoReport, error) {
+	edited, err := document.FromZipFile(env, fileNameEdited, uuidEdited)
+	if err != nil {
+		return UndoReport{}, err
+	}
+	defer func() { edited.Close() }()
+	original, err := document.FromZipFile(env, fileNameOriginal, uuidOriginal)
+	if err != nil {
+		return UndoReport{}, err
+	}
+	defer func() { original.Close() }()
+
+	// Notebooks stay notebooks, unless the other side is a PDF
+	if (edited.Pdf == nil) != (original.Pdf == nil) {
+		for _, pdfDoc := range []*document.PdfDocument{&edited, &original} {
+			if pdfDoc.Pdf != nil {
+				continue
+			}
+			env.Logf("Filetype is not PDF! Creating PDF pages")
+			converted, err := pdfDoc.ToPdfDoc(env)
+			if err != nil {
+				return UndoReport{}, err
+			}
+			*pdfDoc = converted
+		}
+	}
+
+	log, ok := EditLogOf(edited.Content)
+	if !ok {
+		return UndoReport{}, &document.DocumentError{Op: "undo", Err: fmt.Errorf("document %s is not the result of an edit", uuidEdited)}
+	}
+	if log.Original != uuidOriginal || len(log.Pages) != original.Content.PageCount {
+		return UndoReport{}, &document.DocumentError{Op: "undo", Err: fmt.Errorf("document %s is not the original of document %s", uuidOriginal, uuidEdited)}
+	}
+
+	res, report, err := Undo(env, edited, original, log)
+	if err != nil {
+		return report, err
+	}
+	defer res.Close()
+	err = res.WriteToFile(fileNameProcessed)
+	if err != nil {
+		return report, err
+	}
+	return report, document.Validate(env, fileNameProcessed)
+}
+
+// Undo inverts the edit described by log, which turned original into edited. The pages of original are restored in
+// their original order: pages deleted by the edit are taken from original, all others from edited together with the
+// annotations made since the edit. Pages of edited which are not part of original, e.g. inserted pages, are dropped.
+// Either both or neither of edited and original must have a PDF, the result is a notebook if neither has.
+func Undo(env *document.Env, edited, original document.PdfDocument, log EditLog) (document.PdfDocument, UndoReport, error) {
+	report := UndoReport{}
+
+	editedIdx := make(map[string]int)
+	for j, pageUuid := range edited.Content.Pages {
+		editedIdx[pageUuid] = j
+	}
+
+	// New index of the pages taken from original and edited respectively
+	fromOriginal := make(map[int]int)
+	fromEdited := make(map[int]int)
+	pages := make([]document.PdfPage, 0)
+	res := document.PdfDocument{}
+	res.Uuid = uuid.New().String()
+	res.Format = edited.Format
+	res.Metadata = edited.Metadata
+	res.Content = edited.Content
+	res.Content.Pages = make([]string, 0)
+	res.Pagedata = make([]string, 0)
+	for i, pageUuid := range original.Content.Pages {
+		if log.Pages[i] == -1 {
+			report.Restored = append(report.Restored, i)
+			fromOriginal[i] = len(res.Content.Pages)
+			pages = append(pages, document.PdfPage{SourcePage: i + 1})
+			res.Pagedata = append(res.Pagedata, pagedataLine(original.Pagedata, i))
+		} else if j, ok := editedIdx[pageUuid]; ok {
+			fromEdited[j] = len(res.Content.Pages)
+			pages = append(pages, document.PdfPage{SourcePage: original.Content.PageCount + j + 1})
+			res.Pagedata = append(res.Pagedata, pagedataLine(edited.Pagedata, j))
+		} else {
+			// The page was deleted after the edit
+			continue
+		}
+		res.Content.Pages = append(res.Content.Pages, pageUuid)
+	}
+	res.Content.PageCount = len(res.Content.Pages)
+
+	annotated := make(map[int]bool)
+	for pf := range edited.PageFiles {
+		annotated[pageIdxOf(pf, edited.Content.Pages)] = true
+	}
+	for j := range edited.Content.Pages {
+		if _, ok := fromEdited[j]; !ok {
+			report.Dropped = append(report.Dropped, j)
+			if annotated[j] {
+				report.DroppedAnnotated = append(report.DroppedAnnotated, j)
+			}
+		}
+	}
+
+	res.PageFiles = make(map[document.PageFile][]byte)
+	for _, src := range []struct {
+		doc    document.PdfDocument
+		newIdx map[int]int
+	}{{original, fromOriginal}, {edited, fromEdited}} {
+		for pf, data := range src.doc.PageFiles {
+			newIdx, ok := src.newIdx[pageIdxOf(pf, src.doc.Content.Pages)]
+			if !ok {
+				continue
+			}
+			if !pf.Key.IsUuid() {
+				pf = pf.WithKey(document.PageKey{Index: newIdx})
+			}
+			res.PageFiles[pf] = data
+		}
+	}
+
+	// The original's own edit log and merge log, if any, apply again, as the pages are those of the original
+	res.Content.DocumentMetadata = make(map[string]interface{})
+	for k, v := range edited.Content.DocumentMetadata {
+		res.Content.DocumentMetadata[k] = v
+	}
+	for _, key := range []string{editLogKey, mergeLogKey} {
+		delete(res.Content.DocumentMetadata, key)
+		if v, ok := original.Content.DocumentMetadata[key]; ok {
+			res.Content.DocumentMetadata[key] = v
+		}
+	}
+
+	if edited.Pdf == nil {
+		return res, report, nil
+	}
+	// Both PDFs are versions of the same PDF, so their pages are not scaled like for a merge
+	combined, err := concatPdfs(env, []*document.Blob{original.Pdf, edited.Pdf})
+	if err != nil {
+		return res, report, err
+	}
+	defer combined.Close()
+	res.Pdf = env.NewBlob()
+	err = document.RearrangePdf(combined.Reader(), res.Pdf, func(int) ([]document.PdfPage, error) {
+		return pages, nil
+	})
+	if err != nil {
+		res.Close()
+		return res, report, err
+	}
+
+	return res, report, nil
+}
+
+// pagedataLine returns line idx of pagedata, or "Blank" if there is none.
+func pagedataLine(pagedata []string, idx int) string {
+	if idx < len(pagedata) {
+		return pagedata[idx]
+	}
+	return "Blank"
+}
@@ -1,142 +1,112 @@
 package actions
 
 import (
+	"archive/zip"
+	"encoding/json"
 	"fmt"
-	"strconv"
+	"github.com/skius/rm-pdf-tools/document"
+	"io"
 	"strings"
 )
 
-func FromString(s string) []Action {
+// FromString parses a comma-separated list of actions, e.g. "2a1,-3", see Register.
+func FromString(s string) ([]Action, error) {
 	actions := make([]Action, 0)
 
 	actionStrs := strings.Split(s, ",")
 
 	for _, actionStr := range actionStrs {
-		if strings.HasPrefix(actionStr, "-") {
-			pageNo, err := strconv.Atoi(actionStr[1:])
-			if err != nil {
-				panic(err)
-			}
-			actions = append(actions, Delete{Count: 1, PageNo: pageNo})
-		} else if strings.Contains(actionStr, "a") {
-			// XaY => Insert{X, Y, true}
-			args := strings.Split(actionStr, "a")
-			count, err := strconv.Atoi(args[0])
-			if err != nil {
-				panic(err)
-			}
-			pageNo, err := strconv.Atoi(args[1])
-			if err != nil {
-				panic(err)
-			}
-
-			actions = append(actions, Insert{Count: count, PageNo: pageNo, InsertAfter: true})
-		} else if strings.Contains(actionStr, "b") {
-			// XbY => Insert{X, Y, false}
-			args := strings.Split(actionStr, "b")
-			count, err := strconv.Atoi(args[0])
-			if err != nil {
-				panic(err)
-			}
-			pageNo, err := strconv.Atoi(args[1])
-			if err != nil {
-				panic(err)
-			}
-
-			actions = append(actions, Insert{Count: count, PageNo: pageNo, InsertAfter: false})
+		action, err := parse(actionStr)
+		if err != nil {
+			return nil, err
 		}
+		actions = append(actions, action)
 	}
 
-	checkActions(actions)
-	return actions
+	err := checkActions(actions)
+	if err != nil {
+		return nil, err
+	}
+	return actions, nil
 }
 
-func checkActions(actions []Action) {
+// ToString is the inverse of FromString.
+func ToString(actions []Action) string {
+	actionStrs := make([]string, len(actions))
+	for i, a := range actions {
+		actionStrs[i] = fmt.Sprint(a)
+	}
+	return strings.Join(actionStrs, ",")
+}
+
+func checkActions(actions []Action) error {
 	seen := make(map[int]struct{})
 	for _, a := range actions {
 		if _, ok := seen[a.Page()]; ok {
-			panic(fmt.Sprintf("Page %i occurs more than once!", a.Page()))
+			return &ActionError{Action: fmt.Sprint(a), Msg: fmt.Sprintf("page %d occurs more than once", a.Page())}
 		}
 
 		seen[a.Page()] = struct{}{}
 	}
+	return nil
 }
 
-func runSlice(arr []interface{}, actions []Action, defaultCreator func() interface{}) []interface{} {
-	Sort(actions)
-
-	for i := len(actions)-1; i >= 0; i-- {
-		currActionI := actions[i]
-		switch currAction := currActionI.(type) {
-		case Delete:
-			pageIdx := currAction.PageNo - 1
-			arr = append(arr[:pageIdx], arr[pageIdx + currAction.Count:]...)
-		case Insert:
-			pageIdx := currAction.PageNo - 1
-
-			// make copy of arrPre, because appending causes issues due to aliasing
-			arrPre := make([]interface{}, pageIdx+1)
-			copy(arrPre, arr[:pageIdx+1])
-			//arrPre := arr[:pageIdx+1]
-			arrPost := arr[pageIdx+1:]
-			if !currAction.InsertAfter {
-				arrPre = make([]interface{}, pageIdx)
-				copy(arrPre, arr[:pageIdx])
-				//arrPre = arr[:pageIdx]
-				arrPost = arr[pageIdx:]
-			}
-
-			arrMid := make([]interface{}, 0)
-
-			//fmt.Println("Processing insert action, arr:", arr)
-			for c := 0; c < currAction.Count; c++ {
-				arrMid = append(arrMid, defaultCreator())
-				//fmt.Println("arrMid", arrMid)
-			}
-
-			//fmt.Println("after loop: arrPre:", arrPre, "arrMid:", arrMid, "arrPost:", arrPost)
-			arr = append(arrPre, arrMid...)
-			arr = append(arr, arrPost...)
-			//fmt.Println("arr final", arr)
-
+// checkPageRange checks that all actions refer to pages of a document with pageCount pages.
+func checkPageRange(actions []Action, pageCount int) error {
+	for _, a := range actions {
+		if a.Page() < 1 || a.Page() > pageCount {
+			return &ActionError{Action: fmt.Sprint(a), Msg: fmt.Sprintf("page %d does not exist, the document has %d pages", a.Page(), pageCount)}
 		}
 	}
+	return nil
+}
 
-	return arr
+// readZipEntry reads the whole content of f, only use this for small files.
+func readZipEntry(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, &document.DocumentError{Op: "read " + f.Name, Err: err}
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, &document.DocumentError{Op: "read " + f.Name, Err: err}
+	}
+	return data, nil
 }
 
-func anySliceToStringSlice(arrI []interface{}) []string {
-	arr := make([]string, len(arrI))
-	for i := range arrI {
-		arr[i] = arrI[i].(string)
+func writeZipEntry(w *zip.Writer, name string, data []byte) error {
+	fw, err := w.Create(name)
+	if err != nil {
+		return err
 	}
-	return arr
+	_, err = fw.Write(data)
+	return err
 }
 
-func stringSliceToAnySlice(arr []string) []interface{} {
-	arrI := make([]interface{}, len(arr))
-	for i := range arr {
-		arrI[i] = arr[i]
+// copyZipEntry copies f to w as name without decompressing it.
+func copyZipEntry(w *zip.Writer, f *zip.File, name string) error {
+	fh := f.FileHeader
+	fh.Name = name
+	fw, err := w.CreateRaw(&fh)
+	if err != nil {
+		return err
 	}
-	return arrI
+	rc, err := f.OpenRaw()
+	if err != nil {
+		return &document.DocumentError{Op: "read " + f.Name, Err: err}
+	}
+	_, err = io.Copy(fw, rc)
+	return err
 }
 
-func getIdxFromFileName(name string) int {
-	if strings.Contains(name, "-") {
-		// xxx-metadata.json
-		parts := strings.Split(name, "-")
-		idx, err := strconv.Atoi(parts[0])
-		if err != nil {
-			panic(err)
-		}
-		return idx
-	} else {
-		// xxx.rm
-		idxStr := name[:len(name)-3]
-		idx, err := strconv.Atoi(idxStr)
-		if err != nil {
-			panic(err)
-		}
-		return idx
+// parseContent parses a content JSON. The pages of .rmdoc content are taken from its cPages.
+func parseContent(contentData []byte) (document.Content, error) {
+	content := document.Content{}
+	err := json.Unmarshal(contentData, &content)
+	if err != nil {
+		return content, &document.DocumentError{Op: "parse content", Err: err}
 	}
+	content.UseCPages()
+	return content, nil
 }
@@ -0,0 +1,95 @@
+package cloud
+
+import (
+	"fmt"
+	"github.com/juruen/rmapi/model"
+	"sort"
+	"strings"
+)
+
+// Backend stores documents and folders like the reMarkable cloud. Paths are absolute and use "/", e.g.
+// "/pdf-tools/merge/", and files and folders are nodes of rmapi's file tree. Errors are *CloudError.
+type Backend interface {
+	// FindFile finds a file or folder by path.
+	FindFile(path string) (*model.Node, error)
+	// FindFileById finds a file or folder by its UUID.
+	FindFileById(id string) (*model.Node, error)
+	// Children returns the files and folders in the folder path.
+	Children(path string) ([]*model.Node, error)
+	// Download downloads the document node as a .zip to the file dst.
+	Download(node *model.Node, dst string) error
+	// Upload uploads the document .zip or PDF src to the folder dstPath, named like src without its extension.
+	Upload(src string, dstPath string) (*model.Node, error)
+	// Move moves node to `dstPath/dstName`.
+	Move(node *model.Node, dstPath, dstName string) (*model.Node, error)
+	// Rename renames node to name.
+	Rename(node *model.Node, name string) (*model.Node, error)
+	// Mkdir creates the folder path, whose parent folder must exist.
+	Mkdir(path string) (*model.Node, error)
+	// Delete deletes node, folders must be empty.
+	Delete(node *model.Node) error
+}
+
+var (
+	_ Backend = (*Cloud)(nil)
+	_ Backend = (*Local)(nil)
+)
+
+// FindNewFilesEdit provides all files in subdirectories of the provided directory.
+// e.g. FindNewFilesEdit(b, "pdf-tools") -> ["pdf-tools/sub1/file1", "pdf-tools/sub2/file2"]
+func FindNewFilesEdit(b Backend, dir string) ([]*model.Node, error) {
+	files := make([]*model.Node, 0)
+
+	nodes, err := b.Children(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes {
+		fmt.Println("UUID:", node.Id(), "name:", node.Name(), "node:", node)
+		for _, child := range children(node) {
+			fmt.Println("Inside", node.Name(), "child:", child.Name())
+			files = append(files, child)
+		}
+		fmt.Println()
+	}
+
+	return files, nil
+}
+
+// FindDirs returns all folders in the provided directory whose name starts with prefix.
+func FindDirs(b Backend, dir, prefix string) ([]*model.Node, error) {
+	dirs := make([]*model.Node, 0)
+
+	nodes, err := b.Children(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes {
+		if node.IsDirectory() && strings.HasPrefix(node.Name(), prefix) {
+			dirs = append(dirs, node)
+		}
+	}
+
+	return dirs, nil
+}
+
+// children returns the children of node, ordered by name.
+func children(node *model.Node) []*model.Node {
+	res := make([]*model.Node, 0, len(node.Children))
+	for _, child := range node.Children {
+		res = append(res, child)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name() < res[j].Name()
+	})
+	return res
+}
+
+// splitPath splits path into the path of its parent folder and its name.
+func splitPath(path string) (string, string) {
+	path = strings.TrimSuffix(path, "/")
+	i := strings.LastIndex(path, "/")
+	return path[:i+1], path[i+1:]
+}
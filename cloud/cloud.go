@@ -2,7 +2,6 @@ package cloud
 
 import (
 	"errors"
-	"fmt"
 	"github.com/juruen/rmapi/api"
 	"github.com/juruen/rmapi/log"
 	"github.com/juruen/rmapi/model"
@@ -25,11 +24,11 @@ func New() (*Cloud, error) {
 
 	rm, sync15, err = api.CreateApiCtx(api.AuthHttpCtx(true, false))
 	if err != nil {
-		panic(err)
+		return nil, cloudError("connect", err)
 	}
 
-	if rm.Filetree == nil {
-		return nil, errors.New("failed to build remarkable documents tree")
+	if rm.Filetree() == nil {
+		return nil, cloudError("connect", errors.New("failed to build remarkable documents tree"))
 	}
 
 	return &Cloud{api: rm, sync15: sync15}, nil
@@ -37,71 +36,93 @@ func New() (*Cloud, error) {
 
 // Download downloads node to the file dst.
 func (r *Cloud) Download(node *model.Node, dst string) error {
-	return r.api.FetchDocument(node.Id(), dst)
+	return cloudError("download "+node.Name(), r.api.FetchDocument(node.Id(), dst))
 }
 
 // Upload uploads the file src to folder dstPath in the cloud.
-func (r *Cloud) Upload(src string, dstPath string) (*model.Document, error) {
+func (r *Cloud) Upload(src string, dstPath string) (*model.Node, error) {
 	dstNode, err := r.api.Filetree().NodeByPath(dstPath, r.api.Filetree().Root())
 	if err != nil {
-		return nil, err
+		return nil, notFoundError("upload "+src, dstPath)
 	}
 	doc, err := r.api.UploadDocument(dstNode.Id(), src)
 	if err != nil {
-		return nil, err
+		return nil, cloudError("upload "+src, err)
 	}
 	r.api.Filetree().AddDocument(doc)
-	return doc, nil
+	return r.api.Filetree().NodeById(doc.ID), nil
 }
 
 // Move moves node to `dstPath/dstName`.
 func (r *Cloud) Move(node *model.Node, dstPath, dstName string) (*model.Node, error) {
 	dstNode, err := r.api.Filetree().NodeByPath(dstPath, r.api.Filetree().Root())
 	if err != nil {
-		return nil, err
+		return nil, notFoundError("move "+node.Name(), dstPath)
 	}
-	return r.api.MoveEntry(node, dstNode, dstName)
+	moved, err := r.api.MoveEntry(node, dstNode, dstName)
+	if err != nil {
+		return nil, cloudError("move "+node.Name(), err)
+	}
+	return moved, nil
 }
 
-// FindFile finds a file in the cloud by path and returns the associated Node.
-func (r *Cloud) FindFile(path string) (*model.Node, error) {
-	return r.api.Filetree().NodeByPath(path, r.api.Filetree().Root())
+// Rename renames node to name.
+func (r *Cloud) Rename(node *model.Node, name string) (*model.Node, error) {
+	renamed, err := r.api.MoveEntry(node, node.Parent, name)
+	if err != nil {
+		return nil, cloudError("rename "+node.Name(), err)
+	}
+	return renamed, nil
 }
 
-// FindNewFilesEdit provides all files in subdirectories of the provided directory.
-// e.g. FindNewFilesEdit("pdf-tools") -> ["pdf-tools/sub1/file1", "pdf-tools/sub2/file2"]
-func (r *Cloud) FindNewFilesEdit(dir string) []*model.Node {
-	files := make([]*model.Node, 0)
-
-	dirNode, err := r.api.Filetree().NodeByPath(dir, r.api.Filetree().Root())
+// Mkdir creates the folder path, whose parent folder must exist.
+func (r *Cloud) Mkdir(path string) (*model.Node, error) {
+	parentPath, name := splitPath(path)
+	parent, err := r.api.Filetree().NodeByPath(parentPath, r.api.Filetree().Root())
 	if err != nil {
-		panic(err)
+		return nil, notFoundError("mkdir "+path, parentPath)
 	}
-
-	for uuid, node := range dirNode.Children {
-		fmt.Println("UUID:", uuid, "name:", node.Name(),"node:", node)
-		for _, child := range node.Children {
-			fmt.Println("Inside", node.Name(), "child:", child.Name())
-			files = append(files, child)
-		}
-		fmt.Println()
+	doc, err := r.api.CreateDir(parent.Id(), name)
+	if err != nil {
+		return nil, cloudError("mkdir "+path, err)
 	}
-
-	return files
+	r.api.Filetree().AddDocument(doc)
+	return r.api.Filetree().NodeById(doc.ID), nil
 }
 
-// FindNewFilesMerge returns all files in the provided directory.
-func (r *Cloud) FindNewFilesMerge(dir string) []*model.Node {
-	files := make([]*model.Node, 0)
+// Delete deletes node, folders must be empty.
+func (r *Cloud) Delete(node *model.Node) error {
+	err := r.api.DeleteEntry(node)
+	if err != nil {
+		return cloudError("delete "+node.Name(), err)
+	}
+	r.api.Filetree().DeleteNode(node)
+	return nil
+}
 
-	dirNode, err := r.api.Filetree().NodeByPath(dir, r.api.Filetree().Root())
+// FindFile finds a file in the cloud by path and returns the associated Node.
+func (r *Cloud) FindFile(path string) (*model.Node, error) {
+	node, err := r.api.Filetree().NodeByPath(path, r.api.Filetree().Root())
 	if err != nil {
-		return nil
+		return nil, notFoundError("find", path)
 	}
+	return node, nil
+}
 
-	for _, node := range dirNode.Children {
-		files = append(files, node)
+// FindFileById finds a file in the cloud by its UUID and returns the associated Node.
+func (r *Cloud) FindFileById(id string) (*model.Node, error) {
+	node := r.api.Filetree().NodeById(id)
+	if node == nil {
+		return nil, notFoundError("find", id)
 	}
+	return node, nil
+}
 
-	return files
+// Children returns the files and folders in the folder path.
+func (r *Cloud) Children(path string) ([]*model.Node, error) {
+	dirNode, err := r.api.Filetree().NodeByPath(path, r.api.Filetree().Root())
+	if err != nil {
+		return nil, notFoundError("find", path)
+	}
+	return children(dirNode), nil
 }
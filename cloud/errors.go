@@ -0,0 +1,55 @@
+package cloud
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is the cause of the CloudError returned for a file or folder which doesn't exist.
+var ErrNotFound = errors.New("no such file or folder")
+
+// CloudError is returned when talking to the reMarkable cloud fails, e.g. because of network problems, or when a
+// file or folder does not exist.
+type CloudError struct {
+	// Op describes what failed, e.g. "upload merged.zip".
+	Op  string
+	Err error
+	// Temporary is set if the error is likely transient, so retrying later may help, e.g. for network problems.
+	// Missing files and folders and the errors of the Local backend are permanent.
+	Temporary bool
+}
+
+func (e *CloudError) Error() string {
+	return fmt.Sprintf("cloud: %s: %v", e.Op, e.Err)
+}
+
+func (e *CloudError) Unwrap() error {
+	return e.Err
+}
+
+// IsTemporary returns whether err is a temporary CloudError.
+func IsTemporary(err error) bool {
+	var cloudErr *CloudError
+	return errors.As(err, &cloudErr) && cloudErr.Temporary
+}
+
+// cloudError wraps err in a temporary CloudError, or returns nil if err is nil.
+func cloudError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CloudError{Op: op, Err: err, Temporary: true}
+}
+
+// permanentError wraps err in a permanent CloudError, or returns nil if err is nil.
+func permanentError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CloudError{Op: op, Err: err}
+}
+
+// notFoundError returns the permanent CloudError for the missing file or folder path.
+func notFoundError(op, path string) error {
+	return &CloudError{Op: op, Err: fmt.Errorf("%w: %s", ErrNotFound, path)}
+}
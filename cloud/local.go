@@ -0,0 +1,285 @@
+package cloud
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/juruen/rmapi/filetree"
+	"github.com/juruen/rmapi/model"
+	"github.com/skius/rm-pdf-tools/document"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local is a Backend storing the documents as .zip bundles in a local directory, as downloaded from the cloud, and
+// folders as directories, e.g. "<root>/pdf-tools/merge/Notes.zip" is the document "/pdf-tools/merge/Notes". It serves to
+// run rm-pdf-tools without the reMarkable cloud, e.g. to try out the edit and merge flows. Its errors are permanent,
+// see CloudError.
+//
+// The UUID of a document is that of its bundle, the last-modified time is that of its file. Folders get a fresh UUID
+// every time the directory is read.
+type Local struct {
+	root string
+	tree filetree.FileTreeCtx
+}
+
+// NewLocal creates a Local storing documents in the directory root and reads the documents it contains.
+func NewLocal(root string) (*Local, error) {
+	l := &Local{root: root, tree: filetree.CreateFileTreeCtx()}
+	err := l.scan(root, "")
+	if err != nil {
+		return nil, permanentError("read "+root, err)
+	}
+	return l, nil
+}
+
+// scan adds the folders and documents in the directory dir, which is the folder parentId, to the tree.
+func (l *Local) scan(dir, parentId string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fileName := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			doc := l.newDocument(uuid.New().String(), parentId, entry.Name(), model.DirectoryType, info.ModTime())
+			l.tree.AddDocument(doc)
+			err = l.scan(fileName, doc.ID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if filepath.Ext(entry.Name()) != ".zip" {
+			continue
+		}
+		id, err := bundleUuid(fileName)
+		if err != nil || l.tree.NodeById(id) != nil {
+			fmt.Println("Skipping file", fileName)
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".zip")
+		l.tree.AddDocument(l.newDocument(id, parentId, name, model.DocumentType, info.ModTime()))
+	}
+	return nil
+}
+
+func (l *Local) newDocument(id, parentId, name, docType string, modified time.Time) *model.Document {
+	return &model.Document{
+		ID:             id,
+		Parent:         parentId,
+		VissibleName:   name,
+		Type:           docType,
+		ModifiedClient: modified.UTC().Format(time.RFC3339Nano),
+		Success:        true,
+	}
+}
+
+// bundleUuid returns the UUID of the document .zip fileName.
+func bundleUuid(fileName string) (string, error) {
+	r, err := zip.OpenReader(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	id, ok := document.ZipUuid(&r.Reader)
+	if !ok {
+		return "", errors.New("not a document, missing .content file")
+	}
+	return id, nil
+}
+
+// fileName returns the file or directory of node.
+func (l *Local) fileName(node *model.Node) string {
+	parts := make([]string, 0)
+	for n := node; n != nil && !n.IsRoot(); n = n.Parent {
+		name := n.Name()
+		if !n.IsDirectory() {
+			name += ".zip"
+		}
+		parts = append([]string{name}, parts...)
+	}
+	return filepath.Join(append([]string{l.root}, parts...)...)
+}
+
+// freeName returns name, or name with a number appended if the folder dir already has an entry called like that.
+func freeName(dir *model.Node, name string) string {
+	taken := make(map[string]bool)
+	for _, child := range dir.Children {
+		taken[child.Name()] = true
+	}
+	free := name
+	for i := 2; taken[free]; i++ {
+		free = fmt.Sprintf("%s (%d)", name, i)
+	}
+	return free
+}
+
+// FindFile finds a file or folder by path.
+func (l *Local) FindFile(path string) (*model.Node, error) {
+	node, err := l.tree.NodeByPath(path, l.tree.Root())
+	if err != nil {
+		return nil, notFoundError("find", path)
+	}
+	return node, nil
+}
+
+// FindFileById finds a file or folder by its UUID.
+func (l *Local) FindFileById(id string) (*model.Node, error) {
+	node := l.tree.NodeById(id)
+	if node == nil {
+		return nil, notFoundError("find", id)
+	}
+	return node, nil
+}
+
+// Children returns the files and folders in the folder path.
+func (l *Local) Children(path string) ([]*model.Node, error) {
+	dirNode, err := l.FindFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return children(dirNode), nil
+}
+
+// Download copies the bundle of node to the file dst.
+func (l *Local) Download(node *model.Node, dst string) error {
+	return permanentError("download "+node.Name(), copyFile(l.fileName(node), dst))
+}
+
+// Upload copies the document .zip src to the folder dstPath. PDFs are converted to a document .zip with a fresh UUID.
+func (l *Local) Upload(src string, dstPath string) (*model.Node, error) {
+	dir, err := l.FindFile(dstPath)
+	if err != nil {
+		return nil, err
+	}
+	name := freeName(dir, strings.TrimSuffix(filepath.Base(src), filepath.Ext(src)))
+	dst := filepath.Join(l.fileName(dir), name+".zip")
+
+	if document.IsPdfFile(src) {
+		err = pdfToBundle(src, dst)
+	} else {
+		err = copyFile(src, dst)
+	}
+	if err != nil {
+		return nil, permanentError("upload "+src, err)
+	}
+	id, err := bundleUuid(dst)
+	if err != nil {
+		os.Remove(dst)
+		return nil, permanentError("upload "+src, err)
+	}
+	if l.tree.NodeById(id) != nil {
+		os.Remove(dst)
+		return nil, permanentError("upload "+src, fmt.Errorf("a document with UUID %s exists already", id))
+	}
+
+	l.tree.AddDocument(l.newDocument(id, dir.Id(), name, model.DocumentType, time.Now()))
+	return l.tree.NodeById(id), nil
+}
+
+// Move moves node to `dstPath/dstName`.
+func (l *Local) Move(node *model.Node, dstPath, dstName string) (*model.Node, error) {
+	dir, err := l.FindFile(dstPath)
+	if err != nil {
+		return nil, err
+	}
+	return l.move(node, dir, dstName)
+}
+
+// Rename renames node to name.
+func (l *Local) Rename(node *model.Node, name string) (*model.Node, error) {
+	return l.move(node, node.Parent, name)
+}
+
+func (l *Local) move(node, dir *model.Node, name string) (*model.Node, error) {
+	if dir == node.Parent && name == node.Name() {
+		return node, nil
+	}
+	name = freeName(dir, name)
+	src := l.fileName(node)
+	dst := filepath.Join(l.fileName(dir), name)
+	if !node.IsDirectory() {
+		dst += ".zip"
+	}
+	err := os.Rename(src, dst)
+	if err != nil {
+		return nil, permanentError("move "+node.Name(), err)
+	}
+
+	delete(node.Parent.Children, node.Id())
+	node.Document.VissibleName = name
+	node.Document.Parent = dir.Id()
+	node.Parent = dir
+	dir.Children[node.Id()] = node
+	return node, nil
+}
+
+// Mkdir creates the folder path, whose parent folder must exist.
+func (l *Local) Mkdir(path string) (*model.Node, error) {
+	parentPath, name := splitPath(path)
+	parent, err := l.FindFile(parentPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parent.FindByName(name); err == nil {
+		return nil, permanentError("mkdir "+path, errors.New("exists already"))
+	}
+	err = os.Mkdir(filepath.Join(l.fileName(parent), name), 0755)
+	if err != nil {
+		return nil, permanentError("mkdir "+path, err)
+	}
+	doc := l.newDocument(uuid.New().String(), parent.Id(), name, model.DirectoryType, time.Now())
+	l.tree.AddDocument(doc)
+	return l.tree.NodeById(doc.ID), nil
+}
+
+// Delete deletes node, folders must be empty.
+func (l *Local) Delete(node *model.Node) error {
+	if len(node.Children) > 0 {
+		return permanentError("delete "+node.Name(), errors.New("folder is not empty"))
+	}
+	err := os.Remove(l.fileName(node))
+	if err != nil {
+		return permanentError("delete "+node.Name(), err)
+	}
+	l.tree.DeleteNode(node)
+	return nil
+}
+
+// pdfToBundle writes the PDF src as a document .zip to dst, like the cloud does for uploaded PDFs.
+func pdfToBundle(src, dst string) error {
+	pdfDoc, err := document.FromPdfFile(nil, src)
+	if err != nil {
+		return err
+	}
+	defer pdfDoc.Close()
+	return pdfDoc.WriteToFile(dst)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
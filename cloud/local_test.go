@@ -0,0 +1,310 @@
+package cloud
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/juruen/rmapi/model"
+	"github.com/skius/rm-pdf-tools/document"
+)
+
+// writePdf writes a PDF of a single page to fileName.
+func writePdf(t *testing.T, fileName string) {
+	t.Helper()
+	buf := bytes.Buffer{}
+	if err := document.WriteTextPdf(&buf, []string{filepath.Base(fileName)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fileName, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeBundle writes a document .zip with a fresh UUID to fileName and returns its UUID.
+func writeBundle(t *testing.T, fileName string) string {
+	t.Helper()
+	pdf := filepath.Join(t.TempDir(), "doc.pdf")
+	writePdf(t, pdf)
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := pdfToBundle(pdf, fileName); err != nil {
+		t.Fatal(err)
+	}
+	id, err := bundleUuid(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// files returns the files in root and its subdirectories, relative to root and sorted.
+func files(t *testing.T, root string) []string {
+	t.Helper()
+	res := make([]string, 0)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		res = append(res, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func names(nodes []*model.Node) []string {
+	res := make([]string, len(nodes))
+	for i, node := range nodes {
+		res[i] = node.Name()
+	}
+	return res
+}
+
+func TestNewLocal(t *testing.T) {
+	root := t.TempDir()
+	id := writeBundle(t, filepath.Join(root, "pdf-tools/work/Notes.zip"))
+	if err := os.MkdirAll(filepath.Join(root, "pdf-tools/original"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Not documents, or a second copy of one, which are skipped
+	writePdf(t, filepath.Join(root, "pdf-tools/work/Slides.pdf"))
+	if err := os.WriteFile(filepath.Join(root, "pdf-tools/work/Broken.zip"), []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "pdf-tools/work/Notes.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "pdf-tools/work/Notes copy.zip"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := l.Children("/pdf-tools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(dirs); fmt.Sprint(got) != "[original work]" || !dirs[0].IsDirectory() {
+		t.Errorf("folders %q, want [original work]", got)
+	}
+	docs, err := l.Children("/pdf-tools/work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Of the copies of a document, the first one read is kept
+	if len(docs) != 1 || docs[0].Id() != id || docs[0].IsDirectory() {
+		t.Fatalf("documents %q, want one document with UUID %s", names(docs), id)
+	}
+	if node, err := l.FindFileById(id); err != nil || node != docs[0] {
+		t.Errorf("got node %v, %v by UUID, want %v", node, err, docs[0])
+	}
+	if _, err := docs[0].LastModified(); err != nil {
+		t.Errorf("last-modified time: %v", err)
+	}
+
+	if _, err := NewLocal(filepath.Join(root, "missing")); err == nil {
+		t.Errorf("got no error for a missing directory")
+	}
+}
+
+func TestLocalNotFound(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.FindFile("/pdf-tools/work")
+	if !errors.Is(err, ErrNotFound) || IsTemporary(err) {
+		t.Errorf("got error %v, want a permanent ErrNotFound", err)
+	}
+	_, err = l.FindFileById("42")
+	if !errors.Is(err, ErrNotFound) || IsTemporary(err) {
+		t.Errorf("got error %v, want a permanent ErrNotFound", err)
+	}
+	if _, err = l.Upload("doc.zip", "/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v uploading to a missing folder, want ErrNotFound", err)
+	}
+}
+
+func TestLocalUploadDownload(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "processed"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := t.TempDir()
+	id := writeBundle(t, filepath.Join(src, "Notes.zip"))
+	writePdf(t, filepath.Join(src, "Notes.pdf"))
+	if err := os.WriteFile(filepath.Join(src, "Broken.zip"), []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := l.Upload(filepath.Join(src, "Notes.zip"), "/processed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Id() != id || node.Name() != "Notes" || node.Parent.Name() != "processed" {
+		t.Errorf("uploaded %s named %q in %q, want %s named Notes in processed", node.Id(), node.Name(), node.Parent.Name(), id)
+	}
+	// A PDF becomes a document with a fresh UUID, named like the file but not like another document
+	pdfNode, err := l.Upload(filepath.Join(src, "Notes.pdf"), "/processed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdfNode.Id() == id || pdfNode.Name() != "Notes (2)" {
+		t.Errorf("uploaded PDF %s named %q, want a fresh UUID named \"Notes (2)\"", pdfNode.Id(), pdfNode.Name())
+	}
+	// The upload is not kept if it is not a document or a document with its UUID exists already
+	for _, fileName := range []string{"Notes.zip", "Broken.zip"} {
+		if _, err := l.Upload(filepath.Join(src, fileName), "/processed"); err == nil || IsTemporary(err) {
+			t.Errorf("uploading %s: got error %v, want a permanent error", fileName, err)
+		}
+	}
+	want := []string{"processed/Notes (2).zip", "processed/Notes.zip"}
+	if got := files(t, root); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("files %q, want %q", got, want)
+	}
+	if err := document.Validate(nil, filepath.Join(root, "processed/Notes (2).zip")); err != nil {
+		t.Errorf("uploaded PDF: %v", err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "download.zip")
+	if err := l.Download(node, dst); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaded, err := os.ReadFile(filepath.Join(src, "Notes.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, uploaded) {
+		t.Errorf("downloaded document differs from the uploaded one")
+	}
+
+	// The documents are found again in a new Local
+	l, err = NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node, err := l.FindFile("/processed/Notes (2)"); err != nil || node.Id() != pdfNode.Id() {
+		t.Errorf("got %v, %v, want the uploaded PDF", node, err)
+	}
+}
+
+func TestLocalMoveRename(t *testing.T) {
+	root := t.TempDir()
+	id := writeBundle(t, filepath.Join(root, "work/Notes.zip"))
+	writeBundle(t, filepath.Join(root, "original/Notes.zip"))
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := l.FindFileById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The name is taken in original
+	moved, err := l.Move(node, "/original", "Notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Name() != "Notes (2)" || moved.Parent.Name() != "original" {
+		t.Errorf("moved to %q in %q, want \"Notes (2)\" in original", moved.Name(), moved.Parent.Name())
+	}
+	renamed, err := l.Rename(moved, "Notes (old)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Name() != "Notes (old)" || renamed.Id() != id {
+		t.Errorf("renamed to %q, want \"Notes (old)\"", renamed.Name())
+	}
+	if _, err := l.Move(renamed, "/missing", "Notes"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v moving to a missing folder, want ErrNotFound", err)
+	}
+
+	want := []string{"original/Notes (old).zip", "original/Notes.zip"}
+	if got := files(t, root); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("files %q, want %q", got, want)
+	}
+	work, err := l.Children("/work")
+	if err != nil || len(work) != 0 {
+		t.Errorf("got %q, %v in work, want no documents", names(work), err)
+	}
+	if node, err := l.FindFile("/original/Notes (old)"); err != nil || node.Id() != id {
+		t.Errorf("got %v, %v, want the moved document", node, err)
+	}
+
+	// A folder is moved with its documents
+	dir, err := l.FindFile("/original")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Rename(dir, "archive"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.FindFile("/archive/Notes (old)"); err != nil {
+		t.Errorf("document of the renamed folder: %v", err)
+	}
+}
+
+func TestLocalMkdirDelete(t *testing.T) {
+	root := t.TempDir()
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := l.Mkdir("/processed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Mkdir("/processed"); err == nil {
+		t.Errorf("got no error creating an existing folder")
+	}
+	if _, err := l.Mkdir("/missing/processed"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v creating a folder in a missing folder, want ErrNotFound", err)
+	}
+
+	src := filepath.Join(t.TempDir(), "Notes.zip")
+	writeBundle(t, src)
+	node, err := l.Upload(src, "/processed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Delete(dir); err == nil {
+		t.Errorf("got no error deleting a folder which is not empty")
+	}
+	if err := l.Delete(node); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Delete(dir); err != nil {
+		t.Fatal(err)
+	}
+	if got := files(t, root); len(got) != 0 {
+		t.Errorf("files %q left", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "processed")); !os.IsNotExist(err) {
+		t.Errorf("folder was not deleted: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package document
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Blob holds the content of a potentially large file, e.g. a PDF, in memory or in a temporary file depending on the
+// MemoryLimit of the Env it was created by. Reading a Blob with pdfcpu still loads the parsed PDF into memory.
+// A Blob is written to once and then read through ReadAt or Reader.
+type Blob struct {
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+
+	memoryLimit int64
+	tempDir     string
+}
+
+// NewBlob creates an empty Blob with the default Env.
+func NewBlob() *Blob {
+	return (*Env)(nil).NewBlob()
+}
+
+// Write appends p to the Blob, moving it to a temporary file once it grows beyond its memory limit.
+func (b *Blob) Write(p []byte) (int, error) {
+	if b.file == nil && int64(b.buf.Len()+len(p)) > b.memoryLimit {
+		f, err := os.CreateTemp(b.tempDir, "rm-pdf-tools-*")
+		if err != nil {
+			return 0, err
+		}
+		_, err = f.Write(b.buf.Bytes())
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return 0, err
+		}
+		b.file = f
+		b.buf = bytes.Buffer{}
+	}
+
+	var n int
+	var err error
+	if b.file != nil {
+		n, err = b.file.Write(p)
+	} else {
+		n, err = b.buf.Write(p)
+	}
+	b.size += int64(n)
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt.
+func (b *Blob) ReadAt(p []byte, off int64) (int, error) {
+	if b.file != nil {
+		return b.file.ReadAt(p, off)
+	}
+	return bytes.NewReader(b.buf.Bytes()).ReadAt(p, off)
+}
+
+// Size returns the size of the Blob's content.
+func (b *Blob) Size() int64 {
+	return b.size
+}
+
+// Reader returns a new reader of the Blob's content.
+func (b *Blob) Reader() *io.SectionReader {
+	return io.NewSectionReader(b, 0, b.size)
+}
+
+// Close releases the Blob's temporary file, if any.
+func (b *Blob) Close() error {
+	if b == nil || b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	if rmErr := os.Remove(b.file.Name()); err == nil {
+		err = rmErr
+	}
+	b.file = nil
+	return err
+}
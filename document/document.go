@@ -2,44 +2,67 @@ package document
 
 import (
 	"archive/zip"
-	"bytes"
-	_ "embed"
 	"encoding/json"
 	"fmt"
-	"github.com/pdfcpu/pdfcpu/pkg/api"
-	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/skius/rm-pdf-tools/document/rm"
 	"io"
 	"os"
 	"strings"
 )
 
-//go:embed empty.pdf
-var emptyPdf []byte
-
 type Document struct {
 	Uuid string
 	Content Content
 	Pagedata []string
-	RmFiles map[string][]byte
+	// PageFiles holds the per-page files of the document, e.g. annotations and highlights.
+	PageFiles map[PageFile][]byte
+	// Format is the layout the document was read from and is written in.
+	Format Format
+	// Metadata is the .metadata file of the document, it is only written in FormatRmdoc.
+	Metadata []byte
 }
 
 func (doc Document) String() string {
 	fileNames := make([]string, 0)
-	for fn, _ := range doc.RmFiles {
-		fileNames = append(fileNames, fn)
+	for pf := range doc.PageFiles {
+		fileNames = append(fileNames, pf.Dir()+pf.Name())
+	}
+	return fmt.Sprintf("{uuid: %s, content: %v, pagedata: %s, pagefiles: %s}", doc.Uuid, doc.Content, doc.Pagedata, fileNames)
+}
+
+// RmPage decodes the .rm file of the page identified by key.
+func (doc Document) RmPage(key PageKey) (*rm.Page, error) {
+	data, ok := doc.PageFiles[RmFile(key)]
+	if !ok {
+		return nil, fmt.Errorf("no .rm file for page %s in document %s", key, doc.Uuid)
+	}
+	return rm.Decode(data)
+}
+
+// SetRmPage encodes page and stores it as the .rm file of the page identified by key.
+func (doc Document) SetRmPage(key PageKey, page *rm.Page) error {
+	data, err := rm.Encode(page)
+	if err != nil {
+		return err
 	}
-	return fmt.Sprintf("{uuid: %s, content: %v, pagedata: %s, rmfiles: %s}", doc.Uuid, doc.Content, doc.Pagedata, fileNames)
+	doc.PageFiles[RmFile(key)] = data
+	return nil
 }
 
 type PdfDocument struct {
 	Document
-	Pdf []byte
+	Pdf *Blob
 }
 
 func (pdfDoc PdfDocument) String() string {
 	return pdfDoc.Document.String()
 }
 
+// Close releases the temporary file of the PDF, if any.
+func (pdfDoc PdfDocument) Close() error {
+	return pdfDoc.Pdf.Close()
+}
+
 // Content represents the top-level UUID.content JSON
 type Content struct {
 	CoverPageNumber  int `json:"coverPageNumber"`
@@ -74,6 +97,9 @@ type Content struct {
 	} `json:"extraMetadata"`
 	FileType      string   `json:"fileType"`
 	FontName      string   `json:"fontName"`
+	// FormatVersion and CPages are only set in FormatRmdoc, see CPages.
+	FormatVersion int      `json:"formatVersion,omitempty"`
+	CPages        *CPages  `json:"cPages,omitempty"`
 	LineHeight    int      `json:"lineHeight"`
 	Margins       int      `json:"margins"`
 	Orientation   string   `json:"orientation"`
@@ -88,123 +114,189 @@ func (c Content) String() string {
 	return string(buf)
 }
 
-// ToPdfDoc converts the Document into a PdfDocument by creating the appropriate number of blank PDF pages.
-func (doc Document) ToPdfDoc() PdfDocument {
+// ToPdfDoc converts the Document into a PdfDocument by rendering the template of each page as its PDF page, see templates.
+func (doc Document) ToPdfDoc(env *Env) (PdfDocument, error) {
 	pdfDoc := PdfDocument{}
 	pdfDoc.Document = doc
 	pdfDoc.Content.FileType = "pdf"
 
-	pageCount := pdfDoc.Content.PageCount
-	// Insert pageCount - 1 empty pages
-	reader := bytes.NewReader(emptyPdf)
-	conf := pdfcpu.NewDefaultConfiguration()
-	for c := 0; c < pageCount - 1; c++ {
-		writer := new(bytes.Buffer)
-		err := api.InsertPages(reader, writer, []string{"1"}, true, conf)
-		if err != nil {
-			panic(err)
-		}
-		reader = bytes.NewReader(writer.Bytes())
-	}
-
-	filledPdf, err := io.ReadAll(reader)
+	var pages []rawPdfPage
+	pages, pdfDoc.Pagedata = templatePdfPages(env, doc.Pagedata, pdfDoc.Content.PageCount)
+	writer := env.NewBlob()
+	err := writeRawPdf(writer, pages)
 	if err != nil {
-		panic(err)
+		writer.Close()
+		return pdfDoc, err
 	}
+	pdfDoc.Pdf = writer
 
-	pdfDoc.Pdf = filledPdf
-
-	return pdfDoc
+	return pdfDoc, nil
 }
 
 // FromZipFilePdf deserializes a .zip'd PdfDocument from the .zip file.
-func FromZipFilePdf(fileName, uuid string) PdfDocument {
+func FromZipFilePdf(env *Env, fileName, uuid string) (PdfDocument, error) {
 	r, err := zip.OpenReader(fileName)
 	if err != nil {
-		panic(err)
+		return PdfDocument{}, documentError("open document", err)
 	}
+	defer r.Close()
 
-	pdfDoc := FromZipReaderPdf(r, uuid)
+	return FromZipReaderPdf(env, &r.Reader, uuid)
+}
 
-	err = r.Close()
+// FromZipFile is like FromZipFilePdf, but keeps documents without a PDF as they are, see FromZipReader.
+func FromZipFile(env *Env, fileName, uuid string) (PdfDocument, error) {
+	r, err := zip.OpenReader(fileName)
 	if err != nil {
-		panic(err)
+		return PdfDocument{}, documentError("open document", err)
 	}
+	defer r.Close()
 
-	return pdfDoc
+	return FromZipReader(env, &r.Reader, uuid)
 }
 
 // FromZipReaderPdf deserializes a .zip'd PdfDocument from the .zip's Reader.
-func FromZipReaderPdf(reader *zip.ReadCloser, uuid string) PdfDocument {
+// Documents without a PDF, e.g. notebooks, get blank PDF pages.
+func FromZipReaderPdf(env *Env, reader *zip.Reader, uuid string) (PdfDocument, error) {
+	pdfDoc, err := FromZipReader(env, reader, uuid)
+	if err != nil {
+		return pdfDoc, err
+	}
+
+	if pdfDoc.Content.FileType != "pdf" {
+		env.Logf("Filetype is not PDF! Creating blank PDF pages")
+		pdfDoc.Close()
+		return pdfDoc.ToPdfDoc(env)
+	}
+
+	return pdfDoc, nil
+}
+
+// FromZipReader deserializes a .zip'd document of any Format from the .zip's Reader. Pdf is nil if the document has
+// no PDF, e.g. for notebooks.
+func FromZipReader(env *Env, reader *zip.Reader, uuid string) (PdfDocument, error) {
 	pdfDoc := PdfDocument{}
 	pdfDoc.Uuid = uuid
-	pdfDoc.RmFiles = make(map[string][]byte)
+	pdfDoc.PageFiles = make(map[PageFile][]byte)
 	pdfDoc.Pagedata = make([]string, 0)
 	for _, f := range reader.File {
-		fr, err := f.Open()
-		if err != nil {
-			panic(err)
+		err := env.Err()
+		if err == nil {
+			err = pdfDoc.readZipEntry(env, f)
 		}
-		// 36 character UUID
-		suffix := f.Name[36:]
-		switch suffix {
-		case ".content":
-			pdfDoc.Content = getContentFromReader(fr)
-		case ".pagedata":
-			pdfDoc.Pagedata = getPagedataFromReader(fr)
-		case ".pdf":
-			pdfDoc.Pdf = getBytesFromReader(fr)
-		default:
-			// Must be inner file:
-			fmt.Println("Inner file", f.Name)
-			rmFileName := f.Name[37:]
-			buf := getBytesFromReader(fr)
-			pdfDoc.RmFiles[rmFileName] = buf
+		if err != nil {
+			pdfDoc.Close()
+			return PdfDocument{}, err
 		}
-		err = fr.Close()
+	}
+
+	if pdfDoc.Content.CPages != nil {
+		err := pdfDoc.fromCPages(env)
 		if err != nil {
-			panic(err)
+			pdfDoc.Close()
+			return PdfDocument{}, err
 		}
 	}
 
-	if pdfDoc.Content.FileType != "pdf" {
-		fmt.Println("Filetype is not PDF! PDF content is:", pdfDoc.Pdf)
-		pdfDoc = pdfDoc.ToPdfDoc()
+	return pdfDoc, nil
+}
+
+// readZipEntry reads the .zip entry f into the PdfDocument.
+func (pdfDoc *PdfDocument) readZipEntry(env *Env, f *zip.File) error {
+	fr, err := f.Open()
+	if err != nil {
+		return documentError("read "+f.Name, err)
 	}
+	defer fr.Close()
 
-	return pdfDoc
+	entry := ClassifyEntry(f.Name, pdfDoc.Uuid)
+	switch entry.Kind {
+	case EntryContent:
+		pdfDoc.Content, err = getContentFromReader(fr)
+	case EntryPagedata:
+		pdfDoc.Pagedata, err = getPagedataFromReader(fr)
+	case EntryPdf:
+		pdfDoc.Pdf, err = env.ReadBlob(fr)
+	case EntryMetadata:
+		pdfDoc.Metadata, err = getBytesFromReader(fr)
+	case EntryPageFile:
+		env.Logf("Page file %s", f.Name)
+		pdfDoc.PageFiles[entry.PageFile], err = getBytesFromReader(fr)
+	default:
+		env.Logf("Skipping file %s", f.Name)
+	}
+	return err
 }
 
 // WriteToFile writes the document as .zip to the given file.
-func (pdfDoc PdfDocument) WriteToFile(fileName string) {
+func (pdfDoc PdfDocument) WriteToFile(fileName string) error {
 	file, err := os.Create(fileName)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	w := zip.NewWriter(file)
 
-	writeToZip(w, pdfDoc.Uuid + ".pdf", pdfDoc.Pdf)
+	err = pdfDoc.WriteZipEntries(w)
+	if err == nil {
+		err = w.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", fileName, err)
+	}
+	return nil
+}
 
-	contentData, err := json.Marshal(pdfDoc.Content)
+// WriteZip writes the document as .zip to w.
+func (pdfDoc PdfDocument) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	err := pdfDoc.WriteZipEntries(zw)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	writeToZip(w, pdfDoc.Uuid + ".content", contentData)
+	return zw.Close()
+}
 
-	// Add newline
-	pagedata := strings.Join(pdfDoc.Pagedata, "\n") + "\n"
-	writeToZip(w, pdfDoc.Uuid + ".pagedata", []byte(pagedata))
+// WriteZipEntries writes the entries of the document to w in its Format.
+func (pdfDoc PdfDocument) WriteZipEntries(w *zip.Writer) error {
+	if pdfDoc.Format == FormatRmdoc {
+		return pdfDoc.writeRmdoc(w)
+	}
 
-	for fn, data := range pdfDoc.RmFiles {
-		writeToZip(w, pdfDoc.Uuid + "/" + fn, data)
+	if pdfDoc.Pdf != nil {
+		err := writeBlobToZip(w, pdfDoc.Uuid + ".pdf", pdfDoc.Pdf)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = w.Close()
+	// The pages of FormatZip are not in cPages
+	content := pdfDoc.Content
+	content.FormatVersion = 0
+	content.CPages = nil
+	contentData, err := json.Marshal(content)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	err = file.Close()
+	err = writeToZip(w, pdfDoc.Uuid + ".content", contentData)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	// Add newline
+	pagedata := strings.Join(pdfDoc.Pagedata, "\n") + "\n"
+	err = writeToZip(w, pdfDoc.Uuid + ".pagedata", []byte(pagedata))
+	if err != nil {
+		return err
+	}
+
+	for pf, data := range pdfDoc.PageFiles {
+		err = writeToZip(w, pf.Path(pdfDoc.Uuid), data)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
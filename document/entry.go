@@ -0,0 +1,66 @@
+package document
+
+import (
+	"archive/zip"
+	"strings"
+)
+
+// EntryKind is the kind of a file stored in a document .zip.
+type EntryKind int
+
+const (
+	// EntryUnknown is any file we don't know about, it is passed through unchanged.
+	EntryUnknown EntryKind = iota
+	EntryContent
+	EntryPagedata
+	EntryPdf
+	EntryEpub
+	EntryMetadata
+	// EntryPageFile is a file belonging to a single page, see PageFile.
+	EntryPageFile
+)
+
+var topLevelSuffixes = map[string]EntryKind{
+	".content":  EntryContent,
+	".pagedata": EntryPagedata,
+	".pdf":      EntryPdf,
+	".epub":     EntryEpub,
+	".metadata": EntryMetadata,
+}
+
+// Entry is a classified file of a document .zip.
+type Entry struct {
+	Kind EntryKind
+	// Name is the full file name inside the .zip.
+	Name string
+	// PageFile is the parsed name of EntryPageFile entries.
+	PageFile PageFile
+}
+
+// ClassifyEntry classifies the file name of the .zip of document uuid.
+func ClassifyEntry(name, uuid string) Entry {
+	if !strings.HasPrefix(name, uuid) {
+		return Entry{Kind: EntryUnknown, Name: name}
+	}
+	rest := name[len(uuid):]
+
+	if kind, ok := topLevelSuffixes[rest]; ok {
+		return Entry{Kind: kind, Name: name}
+	}
+
+	if pf, ok := ParsePageFile(rest); ok {
+		return Entry{Kind: EntryPageFile, Name: name, PageFile: pf}
+	}
+
+	return Entry{Kind: EntryUnknown, Name: name}
+}
+
+// ZipUuid finds the UUID of the document in a .zip by its .content file, it returns false if there is none.
+func ZipUuid(r *zip.Reader) (string, bool) {
+	for _, f := range r.File {
+		if strings.HasSuffix(f.Name, ".content") && !strings.Contains(f.Name, "/") {
+			return strings.TrimSuffix(f.Name, ".content"), true
+		}
+	}
+	return "", false
+}
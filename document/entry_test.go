@@ -0,0 +1,46 @@
+package document
+
+import (
+	"testing"
+)
+
+func TestClassifyEntry(t *testing.T) {
+	const id = "9e0a5b4c-2f5e-4a36-9f0f-1f0b6f1cbb1e"
+	const pageId = "c3a2f0d6-7b1e-4a5c-8e8a-0d5b5b0a9a11"
+	tests := []struct {
+		name     string
+		kind     EntryKind
+		pageFile PageFile
+	}{
+		{id + ".content", EntryContent, PageFile{}},
+		{id + ".pagedata", EntryPagedata, PageFile{}},
+		{id + ".pdf", EntryPdf, PageFile{}},
+		{id + ".epub", EntryEpub, PageFile{}},
+		{id + ".metadata", EntryMetadata, PageFile{}},
+		{id + "/3.rm", EntryPageFile, PageFile{PageFileRm, PageKey{Index: 3}, ".rm"}},
+		{id + "/" + pageId + ".rm", EntryPageFile, PageFile{PageFileRm, PageKey{Uuid: pageId}, ".rm"}},
+		{id + "/0-metadata.json", EntryPageFile, PageFile{PageFileMetadata, PageKey{Index: 0}, "-metadata.json"}},
+		{id + ".thumbnails/1.jpg", EntryPageFile, PageFile{PageFileThumbnail, PageKey{Index: 1}, ".jpg"}},
+		{id + ".highlights/" + pageId + ".json", EntryPageFile, PageFile{PageFileHighlights, PageKey{Uuid: pageId}, ".json"}},
+		{id + ".textconversion/2.json", EntryPageFile, PageFile{PageFileTextConversion, PageKey{Index: 2}, ".json"}},
+		{id + ".local", EntryUnknown, PageFile{}},
+		{id + "/notes.txt", EntryUnknown, PageFile{}},
+		{id + "/x.rm", EntryUnknown, PageFile{}},
+		{pageId + ".content", EntryUnknown, PageFile{}},
+		{"README", EntryUnknown, PageFile{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := ClassifyEntry(tt.name, id)
+			if e.Name != tt.name {
+				t.Errorf("name %q, want %q", e.Name, tt.name)
+			}
+			if e.Kind != tt.kind {
+				t.Errorf("kind %d, want %d", e.Kind, tt.kind)
+			}
+			if e.PageFile != tt.pageFile {
+				t.Errorf("page file %+v, want %+v", e.PageFile, tt.pageFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package document
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+)
+
+// DefaultMemoryLimit is the MemoryLimit of an Env which doesn't set one.
+const DefaultMemoryLimit int64 = 32 << 20
+
+// Env configures how documents are processed: where large files are buffered and where progress is logged.
+// A nil *Env is valid and uses the defaults.
+type Env struct {
+	// MemoryLimit is the size in bytes up to which a Blob is kept in memory, larger Blobs are moved to a temporary
+	// file. DefaultMemoryLimit is used if it is 0. It only bounds the copies of files held in Blobs: pdfcpu still
+	// reads the object graph of every PDF it processes into memory, which takes memory in proportion to the PDF.
+	MemoryLimit int64
+	// TempDir is the directory temporary files are created in, the default directory for temporary files if empty.
+	TempDir string
+	// Log receives progress messages, they are discarded if it is nil.
+	Log *log.Logger
+	// Context cancels processing, which stops with its error before the next step, e.g. the next file of a document
+	// or the next document of a merge. Processing is not cancelled if it is nil.
+	Context context.Context
+}
+
+// Err returns the error of the Context if it is done, nil otherwise.
+func (e *Env) Err() error {
+	if e == nil || e.Context == nil {
+		return nil
+	}
+	return e.Context.Err()
+}
+
+// NewBlob creates an empty Blob buffered according to the Env.
+func (e *Env) NewBlob() *Blob {
+	b := &Blob{memoryLimit: DefaultMemoryLimit}
+	if e != nil {
+		if e.MemoryLimit != 0 {
+			b.memoryLimit = e.MemoryLimit
+		}
+		b.tempDir = e.TempDir
+	}
+	return b
+}
+
+// ReadBlob reads r into a new Blob.
+func (e *Env) ReadBlob(r io.Reader) (*Blob, error) {
+	b := e.NewBlob()
+	_, err := io.Copy(b, r)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// BlobFromBytes creates a Blob holding data.
+func (e *Env) BlobFromBytes(data []byte) (*Blob, error) {
+	return e.ReadBlob(bytes.NewReader(data))
+}
+
+// Logf logs a progress message.
+func (e *Env) Logf(format string, a ...interface{}) {
+	if e != nil && e.Log != nil {
+		e.Log.Printf(format, a...)
+	}
+}
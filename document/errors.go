@@ -0,0 +1,29 @@
+package document
+
+import (
+	"fmt"
+)
+
+// DocumentError is returned when a document cannot be processed, e.g. because it is corrupt or its PDF is not supported.
+// Retrying won't help, the document itself needs to be fixed.
+type DocumentError struct {
+	// Op describes what failed, e.g. "read PDF".
+	Op  string
+	Err error
+}
+
+func (e *DocumentError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *DocumentError) Unwrap() error {
+	return e.Err
+}
+
+// documentError wraps err in a DocumentError, or returns nil if err is nil.
+func documentError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &DocumentError{Op: op, Err: err}
+}
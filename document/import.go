@@ -0,0 +1,70 @@
+package document
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"io"
+	"os"
+)
+
+// pdfHeader starts every PDF file.
+var pdfHeader = []byte("%PDF-")
+
+// IsPdf returns whether r is a plain PDF rather than a document .zip.
+func IsPdf(r io.ReaderAt) bool {
+	header := make([]byte, len(pdfHeader))
+	_, err := r.ReadAt(header, 0)
+	return err == nil && bytes.Equal(header, pdfHeader)
+}
+
+// IsPdfFile returns whether the file fileName is a plain PDF rather than a document .zip.
+func IsPdfFile(fileName string) bool {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return IsPdf(f)
+}
+
+// FromPdfReader creates a document without annotations from the plain PDF r, as if it had been uploaded to the
+// tablet: it gets a fresh UUID, a page UUID and a blank template for every page, and a content like that of uploads.
+func FromPdfReader(env *Env, r io.Reader) (PdfDocument, error) {
+	pdf, err := env.ReadBlob(r)
+	if err != nil {
+		return PdfDocument{}, documentError("read PDF", err)
+	}
+	pageCount, err := api.PageCount(pdf.Reader(), pdfcpu.NewDefaultConfiguration())
+	if err != nil {
+		pdf.Close()
+		return PdfDocument{}, documentError("read PDF", err)
+	}
+
+	pdfDoc := PdfDocument{Pdf: pdf}
+	pdfDoc.Uuid = uuid.New().String()
+	pdfDoc.PageFiles = make(map[PageFile][]byte)
+	pdfDoc.Content.FileType = "pdf"
+	pdfDoc.Content.Orientation = "portrait"
+	pdfDoc.Content.TextScale = 1
+	pdfDoc.Content.PageCount = pageCount
+	pdfDoc.Content.Pages = make([]string, pageCount)
+	pdfDoc.Pagedata = make([]string, pageCount)
+	for i := range pdfDoc.Content.Pages {
+		pdfDoc.Content.Pages[i] = uuid.New().String()
+		pdfDoc.Pagedata[i] = "Blank"
+	}
+	return pdfDoc, nil
+}
+
+// FromPdfFile is like FromPdfReader for the plain PDF stored in fileName.
+func FromPdfFile(env *Env, fileName string) (PdfDocument, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return PdfDocument{}, documentError("open document", err)
+	}
+	defer f.Close()
+
+	return FromPdfReader(env, f)
+}
@@ -0,0 +1,129 @@
+package document
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// PageFileKind is the kind of a file belonging to a single page.
+type PageFileKind int
+
+const (
+	// PageFileRm is the annotations of a page, uuid/<key>.rm
+	PageFileRm PageFileKind = iota
+	// PageFileMetadata is the layer metadata of a page, uuid/<key>-metadata.json
+	PageFileMetadata
+	// PageFileHighlights is the highlighted text of a page, uuid.highlights/<key>.json
+	PageFileHighlights
+	// PageFileThumbnail is the thumbnail image of a page, uuid.thumbnails/<key>.jpg or .png
+	PageFileThumbnail
+	// PageFileTextConversion is the handwriting recognition result of a page, uuid.textconversion/<key>.json
+	PageFileTextConversion
+)
+
+type pageFileLayout struct {
+	kind     PageFileKind
+	dir      string
+	suffixes []string
+}
+
+// pageFileLayouts lists where each kind of page file is stored relative to the document UUID.
+var pageFileLayouts = []pageFileLayout{
+	{PageFileMetadata, "/", []string{"-metadata.json"}},
+	{PageFileRm, "/", []string{".rm"}},
+	{PageFileHighlights, ".highlights/", []string{".json"}},
+	{PageFileThumbnail, ".thumbnails/", []string{".jpg", ".png"}},
+	{PageFileTextConversion, ".textconversion/", []string{".json"}},
+}
+
+// PageKey identifies the page a PageFile belongs to. Older firmware names page files after the page
+// index, newer firmware after the page UUID found in Content.Pages.
+type PageKey struct {
+	Index int
+	// Uuid is the page UUID, if set Index is meaningless.
+	Uuid string
+}
+
+// IsUuid returns whether the key refers to its page by UUID.
+func (k PageKey) IsUuid() bool {
+	return k.Uuid != ""
+}
+
+func (k PageKey) String() string {
+	if k.IsUuid() {
+		return k.Uuid
+	}
+	return strconv.Itoa(k.Index)
+}
+
+// PageFile is the parsed name of a file belonging to a single page.
+type PageFile struct {
+	Kind PageFileKind
+	Key  PageKey
+	// Suffix is the file name after the key, e.g. ".rm" or ".png".
+	Suffix string
+}
+
+// ParsePageFile parses the name of a page file relative to the document UUID, e.g. "/3.rm" or ".highlights/<uuid>.json".
+func ParsePageFile(name string) (PageFile, bool) {
+	for _, layout := range pageFileLayouts {
+		if !strings.HasPrefix(name, layout.dir) {
+			continue
+		}
+		base := name[len(layout.dir):]
+		for _, suffix := range layout.suffixes {
+			if !strings.HasSuffix(base, suffix) {
+				continue
+			}
+			key, ok := parsePageKey(base[:len(base)-len(suffix)])
+			if !ok {
+				return PageFile{}, false
+			}
+			return PageFile{Kind: layout.kind, Key: key, Suffix: suffix}, true
+		}
+	}
+	return PageFile{}, false
+}
+
+func parsePageKey(s string) (PageKey, bool) {
+	if idx, err := strconv.Atoi(s); err == nil && idx >= 0 {
+		return PageKey{Index: idx}, true
+	}
+	if _, err := uuid.Parse(s); err == nil && len(s) == 36 {
+		return PageKey{Uuid: s}, true
+	}
+	return PageKey{}, false
+}
+
+// Dir returns the directory of the page file relative to the document UUID, e.g. "/" or ".thumbnails/".
+func (pf PageFile) Dir() string {
+	for _, layout := range pageFileLayouts {
+		if layout.kind == pf.Kind {
+			return layout.dir
+		}
+	}
+	panic("unknown page file kind")
+}
+
+// Name returns the file name of the page file inside its directory.
+func (pf PageFile) Name() string {
+	return pf.Key.String() + pf.Suffix
+}
+
+// Path returns the full name of the page file inside the .zip of document docUuid.
+func (pf PageFile) Path(docUuid string) string {
+	return docUuid + pf.Dir() + pf.Name()
+}
+
+// WithKey returns the page file renamed to belong to the page identified by key.
+func (pf PageFile) WithKey(key PageKey) PageFile {
+	pf.Key = key
+	return pf
+}
+
+// RmFile returns the PageFile of the annotations of page key.
+func RmFile(key PageKey) PageFile {
+	return PageFile{Kind: PageFileRm, Key: key, Suffix: ".rm"}
+}
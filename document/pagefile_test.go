@@ -0,0 +1,47 @@
+package document
+
+import (
+	"testing"
+)
+
+func TestParsePageFile(t *testing.T) {
+	const pageId = "c3a2f0d6-7b1e-4a5c-8e8a-0d5b5b0a9a11"
+	tests := []struct {
+		name string
+		want PageFile
+		ok   bool
+	}{
+		{"/0.rm", PageFile{PageFileRm, PageKey{Index: 0}, ".rm"}, true},
+		{"/11.rm", PageFile{PageFileRm, PageKey{Index: 11}, ".rm"}, true},
+		{"/" + pageId + ".rm", PageFile{PageFileRm, PageKey{Uuid: pageId}, ".rm"}, true},
+		{"/11-metadata.json", PageFile{PageFileMetadata, PageKey{Index: 11}, "-metadata.json"}, true},
+		{"/" + pageId + "-metadata.json", PageFile{PageFileMetadata, PageKey{Uuid: pageId}, "-metadata.json"}, true},
+		{".highlights/4.json", PageFile{PageFileHighlights, PageKey{Index: 4}, ".json"}, true},
+		{".highlights/" + pageId + ".json", PageFile{PageFileHighlights, PageKey{Uuid: pageId}, ".json"}, true},
+		{".thumbnails/2.jpg", PageFile{PageFileThumbnail, PageKey{Index: 2}, ".jpg"}, true},
+		{".thumbnails/" + pageId + ".png", PageFile{PageFileThumbnail, PageKey{Uuid: pageId}, ".png"}, true},
+		{".textconversion/7.json", PageFile{PageFileTextConversion, PageKey{Index: 7}, ".json"}, true},
+
+		{".content", PageFile{}, false},
+		{".pdf", PageFile{}, false},
+		{"/-1.rm", PageFile{}, false},
+		{"/notes.rm", PageFile{}, false},
+		{"/11.json", PageFile{}, false},
+		{"/11-metadata.txt", PageFile{}, false},
+		{"/" + pageId[:35] + ".rm", PageFile{}, false},
+		{".thumbnails/2.gif", PageFile{}, false},
+		{"/sub/2.rm", PageFile{}, false},
+		{"", PageFile{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pf, ok := ParsePageFile(tt.name)
+			if ok != tt.ok || pf != tt.want {
+				t.Fatalf("got %+v, %v, want %+v, %v", pf, ok, tt.want, tt.ok)
+			}
+			if ok && pf.Dir()+pf.Name() != tt.name {
+				t.Errorf("name %q, want %q", pf.Dir()+pf.Name(), tt.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,474 @@
+package document
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"io"
+	"strings"
+)
+
+// PageTokens returns a set of tokens describing the content of every page of pdf, used to compare pages
+// of different PDFs. These are the words of the page's text, or the lines of its content stream for pages
+// without text, e.g. scans.
+func PageTokens(pdf io.ReadSeeker) ([]map[string]struct{}, error) {
+	ctx, err := api.ReadContext(pdf, pdfcpu.NewDefaultConfiguration())
+	if err != nil {
+		return nil, documentError("read PDF", err)
+	}
+	err = ctx.EnsurePageCount()
+	if err != nil {
+		return nil, documentError("read PDF", err)
+	}
+
+	res := make([]map[string]struct{}, ctx.PageCount)
+	for i := range res {
+		r, err := ctx.ExtractPageContent(i + 1)
+		if err != nil {
+			return nil, documentError(fmt.Sprintf("read content of PDF page %d", i+1), err)
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return nil, documentError(fmt.Sprintf("read content of PDF page %d", i+1), err)
+		}
+
+		tokens := make(map[string]struct{})
+		for _, s := range contentStrings(content) {
+			for _, word := range strings.Fields(s) {
+				tokens[word] = struct{}{}
+			}
+		}
+		if len(tokens) == 0 {
+			for _, line := range strings.Split(string(content), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					tokens[line] = struct{}{}
+				}
+			}
+		}
+		res[i] = tokens
+	}
+
+	return res, nil
+}
+
+// contentStrings returns the literal and hex strings of a content stream, which hold the text shown by
+// the Tj, TJ, ' and " operators. Strings are returned as encoded in the PDF, which is good enough to compare
+// PDFs produced by the same tool.
+func contentStrings(content []byte) []string {
+	res := make([]string, 0)
+	for i := 0; i < len(content); i++ {
+		switch content[i] {
+		case '(':
+			sb := strings.Builder{}
+			depth := 1
+			for i++; i < len(content) && depth > 0; i++ {
+				c := content[i]
+				switch {
+				case c == '\\' && i+1 < len(content):
+					i++
+					sb.WriteByte(content[i])
+				case c == '(':
+					depth++
+					sb.WriteByte(c)
+				case c == ')':
+					depth--
+					if depth > 0 {
+						sb.WriteByte(c)
+					}
+				default:
+					sb.WriteByte(c)
+				}
+			}
+			i--
+			res = append(res, sb.String())
+		case '<':
+			if i+1 < len(content) && content[i+1] == '<' {
+				// Dictionary, not a hex string
+				i++
+				continue
+			}
+			end := bytes.IndexByte(content[i:], '>')
+			if end == -1 {
+				return res
+			}
+			res = append(res, string(content[i+1:i+end]))
+			i += end
+		}
+	}
+	return res
+}
+
+// PdfPage is a page of a rearranged PDF: page SourcePage (1-based) of the input PDF, or a blank page of the same
+// size if Blank is set.
+type PdfPage struct {
+	SourcePage int
+	Blank      bool
+}
+
+// inheritablePageAttrs are the page attributes which may be set on a page tree node instead of the page itself.
+var inheritablePageAttrs = []string{"Resources", "MediaBox", "CropBox", "Rotate"}
+
+// RearrangePdf reads pdf once, rearranges its pages and writes the resulting PDF to w. plan is called with the page count
+// of pdf and returns the pages of the result, or an error if the pages can't be planned, which is returned as is.
+// Pages of pdf which are not part of the plan are dropped, along with the outline items and named destinations pointing
+// to them. A page planned more than once is copied.
+func RearrangePdf(pdf io.ReadSeeker, w io.Writer, plan func(pageCount int) ([]PdfPage, error)) error {
+	ctx, pageRefs, err := readPdfPages(pdf)
+	if err != nil {
+		return err
+	}
+	rootRef, err := ctx.Pages()
+	if err != nil {
+		return documentError("read PDF page tree", err)
+	}
+	root, err := ctx.DereferenceDict(*rootRef)
+	if err != nil {
+		return documentError("read PDF page tree", err)
+	}
+
+	pages, err := plan(len(pageRefs))
+	if err != nil {
+		return err
+	}
+	kids := pdfcpu.Array{}
+	used := make(map[pdfcpu.IndirectRef]bool)
+	for _, p := range pages {
+		if p.SourcePage < 1 || p.SourcePage > len(pageRefs) {
+			return documentError("rearrange PDF pages", fmt.Errorf("page %d does not exist, the PDF has %d pages", p.SourcePage, len(pageRefs)))
+		}
+		src := pageRefs[p.SourcePage-1]
+		switch {
+		case p.Blank:
+			src, err = blankPage(ctx, src)
+			if err != nil {
+				return documentError("add blank PDF page", err)
+			}
+		case used[src]:
+			// A page can only be once in the page tree
+			src, err = copyPage(ctx, src)
+			if err != nil {
+				return documentError("copy PDF page", err)
+			}
+		}
+		used[src] = true
+
+		d, err := ctx.DereferenceDict(src)
+		if err != nil {
+			return documentError("read PDF page", err)
+		}
+		d.Update("Parent", *rootRef)
+		kids = append(kids, src)
+	}
+
+	dropped := make(map[pdfcpu.IndirectRef]bool)
+	for _, ref := range pageRefs {
+		if !used[ref] {
+			dropped[ref] = true
+		}
+	}
+	if len(dropped) > 0 {
+		err = pruneDests(ctx, dropped)
+		if err != nil {
+			return documentError("remove links to dropped PDF pages", err)
+		}
+	}
+
+	root.Update("Kids", kids)
+	root.Update("Count", pdfcpu.Integer(len(kids)))
+	ctx.PageCount = len(kids)
+
+	err = api.WriteContext(ctx, w)
+	if err != nil {
+		return documentError("write PDF", err)
+	}
+	return nil
+}
+
+// PdfPageDims returns the size of every page of pdf in points, as shown by a viewer, i.e. of its CropBox and rotated.
+func PdfPageDims(pdf io.ReadSeeker) ([]pdfcpu.Dim, error) {
+	conf := pdfcpu.NewDefaultConfiguration()
+	ctx, err := api.ReadContext(pdf, conf)
+	if err != nil {
+		return nil, documentError("read PDF", err)
+	}
+	dims, err := ctx.PageDims()
+	if err != nil {
+		return nil, documentError("read PDF page boxes", err)
+	}
+	return dims, nil
+}
+
+// pageBoxes are the page attributes which are rectangles in the coordinates of the page.
+var pageBoxes = []string{"MediaBox", "CropBox", "BleedBox", "TrimBox", "ArtBox"}
+
+// ScalePdf reads pdf, scales page i by factors[i] and writes the resulting PDF to w. The page boxes, the content
+// and the areas of annotations such as links are scaled, so the pages look the same, only larger or smaller.
+func ScalePdf(pdf io.ReadSeeker, w io.Writer, factors []float64) error {
+	ctx, pageRefs, err := readPdfPages(pdf)
+	if err != nil {
+		return err
+	}
+	if len(factors) != len(pageRefs) {
+		return fmt.Errorf("%d scale factors for %d pages", len(factors), len(pageRefs))
+	}
+
+	for i, ref := range pageRefs {
+		if factors[i] == 1 {
+			continue
+		}
+		err = scalePage(ctx, ref, factors[i])
+		if err != nil {
+			return documentError("scale PDF page", err)
+		}
+	}
+
+	err = api.WriteContext(ctx, w)
+	if err != nil {
+		return documentError("write PDF", err)
+	}
+	return nil
+}
+
+// AddPageLinks reads pdf, adds links to it and writes the resulting PDF to w.
+func AddPageLinks(pdf io.ReadSeeker, w io.Writer, links []PageLink) error {
+	ctx, pageRefs, err := readPdfPages(pdf)
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		if link.Page < 1 || link.Page > len(pageRefs) || link.Target < 1 || link.Target > len(pageRefs) {
+			return fmt.Errorf("link from page %d to page %d, the PDF has %d pages", link.Page, link.Target, len(pageRefs))
+		}
+		annot := pdfcpu.Dict(map[string]pdfcpu.Object{
+			"Type":    pdfcpu.Name("Annot"),
+			"Subtype": pdfcpu.Name("Link"),
+			"Rect":    pdfcpu.NewNumberArray(link.Rect[:]...),
+			"Border":  pdfcpu.NewIntegerArray(0, 0, 0),
+			"Dest":    pdfcpu.Array{pageRefs[link.Target-1], pdfcpu.Name("Fit")},
+		})
+		annotRef, err := ctx.IndRefForNewObject(annot)
+		if err != nil {
+			return documentError("add PDF link", err)
+		}
+
+		d, err := ctx.DereferenceDict(pageRefs[link.Page-1])
+		if err != nil {
+			return documentError("read PDF page", err)
+		}
+		annots := pdfcpu.Array{}
+		if o, ok := d.Find("Annots"); ok {
+			annots, err = ctx.DereferenceArray(o)
+			if err != nil {
+				return documentError("read PDF page", err)
+			}
+		}
+		d.Update("Annots", append(annots, *annotRef))
+	}
+
+	err = api.WriteContext(ctx, w)
+	if err != nil {
+		return documentError("write PDF", err)
+	}
+	return nil
+}
+
+// readPdfPages reads and validates pdf and returns its context and pages in order.
+func readPdfPages(pdf io.ReadSeeker) (*pdfcpu.Context, []pdfcpu.IndirectRef, error) {
+	conf := pdfcpu.NewDefaultConfiguration()
+	ctx, err := api.ReadContext(pdf, conf)
+	if err != nil {
+		return nil, nil, documentError("read PDF", err)
+	}
+	err = api.ValidateContext(ctx)
+	if err != nil {
+		return nil, nil, documentError("validate PDF", err)
+	}
+	err = ctx.EnsurePageCount()
+	if err != nil {
+		return nil, nil, documentError("read PDF", err)
+	}
+
+	rootRef, err := ctx.Pages()
+	if err != nil {
+		return nil, nil, documentError("read PDF page tree", err)
+	}
+	pageRefs := make([]pdfcpu.IndirectRef, 0, ctx.PageCount)
+	err = flattenPageTree(ctx, *rootRef, pdfcpu.NewDict(), &pageRefs)
+	if err != nil {
+		return nil, nil, documentError("read PDF page tree", err)
+	}
+	return ctx, pageRefs, nil
+}
+
+// scalePage scales the page ref by factor, see ScalePdf.
+func scalePage(ctx *pdfcpu.Context, ref pdfcpu.IndirectRef, factor float64) error {
+	d, err := ctx.DereferenceDict(ref)
+	if err != nil {
+		return err
+	}
+
+	for _, box := range pageBoxes {
+		if o, ok := d.Find(box); ok {
+			scaled, err := scaleRect(ctx, o, factor)
+			if err != nil {
+				return err
+			}
+			d.Update(box, scaled)
+		}
+	}
+
+	// Prepend a content stream scaling everything drawn by the page
+	if o, ok := d.Find("Contents"); ok {
+		sd, err := ctx.NewStreamDictForBuf([]byte(fmt.Sprintf("%.6f 0 0 %.6f 0 0 cm\n", factor, factor)))
+		if err != nil {
+			return err
+		}
+		err = sd.Encode()
+		if err != nil {
+			return err
+		}
+		scaleRef, err := ctx.IndRefForNewObject(*sd)
+		if err != nil {
+			return err
+		}
+
+		contents := pdfcpu.Array{*scaleRef}
+		if arr, err := ctx.DereferenceArray(o); err == nil && arr != nil {
+			contents = append(contents, arr...)
+		} else {
+			contents = append(contents, o)
+		}
+		d.Update("Contents", contents)
+	}
+
+	if o, ok := d.Find("Annots"); ok {
+		annots, err := ctx.DereferenceArray(o)
+		if err != nil {
+			return err
+		}
+		for _, o := range annots {
+			annot, err := ctx.DereferenceDict(o)
+			if err != nil || annot == nil {
+				continue
+			}
+			if rect, ok := annot.Find("Rect"); ok {
+				scaled, err := scaleRect(ctx, rect, factor)
+				if err != nil {
+					return err
+				}
+				annot.Update("Rect", scaled)
+			}
+		}
+	}
+	return nil
+}
+
+// scaleRect returns the rectangle o scaled by factor.
+func scaleRect(ctx *pdfcpu.Context, o pdfcpu.Object, factor float64) (pdfcpu.Array, error) {
+	arr, err := ctx.DereferenceArray(o)
+	if err != nil {
+		return nil, err
+	}
+	if len(arr) != 4 {
+		return nil, fmt.Errorf("rectangle has %d numbers instead of 4", len(arr))
+	}
+	nums := make([]float64, 4)
+	for i, n := range arr {
+		nums[i], err = ctx.DereferenceNumber(n)
+		if err != nil {
+			return nil, err
+		}
+		nums[i] *= factor
+	}
+	return pdfcpu.NewNumberArray(nums...), nil
+}
+
+// flattenPageTree collects the pages of the page tree node ref in order. As all pages end up as direct children of the
+// root, attributes inherited from intermediate nodes are copied to the pages.
+func flattenPageTree(ctx *pdfcpu.Context, ref pdfcpu.IndirectRef, inherited pdfcpu.Dict, pages *[]pdfcpu.IndirectRef) error {
+	d, err := ctx.DereferenceDict(ref)
+	if err != nil {
+		return err
+	}
+
+	if d.Type() != nil && *d.Type() == "Page" {
+		for _, attr := range inheritablePageAttrs {
+			if _, ok := d.Find(attr); !ok {
+				if o, ok := inherited.Find(attr); ok {
+					d.Insert(attr, o)
+				}
+			}
+		}
+		*pages = append(*pages, ref)
+		return nil
+	}
+
+	childInherited := pdfcpu.NewDict()
+	for _, attr := range inheritablePageAttrs {
+		if o, ok := d.Find(attr); ok {
+			childInherited.Insert(attr, o)
+		} else if o, ok := inherited.Find(attr); ok {
+			childInherited.Insert(attr, o)
+		}
+	}
+
+	kids, err := ctx.DereferenceArray(d["Kids"])
+	if err != nil {
+		return err
+	}
+	for _, o := range kids {
+		kid, ok := o.(pdfcpu.IndirectRef)
+		if !ok {
+			return errors.New("corrupt page tree: page tree node kid is not an indirect reference")
+		}
+		err = flattenPageTree(ctx, kid, childInherited, pages)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blankPageAttrs are the page attributes a blank page copies from its neighbour, so it has the same size and orientation.
+var blankPageAttrs = []string{"MediaBox", "CropBox", "Rotate"}
+
+// blankPage adds an empty page with the same boxes and rotation as the page ref to ctx.
+func blankPage(ctx *pdfcpu.Context, ref pdfcpu.IndirectRef) (pdfcpu.IndirectRef, error) {
+	d, err := ctx.DereferenceDict(ref)
+	if err != nil {
+		return pdfcpu.IndirectRef{}, err
+	}
+
+	sd, err := ctx.NewStreamDictForBuf(nil)
+	if err != nil {
+		return pdfcpu.IndirectRef{}, err
+	}
+	err = sd.Encode()
+	if err != nil {
+		return pdfcpu.IndirectRef{}, err
+	}
+	contentsRef, err := ctx.IndRefForNewObject(*sd)
+	if err != nil {
+		return pdfcpu.IndirectRef{}, err
+	}
+
+	pageDict := pdfcpu.Dict(map[string]pdfcpu.Object{
+		"Type":      pdfcpu.Name("Page"),
+		"Resources": pdfcpu.NewDict(),
+		"Contents":  *contentsRef,
+	})
+	for _, attr := range blankPageAttrs {
+		if o, ok := d.Find(attr); ok {
+			pageDict.Insert(attr, o)
+		}
+	}
+
+	pageRef, err := ctx.IndRefForNewObject(pageDict)
+	if err != nil {
+		return pdfcpu.IndirectRef{}, err
+	}
+	return *pageRef, nil
+}
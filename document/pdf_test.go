@@ -0,0 +1,371 @@
+package document
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testPdf returns a PDF of pageCount A4 pages, each showing its page number.
+func testPdf(tb testing.TB, pageCount int) []byte {
+	tb.Helper()
+	pages := make([]rawPdfPage, pageCount)
+	for i := range pages {
+		pages[i] = rawPdfPage{width: 595, height: 842, content: fmt.Sprintf("BT /F1 12 Tf 72 720 Td (Page %d) Tj ET", i+1)}
+	}
+	buf := bytes.Buffer{}
+	if err := writeRawPdf(&buf, pages); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRearrangePdfBlankPage(t *testing.T) {
+	// Crop and rotate the first page, a blank page inserted after it must look the same
+	ctx, pageRefs, err := readPdfPages(bytes.NewReader(testPdf(t, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := ctx.DereferenceDict(pageRefs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Update("CropBox", pdfcpu.NewNumberArray(20, 20, 420, 620))
+	d.Update("Rotate", pdfcpu.Integer(90))
+	pdf := bytes.Buffer{}
+	if err = api.WriteContext(ctx, &pdf); err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.Buffer{}
+	err = RearrangePdf(bytes.NewReader(pdf.Bytes()), &out, func(pageCount int) ([]PdfPage, error) {
+		return []PdfPage{{SourcePage: 1}, {SourcePage: 1, Blank: true}, {SourcePage: 2}, {SourcePage: 2, Blank: true}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dims, err := PdfPageDims(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dims) != 4 {
+		t.Fatalf("%d pages, want 4", len(dims))
+	}
+	if dims[0] != (pdfcpu.Dim{Width: 600, Height: 400}) {
+		t.Errorf("cropped and rotated page is %v", dims[0])
+	}
+	if dims[1] != dims[0] {
+		t.Errorf("blank page is %v, want %v like the page before it", dims[1], dims[0])
+	}
+	if dims[3] != dims[2] {
+		t.Errorf("blank page is %v, want %v like the page before it", dims[3], dims[2])
+	}
+}
+
+func TestRearrangePdfDuplicatePage(t *testing.T) {
+	pdf := bytes.Buffer{}
+	links := []PageLink{{Page: 1, Rect: [4]float64{72, 700, 200, 730}, Target: 2}}
+	if err := AddPageLinks(bytes.NewReader(testPdf(t, 2)), &pdf, links); err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.Buffer{}
+	err := RearrangePdf(bytes.NewReader(pdf.Bytes()), &out, func(pageCount int) ([]PdfPage, error) {
+		return []PdfPage{{SourcePage: 1}, {SourcePage: 2}, {SourcePage: 1}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, pageRefs, err := readPdfPages(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pageRefs) != 3 || pageRefs[0] == pageRefs[2] {
+		t.Fatalf("got pages %v, want 3 distinct pages", pageRefs)
+	}
+	annots := make([]pdfcpu.Array, 0)
+	for _, i := range []int{0, 2} {
+		d, err := ctx.DereferenceDict(pageRefs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		annots = append(annots, d.ArrayEntry("Annots"))
+	}
+	if len(annots[0]) != 1 || len(annots[1]) != 1 || annots[0][0] == annots[1][0] {
+		t.Errorf("got annotations %v and %v, want a link each", annots[0], annots[1])
+	}
+
+	err = RearrangePdf(bytes.NewReader(pdf.Bytes()), &out, func(pageCount int) ([]PdfPage, error) {
+		return []PdfPage{{SourcePage: 3}}, nil
+	})
+	if !errors.As(err, new(*DocumentError)) {
+		t.Errorf("got error %v for a page out of range, want a DocumentError", err)
+	}
+}
+
+// outlineItem is an outline item of a test PDF pointing to dest.
+type outlineItem struct {
+	title string
+	dest  pdfcpu.Object
+	kids  []outlineItem
+}
+
+// addOutlineItems adds items as the children of the outline item or outline dictionary parent.
+func addOutlineItems(t *testing.T, ctx *pdfcpu.Context, parentRef pdfcpu.IndirectRef, parent pdfcpu.Dict, items []outlineItem) {
+	t.Helper()
+	refs := make([]pdfcpu.IndirectRef, len(items))
+	dicts := make([]pdfcpu.Dict, len(items))
+	for i, item := range items {
+		dicts[i] = pdfcpu.Dict(map[string]pdfcpu.Object{
+			"Title":  pdfcpu.StringLiteral(item.title),
+			"Parent": parentRef,
+		})
+		if a, ok := item.dest.(pdfcpu.Dict); ok {
+			dicts[i].Insert("A", a)
+		} else if item.dest != nil {
+			dicts[i].Insert("Dest", item.dest)
+		}
+		ref, err := ctx.IndRefForNewObject(dicts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs[i] = *ref
+	}
+	for i, item := range items {
+		if i > 0 {
+			dicts[i].Insert("Prev", refs[i-1])
+		}
+		if i < len(items)-1 {
+			dicts[i].Insert("Next", refs[i+1])
+		}
+		if len(item.kids) > 0 {
+			addOutlineItems(t, ctx, refs[i], dicts[i], item.kids)
+		}
+	}
+	parent.Insert("First", refs[0])
+	parent.Insert("Last", refs[len(refs)-1])
+	parent.Insert("Count", pdfcpu.Integer(len(items)))
+}
+
+// outlineTitles returns the titles of the descendants of the outline item d, indented by their depth, with the
+// number of the page they point to, or 0.
+func outlineTitles(t *testing.T, ctx *pdfcpu.Context, pageRefs []pdfcpu.IndirectRef, named map[string]pdfcpu.Object, d pdfcpu.Dict, indent string) []string {
+	t.Helper()
+	res := make([]string, 0)
+	for o := d["First"]; o != nil; {
+		kid, err := ctx.DereferenceDict(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		title, _ := pdfcpu.Text(kid["Title"])
+		dest, err := outlineDest(ctx, kid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := 0
+		if ref, ok := destPage(ctx, dest, named, 0); ok {
+			for i, pageRef := range pageRefs {
+				if ref == pageRef {
+					page = i + 1
+				}
+			}
+		}
+		res = append(res, fmt.Sprintf("%s%s %d", indent, title, page))
+		res = append(res, outlineTitles(t, ctx, pageRefs, named, kid, indent+"  ")...)
+		o = kid["Next"]
+	}
+	return res
+}
+
+func TestRearrangePdfPrunesDests(t *testing.T) {
+	ctx, pageRefs, err := readPdfPages(bytes.NewReader(testPdf(t, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fit := func(i int) pdfcpu.Array {
+		return pdfcpu.Array{pageRefs[i], pdfcpu.Name("Fit")}
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.Insert("Dests", pdfcpu.Dict(map[string]pdfcpu.Object{"one": fit(0), "two": fit(1)}))
+	root.Insert("Names", pdfcpu.Dict(map[string]pdfcpu.Object{
+		"Dests": pdfcpu.Dict(map[string]pdfcpu.Object{
+			"Names": pdfcpu.Array{pdfcpu.StringLiteral("three"), fit(2), pdfcpu.StringLiteral("two"), fit(1)},
+		}),
+	}))
+	outlines := pdfcpu.NewDict()
+	outlines.Insert("Type", pdfcpu.Name("Outlines"))
+	outlinesRef, err := ctx.IndRefForNewObject(outlines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.Insert("Outlines", *outlinesRef)
+	goTo := func(dest pdfcpu.Object) pdfcpu.Dict {
+		return pdfcpu.Dict(map[string]pdfcpu.Object{"S": pdfcpu.Name("GoTo"), "D": dest})
+	}
+	addOutlineItems(t, ctx, *outlinesRef, outlines, []outlineItem{
+		{title: "One", dest: pdfcpu.Name("one")},
+		{title: "Two", dest: fit(1), kids: []outlineItem{
+			{title: "Two named", dest: pdfcpu.StringLiteral("two")},
+			{title: "Two action", dest: goTo(fit(1))},
+		}},
+		{title: "Section", dest: pdfcpu.Name("two"), kids: []outlineItem{
+			{title: "Three", dest: goTo(pdfcpu.StringLiteral("three"))},
+			{title: "Two", dest: fit(1)},
+		}},
+	})
+	pdf := bytes.Buffer{}
+	if err = api.WriteContext(ctx, &pdf); err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.Buffer{}
+	err = RearrangePdf(bytes.NewReader(pdf.Bytes()), &out, func(pageCount int) ([]PdfPage, error) {
+		return []PdfPage{{SourcePage: 1}, {SourcePage: 3}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, pageRefs, err = readPdfPages(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := 0
+	for _, entry := range ctx.Table {
+		if d, ok := entry.Object.(pdfcpu.Dict); ok && d.Type() != nil && *d.Type() == "Page" {
+			pages++
+		}
+	}
+	if pages != 2 {
+		t.Errorf("%d pages written, want 2", pages)
+	}
+
+	root, err = ctx.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := namedDests(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(named) != 2 || named["one"] == nil || named["three"] == nil {
+		t.Errorf("got named destinations %v, want one and three", named)
+	}
+
+	outlines, err = ctx.DereferenceDict(root["Outlines"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := outlineTitles(t, ctx, pageRefs, named, outlines, "")
+	want := []string{"One 1", "Section 0", "  Three 2"}
+	if strings.Join(titles, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got outline %q, want %q", titles, want)
+	}
+	if count := outlines.IntEntry("Count"); count == nil || *count != 3 {
+		t.Errorf("got outline count %v, want 3", count)
+	}
+}
+
+func TestScalePdf(t *testing.T) {
+	pages := []rawPdfPage{{width: 612, height: 792}, {width: 842, height: 595}, {width: 595, height: 842}}
+	pdf := bytes.Buffer{}
+	if err := writeRawPdf(&pdf, pages); err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.Buffer{}
+	if err := ScalePdf(bytes.NewReader(pdf.Bytes()), &out, []float64{0.5, 2, 1}); err != nil {
+		t.Fatal(err)
+	}
+	dims, err := PdfPageDims(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pdfcpu.Dim{{Width: 306, Height: 396}, {Width: 1684, Height: 1190}, {Width: 595, Height: 842}}
+	if fmt.Sprint(dims) != fmt.Sprint(want) {
+		t.Errorf("scaled pages are %v, want %v", dims, want)
+	}
+
+	if err = ScalePdf(bytes.NewReader(pdf.Bytes()), &out, []float64{2}); err == nil {
+		t.Errorf("scaling 3 pages by 1 factor succeeded")
+	}
+}
+
+// The benchmarks edit a 200 page PDF by inserting 2 blank pages after and deleting single pages at 5 places each.
+const benchPageCount = 200
+
+var (
+	benchInserts = []int{20, 60, 100, 140, 180}
+	benchDeletes = []int{40, 80, 120, 160, 200}
+)
+
+func BenchmarkRearrangePdf(b *testing.B) {
+	pdf := testPdf(b, benchPageCount)
+	inserted := make(map[int]bool)
+	for _, p := range benchInserts {
+		inserted[p] = true
+	}
+	deleted := make(map[int]bool)
+	for _, p := range benchDeletes {
+		deleted[p] = true
+	}
+	plan := func(pageCount int) ([]PdfPage, error) {
+		pages := make([]PdfPage, 0, pageCount+2*len(benchInserts))
+		for p := 1; p <= pageCount; p++ {
+			if !deleted[p] {
+				pages = append(pages, PdfPage{SourcePage: p})
+			}
+			if inserted[p] {
+				pages = append(pages, PdfPage{SourcePage: p, Blank: true}, PdfPage{SourcePage: p, Blank: true})
+			}
+		}
+		return pages, nil
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := RearrangePdf(bytes.NewReader(pdf), io.Discard, plan); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkInsertRemovePages is the same edit as BenchmarkRearrangePdf done the way RearrangePdf replaced: reading
+// and writing the whole PDF once per inserted page and per deleted page.
+func BenchmarkInsertRemovePages(b *testing.B) {
+	pdf := testPdf(b, benchPageCount)
+	conf := pdfcpu.NewDefaultConfiguration()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cur := pdf
+		// From the back, so the page numbers of the remaining edits stay valid
+		for k := len(benchInserts) - 1; k >= 0; k-- {
+			for _, step := range []func(r io.ReadSeeker, w io.Writer) error{
+				func(r io.ReadSeeker, w io.Writer) error {
+					return api.RemovePages(r, w, []string{fmt.Sprint(benchDeletes[k])}, conf)
+				},
+				func(r io.ReadSeeker, w io.Writer) error {
+					return api.InsertPages(r, w, []string{fmt.Sprint(benchInserts[k])}, false, conf)
+				},
+				func(r io.ReadSeeker, w io.Writer) error {
+					return api.InsertPages(r, w, []string{fmt.Sprint(benchInserts[k])}, false, conf)
+				},
+			} {
+				out := bytes.Buffer{}
+				if err := step(bytes.NewReader(cur), &out); err != nil {
+					b.Fatal(err)
+				}
+				cur = out.Bytes()
+			}
+		}
+	}
+}
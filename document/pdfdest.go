@@ -0,0 +1,312 @@
+package document
+
+import (
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// maxDestDepth bounds how often destPage follows names and dictionaries, against reference cycles.
+const maxDestDepth = 8
+
+// copyPage adds a copy of the page ref to ctx, so it can be part of the page tree a second time. Its annotations are
+// copied as well, as an annotation belongs to a single page. Popups are dropped, they belong to the original.
+func copyPage(ctx *pdfcpu.Context, ref pdfcpu.IndirectRef) (pdfcpu.IndirectRef, error) {
+	d, err := ctx.DereferenceDict(ref)
+	if err != nil {
+		return pdfcpu.IndirectRef{}, err
+	}
+	pageDict := d.Clone().(pdfcpu.Dict)
+	pageRef, err := ctx.IndRefForNewObject(pageDict)
+	if err != nil {
+		return pdfcpu.IndirectRef{}, err
+	}
+
+	o, ok := pageDict.Find("Annots")
+	if !ok {
+		return *pageRef, nil
+	}
+	annots, err := ctx.DereferenceArray(o)
+	if err != nil {
+		return pdfcpu.IndirectRef{}, err
+	}
+	copied := pdfcpu.Array{}
+	for _, o := range annots {
+		annot, err := ctx.DereferenceDict(o)
+		if err != nil {
+			return pdfcpu.IndirectRef{}, err
+		}
+		if annot == nil || annot.Subtype() != nil && *annot.Subtype() == "Popup" {
+			continue
+		}
+		annot = annot.Clone().(pdfcpu.Dict)
+		annot.Delete("Popup")
+		if _, ok := annot.Find("P"); ok {
+			annot.Update("P", *pageRef)
+		}
+		annotRef, err := ctx.IndRefForNewObject(annot)
+		if err != nil {
+			return pdfcpu.IndirectRef{}, err
+		}
+		copied = append(copied, *annotRef)
+	}
+	pageDict.Update("Annots", copied)
+	return *pageRef, nil
+}
+
+// pruneDests removes the outline items and named destinations of ctx which point to one of the dropped pages, so
+// the dropped pages aren't written. Outline items with children are kept without their destination.
+func pruneDests(ctx *pdfcpu.Context, dropped map[pdfcpu.IndirectRef]bool) error {
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	named, err := namedDests(ctx, root)
+	if err != nil {
+		return err
+	}
+	isDropped := func(dest pdfcpu.Object) bool {
+		ref, ok := destPage(ctx, dest, named, 0)
+		return ok && dropped[ref]
+	}
+
+	if o, ok := root.Find("Outlines"); ok {
+		outlines, err := ctx.DereferenceDict(o)
+		if err != nil {
+			return err
+		}
+		if outlines != nil {
+			_, err = pruneOutlineItems(ctx, outlines, isDropped)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if o, ok := root.Find("Dests"); ok {
+		dests, err := ctx.DereferenceDict(o)
+		if err != nil {
+			return err
+		}
+		for name, dest := range dests {
+			if isDropped(dest) {
+				dests.Delete(name)
+			}
+		}
+	}
+	tree, err := destNameTree(ctx)
+	if err != nil || tree == nil {
+		return err
+	}
+	return pruneNameTree(ctx, tree, isDropped)
+}
+
+// pruneOutlineItems removes the descendants of the outline item or outline dictionary d whose destination is dropped
+// and returns the number of descendants of d which are visible when d is open.
+func pruneOutlineItems(ctx *pdfcpu.Context, d pdfcpu.Dict, isDropped func(dest pdfcpu.Object) bool) (int, error) {
+	type item struct {
+		ref  pdfcpu.IndirectRef
+		dict pdfcpu.Dict
+	}
+	kept := make([]item, 0)
+	visible := 0
+	o, _ := d.Find("First")
+	for i := 0; o != nil; i++ {
+		// A corrupt outline may link its items in a cycle
+		ref, ok := o.(pdfcpu.IndirectRef)
+		if !ok || i > len(ctx.Table) {
+			break
+		}
+		kid, err := ctx.DereferenceDict(ref)
+		if err != nil {
+			return 0, err
+		}
+		if kid == nil {
+			break
+		}
+		o, _ = kid.Find("Next")
+
+		kidVisible, err := pruneOutlineItems(ctx, kid, isDropped)
+		if err != nil {
+			return 0, err
+		}
+		dest, err := outlineDest(ctx, kid)
+		if err != nil {
+			return 0, err
+		}
+		if dest != nil && isDropped(dest) {
+			if _, ok := kid.Find("First"); !ok {
+				continue
+			}
+			kid.Delete("Dest")
+			kid.Delete("A")
+		}
+		kept = append(kept, item{ref, kid})
+		visible++
+		if count := kid.IntEntry("Count"); count != nil && *count > 0 {
+			visible += kidVisible
+		}
+	}
+
+	if len(kept) == 0 {
+		d.Delete("First")
+		d.Delete("Last")
+		d.Delete("Count")
+		return 0, nil
+	}
+	d.Update("First", kept[0].ref)
+	d.Update("Last", kept[len(kept)-1].ref)
+	for i, kid := range kept {
+		kid.dict.Delete("Prev")
+		kid.dict.Delete("Next")
+		if i > 0 {
+			kid.dict.Insert("Prev", kept[i-1].ref)
+		}
+		if i < len(kept)-1 {
+			kid.dict.Insert("Next", kept[i+1].ref)
+		}
+	}
+	// A negative count marks a closed item
+	if count := d.IntEntry("Count"); count != nil && *count < 0 {
+		d.Update("Count", pdfcpu.Integer(-visible))
+	} else {
+		d.Update("Count", pdfcpu.Integer(visible))
+	}
+	return visible, nil
+}
+
+// outlineDest returns the destination of the outline item d, or nil if it has none or its action is not a GoTo.
+func outlineDest(ctx *pdfcpu.Context, d pdfcpu.Dict) (pdfcpu.Object, error) {
+	if dest, ok := d.Find("Dest"); ok {
+		return dest, nil
+	}
+	o, ok := d.Find("A")
+	if !ok {
+		return nil, nil
+	}
+	action, err := ctx.DereferenceDict(o)
+	if err != nil || action == nil {
+		return nil, err
+	}
+	if s := action.NameEntry("S"); s == nil || *s != "GoTo" {
+		return nil, nil
+	}
+	dest, _ := action.Find("D")
+	return dest, nil
+}
+
+// destPage returns the page the destination dest points to, following named destinations.
+func destPage(ctx *pdfcpu.Context, dest pdfcpu.Object, named map[string]pdfcpu.Object, depth int) (pdfcpu.IndirectRef, bool) {
+	if depth > maxDestDepth {
+		return pdfcpu.IndirectRef{}, false
+	}
+	if ref, ok := dest.(pdfcpu.IndirectRef); ok {
+		o, err := ctx.Dereference(ref)
+		if err != nil {
+			return pdfcpu.IndirectRef{}, false
+		}
+		if d, ok := o.(pdfcpu.Dict); ok && d.Type() != nil && *d.Type() == "Page" {
+			return ref, true
+		}
+		dest = o
+	}
+
+	switch d := dest.(type) {
+	case pdfcpu.Array:
+		if len(d) == 0 {
+			return pdfcpu.IndirectRef{}, false
+		}
+		ref, ok := d[0].(pdfcpu.IndirectRef)
+		return ref, ok
+	case pdfcpu.Dict:
+		return destPage(ctx, d["D"], named, depth+1)
+	case pdfcpu.Name, pdfcpu.StringLiteral, pdfcpu.HexLiteral:
+		key, ok := destName(d)
+		if !ok {
+			return pdfcpu.IndirectRef{}, false
+		}
+		return destPage(ctx, named[key], named, depth+1)
+	}
+	return pdfcpu.IndirectRef{}, false
+}
+
+// destName returns the name of a named destination, given as a name or a string.
+func destName(o pdfcpu.Object) (string, bool) {
+	switch name := o.(type) {
+	case pdfcpu.Name:
+		return string(name), true
+	case pdfcpu.StringLiteral:
+		// Like the keys of pdfcpu's name trees
+		return string(name), true
+	case pdfcpu.HexLiteral:
+		b, err := name.Bytes()
+		return string(b), err == nil
+	}
+	return "", false
+}
+
+// namedDests returns the named destinations of the catalog root, of both its Dests dictionary and its Dests name tree.
+func namedDests(ctx *pdfcpu.Context, root pdfcpu.Dict) (map[string]pdfcpu.Object, error) {
+	named := make(map[string]pdfcpu.Object)
+	if o, ok := root.Find("Dests"); ok {
+		dests, err := ctx.DereferenceDict(o)
+		if err != nil {
+			return nil, err
+		}
+		for name, dest := range dests {
+			named[name] = dest
+		}
+	}
+
+	tree, err := destNameTree(ctx)
+	if err != nil || tree == nil {
+		return named, err
+	}
+	err = tree.Process(ctx.XRefTable, func(_ *pdfcpu.XRefTable, name string, dest pdfcpu.Object) error {
+		named[name] = dest
+		return nil
+	})
+	return named, err
+}
+
+// destNameTree returns the Dests name tree of ctx, or nil if it has none. pdfcpu writes name trees from its cache
+// instead of the catalog, so they are changed through it.
+func destNameTree(ctx *pdfcpu.Context) (*pdfcpu.Node, error) {
+	err := ctx.LocateNameTree("Dests", false)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.Names["Dests"], nil
+}
+
+// pruneNameTree removes the entries of the Dests name tree of ctx whose destination is dropped. The tree is rebuilt
+// from the entries kept, as removing entries from it would delete the objects they refer to, i.e. the pages.
+func pruneNameTree(ctx *pdfcpu.Context, tree *pdfcpu.Node, isDropped func(dest pdfcpu.Object) bool) error {
+	type entry struct {
+		name string
+		dest pdfcpu.Object
+	}
+	kept := make([]entry, 0)
+	pruned := false
+	err := tree.Process(ctx.XRefTable, func(_ *pdfcpu.XRefTable, name string, dest pdfcpu.Object) error {
+		if isDropped(dest) {
+			pruned = true
+		} else {
+			kept = append(kept, entry{name, dest})
+		}
+		return nil
+	})
+	if err != nil || !pruned {
+		return err
+	}
+
+	tree.D.Delete("Kids")
+	tree.D.Delete("Names")
+	rebuilt := &pdfcpu.Node{D: tree.D}
+	for _, e := range kept {
+		err = rebuilt.Add(ctx.XRefTable, e.name, e.dest)
+		if err != nil {
+			return err
+		}
+	}
+	ctx.Names["Dests"] = rebuilt
+	return nil
+}
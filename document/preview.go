@@ -0,0 +1,95 @@
+package document
+
+import (
+	"fmt"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"io"
+)
+
+// previewThumbnails is the number of page thumbnails per page of a preview.
+const previewThumbnails = 9
+
+// PreviewMark describes what happens to a page shown in a preview.
+type PreviewMark int
+
+const (
+	// PreviewKept is a page of the original PDF which is kept.
+	PreviewKept PreviewMark = iota
+	// PreviewInserted is an inserted blank page.
+	PreviewInserted
+	// PreviewDeleted is a page of the original PDF which is deleted.
+	PreviewDeleted
+)
+
+// previewMarkColors are the colors of the labels of marked pages.
+var previewMarkColors = map[PreviewMark]string{
+	PreviewKept:     "0.5 0.5 0.5",
+	PreviewInserted: "0 0.6 0",
+	PreviewDeleted:  "0.8 0 0",
+}
+
+// PreviewPage is a page shown in a preview, see WritePreviewPdf.
+type PreviewPage struct {
+	PdfPage
+	Mark PreviewMark
+}
+
+// label is the text stamped on the page's thumbnail.
+func (p PreviewPage) label() string {
+	switch p.Mark {
+	case PreviewInserted:
+		return "inserted"
+	case PreviewDeleted:
+		return fmt.Sprintf("deleted %d", p.SourcePage)
+	default:
+		return fmt.Sprintf("page %d", p.SourcePage)
+	}
+}
+
+// WritePreviewPdf writes a preview of the pages planned by plan to w: thumbnails of the pages in order, each labelled with
+// its original page number or whether it is inserted or deleted. plan is called with the page count of pdf.
+func WritePreviewPdf(env *Env, pdf io.ReadSeeker, w io.Writer, plan func(pageCount int) ([]PreviewPage, error)) error {
+	conf := pdfcpu.NewDefaultConfiguration()
+
+	var pages []PreviewPage
+	arranged := env.NewBlob()
+	defer arranged.Close()
+	err := RearrangePdf(pdf, arranged, func(pageCount int) ([]PdfPage, error) {
+		var err error
+		pages, err = plan(pageCount)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]PdfPage, len(pages))
+		for i := range pages {
+			res[i] = pages[i].PdfPage
+		}
+		return res, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	wms := make(map[int]*pdfcpu.Watermark)
+	for i, p := range pages {
+		desc := fmt.Sprintf("fontname:Helvetica, points:48, color:%s, rotation:0, scalefactor:0.6, opacity:0.8", previewMarkColors[p.Mark])
+		wm, err := api.TextWatermark(p.label(), desc, true, false, pdfcpu.POINTS)
+		if err != nil {
+			return err
+		}
+		wms[i+1] = wm
+	}
+	labelled := env.NewBlob()
+	defer labelled.Close()
+	err = api.AddWatermarksMap(arranged.Reader(), labelled, wms, conf)
+	if err != nil {
+		return documentError("label preview pages", err)
+	}
+
+	nup, err := api.PDFNUpConfig(previewThumbnails, "")
+	if err != nil {
+		return err
+	}
+	return documentError("create preview thumbnails", api.NUp(labelled.Reader(), w, nil, nil, nup, conf))
+}
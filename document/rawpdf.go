@@ -0,0 +1,60 @@
+package document
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// rawPdfPage is a page of a PDF written by writeRawPdf.
+type rawPdfPage struct {
+	width, height float64
+	// content is the content stream of the page, it may use Courier as font /F1.
+	content string
+}
+
+// writeRawPdf writes a PDF consisting of pages to w.
+func writeRawPdf(w io.Writer, pages []rawPdfPage) error {
+	// Objects: 1 catalog, 2 page tree, 3 font, then a page and its content stream for each page
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+	}
+	kids := make([]string, len(pages))
+	for i, page := range pages {
+		pageObj := len(objects) + 1
+		kids[i] = fmt.Sprintf("%d 0 R", pageObj)
+		objects = append(objects, fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfNumber(page.width), pdfNumber(page.height), pageObj+1,
+		))
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(page.content), page.content))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	buf := bytes.Buffer{}
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// pdfNumber formats f for a PDF content stream, with at most two decimals.
+func pdfNumber(f float64) string {
+	s := fmt.Sprintf("%.2f", f)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
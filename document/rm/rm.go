@@ -0,0 +1,238 @@
+// Package rm decodes and encodes the .rm files which store the annotations of a single page.
+package rm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Version is the version of the .rm format, as found in the file header.
+type Version int
+
+const (
+	V3 Version = 3
+	V5 Version = 5
+	V6 Version = 6
+)
+
+const headerLen = 43
+
+// ErrUnsupportedVersion is returned when decoding a .rm file whose header is not known.
+var ErrUnsupportedVersion = errors.New("unsupported .rm version")
+
+// ErrUnsupportedEdit is returned when encoding a decoded v6 page which was changed in a way that cannot be written
+// back to its block stream, e.g. by reordering its existing layers or lines.
+var ErrUnsupportedEdit = errors.New("unsupported edit of a v6 page")
+
+func header(v Version) string {
+	h := fmt.Sprintf("reMarkable .lines file, version=%d", v)
+	return h + strings.Repeat(" ", headerLen-len(h))
+}
+
+// Page is the decoded content of a .rm file.
+type Page struct {
+	Version Version
+	Layers  []*Layer
+	// Text is the typed text of the page, nil if the page has none.
+	Text *Text
+
+	// blocks is the v6 block stream the page was decoded from. Layers and Text point into it,
+	// so edits to existing lines and points are kept when encoding; added and removed layers
+	// and lines are written back to it by syncBlocks.
+	blocks []*block
+	// layerIds are the ids of the groups the layers were decoded from.
+	layerIds map[*Layer]CrdtId
+}
+
+// Layer is a named group of lines.
+type Layer struct {
+	Name    string
+	Visible bool
+	Lines   []*Line
+}
+
+// Line is a single stroke.
+type Line struct {
+	Pen   Pen
+	Color Color
+	// ThicknessScale is the brush size chosen in the toolbar.
+	ThicknessScale float64
+	StartingLength float32
+	Points         []Point
+
+	// padding is an unused field of v3 and v5 lines, kept for lossless round trips.
+	padding uint32
+}
+
+// Point is a sample of a stroke. Width is in pixels, Direction in radians and Pressure in [0, 1].
+type Point struct {
+	X         float32
+	Y         float32
+	Speed     float32
+	Direction float32
+	Width     float32
+	Pressure  float32
+}
+
+// Text is the typed text of a page.
+type Text struct {
+	Items  []TextItem
+	Styles []TextStyle
+	PosX   float64
+	PosY   float64
+	Width  float32
+}
+
+// TextItem is an element of the CRDT sequence of characters making up a Text.
+type TextItem struct {
+	ItemId        CrdtId
+	LeftId        CrdtId
+	RightId       CrdtId
+	DeletedLength uint32
+	Value         string
+	// Format is set for items which carry a formatting code instead of characters.
+	Format *uint32
+	// hasValue is false for deleted items, which are stored without a value subblock.
+	hasValue bool
+}
+
+// TextStyle sets the ParagraphStyle of the paragraph starting at character CharId.
+type TextStyle struct {
+	CharId    CrdtId
+	Timestamp CrdtId
+	Style     ParagraphStyle
+}
+
+// String returns the text content, ignoring formatting.
+func (t *Text) String() string {
+	var sb strings.Builder
+	for _, item := range t.Items {
+		sb.WriteString(item.Value)
+	}
+	return sb.String()
+}
+
+// Pen is the tool a line was drawn with.
+type Pen uint32
+
+const (
+	Paintbrush1       Pen = 0
+	Pencil1           Pen = 1
+	Ballpoint1        Pen = 2
+	Marker1           Pen = 3
+	Fineliner1        Pen = 4
+	Highlighter1      Pen = 5
+	Eraser            Pen = 6
+	MechanicalPencil1 Pen = 7
+	EraserArea        Pen = 8
+	Paintbrush2       Pen = 12
+	MechanicalPencil2 Pen = 13
+	Pencil2           Pen = 14
+	Ballpoint2        Pen = 15
+	Marker2           Pen = 16
+	Fineliner2        Pen = 17
+	Highlighter2      Pen = 18
+	Calligraphy       Pen = 21
+	Shader            Pen = 23
+)
+
+// Color is the colour a line was drawn in.
+type Color uint32
+
+const (
+	Black       Color = 0
+	Gray        Color = 1
+	White       Color = 2
+	Yellow      Color = 3
+	Green       Color = 4
+	Pink        Color = 5
+	Blue        Color = 6
+	Red         Color = 7
+	GrayOverlap Color = 8
+	Highlight   Color = 9
+	Green2      Color = 10
+	Cyan        Color = 11
+	Magenta     Color = 12
+	Yellow2     Color = 13
+)
+
+// ParagraphStyle is the style of a paragraph of typed text.
+type ParagraphStyle uint8
+
+const (
+	StyleBasic           ParagraphStyle = 0
+	StylePlain           ParagraphStyle = 1
+	StyleHeading         ParagraphStyle = 2
+	StyleBold            ParagraphStyle = 3
+	StyleBullet          ParagraphStyle = 4
+	StyleBullet2         ParagraphStyle = 5
+	StyleCheckbox        ParagraphStyle = 6
+	StyleCheckboxChecked ParagraphStyle = 7
+)
+
+// Decode decodes a .rm file.
+func Decode(data []byte) (*Page, error) {
+	if len(data) < headerLen {
+		return nil, fmt.Errorf("%w: file too short for header", ErrUnsupportedVersion)
+	}
+	switch string(data[:headerLen]) {
+	case header(V3):
+		return decodeV5(data[headerLen:], V3)
+	case header(V5):
+		return decodeV5(data[headerLen:], V5)
+	case header(V6):
+		return decodeV6(data[headerLen:])
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedVersion, strings.TrimSpace(string(data[:headerLen])))
+	}
+}
+
+// Encode encodes page in the format of page.Version.
+func Encode(page *Page) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	switch page.Version {
+	case V3, V5:
+		buf.WriteString(header(page.Version))
+		encodeV5(buf, page)
+	case V6:
+		buf.WriteString(header(V6))
+		if err := encodeV6(buf, page); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, page.Version)
+	}
+	return buf.Bytes(), nil
+}
+
+// Upgrade converts a page decoded from an older version to V6, so it is encoded in the current format.
+// Layers, lines and points are kept as they are, since all versions share the same representation.
+func (p *Page) Upgrade() {
+	if p.Version == V6 {
+		return
+	}
+	p.Version = V6
+	p.blocks = nil
+	p.layerIds = nil
+}
+
+// DecodeLatest decodes a .rm file of any supported version and upgrades it to V6.
+func DecodeLatest(data []byte) (*Page, error) {
+	page, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	page.Upgrade()
+	return page, nil
+}
+
+// Lines returns all lines of the page, in layer order.
+func (p *Page) Lines() []*Line {
+	lines := make([]*Line, 0)
+	for _, layer := range p.Layers {
+		lines = append(lines, layer.Lines...)
+	}
+	return lines
+}
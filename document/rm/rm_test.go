@@ -0,0 +1,289 @@
+package rm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The pages in testdata are written by testdata/gen.py, which encodes them independently of this package. Each has the
+// following content.
+var goldenPages = []struct {
+	file    string
+	version Version
+	// lines are the number of lines of each layer
+	lines []int
+	text  string
+}{
+	{"v3.rm", V3, []int{2, 1}, ""},
+	{"v5.rm", V5, []int{2, 1}, ""},
+	{"v6.rm", V6, []int{2}, "Hello"},
+}
+
+func readGolden(t *testing.T, file string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func decodeGolden(t *testing.T, file string) *Page {
+	t.Helper()
+	page, err := Decode(readGolden(t, file))
+	if err != nil {
+		t.Fatalf("decoding %s: %v", file, err)
+	}
+	return page
+}
+
+func reencode(t *testing.T, page *Page) *Page {
+	t.Helper()
+	data, err := Encode(page)
+	if err != nil {
+		t.Fatalf("encoding: %v", err)
+	}
+	page, err = Decode(data)
+	if err != nil {
+		t.Fatalf("decoding encoded page: %v", err)
+	}
+	return page
+}
+
+func layerLines(page *Page) []int {
+	lines := make([]int, len(page.Layers))
+	for i, layer := range page.Layers {
+		lines[i] = len(layer.Lines)
+	}
+	return lines
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDecodeGolden(t *testing.T) {
+	for _, tt := range goldenPages {
+		t.Run(tt.file, func(t *testing.T) {
+			page := decodeGolden(t, tt.file)
+			if page.Version != tt.version {
+				t.Errorf("version %d, want %d", page.Version, tt.version)
+			}
+			if lines := layerLines(page); !equalInts(lines, tt.lines) {
+				t.Errorf("lines per layer %v, want %v", lines, tt.lines)
+			}
+			text := ""
+			if page.Text != nil {
+				text = page.Text.String()
+			}
+			if text != tt.text {
+				t.Errorf("text %q, want %q", text, tt.text)
+			}
+		})
+	}
+}
+
+func TestRoundTripGolden(t *testing.T) {
+	for _, tt := range goldenPages {
+		t.Run(tt.file, func(t *testing.T) {
+			data := readGolden(t, tt.file)
+			page, err := Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := Encode(page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(encoded, data) {
+				t.Errorf("encoded page differs from %s", tt.file)
+			}
+		})
+	}
+}
+
+func TestUpgradeGolden(t *testing.T) {
+	for _, tt := range goldenPages {
+		t.Run(tt.file, func(t *testing.T) {
+			want := decodeGolden(t, tt.file)
+			page := decodeGolden(t, tt.file)
+			page.Upgrade()
+			got := reencode(t, page)
+			if got.Version != V6 {
+				t.Fatalf("version %d after upgrade", got.Version)
+			}
+			if !equalLines(got.Lines(), want.Lines()) {
+				t.Errorf("lines changed by upgrade")
+			}
+		})
+	}
+}
+
+func equalLines(a, b []*Line) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Pen != b[i].Pen || a[i].Color != b[i].Color || a[i].ThicknessScale != b[i].ThicknessScale ||
+			len(a[i].Points) != len(b[i].Points) {
+			return false
+		}
+		for j := range a[i].Points {
+			if a[i].Points[j] != b[i].Points[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func newLine(x float32) *Line {
+	return &Line{
+		Pen:            Fineliner2,
+		Color:          Blue,
+		ThicknessScale: 2,
+		Points:         []Point{{X: x, Y: 10, Width: 2, Pressure: 0.5}, {X: x + 10, Y: 20, Width: 2, Pressure: 0.5}},
+	}
+}
+
+func TestEditV6(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(page *Page)
+		lines []int
+		names []string
+	}{
+		{"unchanged", func(page *Page) {}, []int{2}, []string{"Layer 1"}},
+		{"append line", func(page *Page) {
+			page.Layers[0].Lines = append(page.Layers[0].Lines, newLine(1))
+		}, []int{3}, []string{"Layer 1"}},
+		{"insert line", func(page *Page) {
+			lines := page.Layers[0].Lines
+			page.Layers[0].Lines = []*Line{lines[0], newLine(1), lines[1]}
+		}, []int{3}, []string{"Layer 1"}},
+		{"remove line", func(page *Page) {
+			page.Layers[0].Lines = page.Layers[0].Lines[1:]
+		}, []int{1}, []string{"Layer 1"}},
+		{"add layer", func(page *Page) {
+			page.Layers = append(page.Layers, &Layer{Name: "Layer 2", Visible: true, Lines: []*Line{newLine(1), newLine(2)}})
+		}, []int{2, 2}, []string{"Layer 1", "Layer 2"}},
+		{"add layer before", func(page *Page) {
+			page.Layers = append([]*Layer{{Name: "Layer 0", Visible: true, Lines: []*Line{newLine(1)}}}, page.Layers...)
+		}, []int{1, 2}, []string{"Layer 0", "Layer 1"}},
+		{"remove layer", func(page *Page) {
+			page.Layers = []*Layer{{Name: "New", Visible: true, Lines: []*Line{newLine(1)}}}
+		}, []int{1}, []string{"New"}},
+		{"move line to new layer", func(page *Page) {
+			line := page.Layers[0].Lines[1]
+			page.Layers[0].Lines = page.Layers[0].Lines[:1]
+			page.Layers = append(page.Layers, &Layer{Name: "Layer 2", Visible: true, Lines: []*Line{line}})
+		}, []int{1, 1}, []string{"Layer 1", "Layer 2"}},
+		{"rename layer", func(page *Page) {
+			page.Layers[0].Name = "Renamed"
+		}, []int{2}, []string{"Renamed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := decodeGolden(t, "v6.rm")
+			tt.edit(page)
+			want := page.Lines()
+
+			got := reencode(t, page)
+			if lines := layerLines(got); !equalInts(lines, tt.lines) {
+				t.Fatalf("lines per layer %v, want %v", lines, tt.lines)
+			}
+			for i, layer := range got.Layers {
+				if layer.Name != tt.names[i] {
+					t.Errorf("layer %d is called %q, want %q", i, layer.Name, tt.names[i])
+				}
+			}
+			if !equalLines(got.Lines(), want) {
+				t.Errorf("lines differ from the edited page")
+			}
+			if got.Text == nil || got.Text.String() != "Hello" {
+				t.Errorf("text was lost")
+			}
+			// The highlight, which is kept raw, must survive the edit
+			if n := countBlocks(got, blockSceneGlyphItem); n != 1 {
+				t.Errorf("%d glyph items, want 1", n)
+			}
+
+			// Encoding again must not change the page any further
+			again := reencode(t, got)
+			if !equalLines(again.Lines(), want) {
+				t.Errorf("lines changed when encoding again")
+			}
+		})
+	}
+}
+
+func countBlocks(page *Page, typ uint8) int {
+	n := 0
+	for _, b := range page.blocks {
+		if b.typ == typ {
+			n++
+		}
+	}
+	return n
+}
+
+func TestEditV6Unsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(page *Page)
+	}{
+		{"reorder lines", func(page *Page) {
+			lines := page.Layers[0].Lines
+			page.Layers[0].Lines = []*Line{lines[1], lines[0]}
+		}},
+		{"remove text", func(page *Page) {
+			page.Text = nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := decodeGolden(t, "v6.rm")
+			tt.edit(page)
+			if _, err := Encode(page); !errors.Is(err, ErrUnsupportedEdit) {
+				t.Errorf("got error %v, want ErrUnsupportedEdit", err)
+			}
+		})
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	v5 := readGolden(t, "v5.rm")
+	// The point count of the first line follows the header, layer count, line count and line header
+	pointCount := headerLen + 4 + 4 + 20
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated v5", v5[:len(v5)-10]},
+		{"huge point count", func() []byte {
+			data := append([]byte(nil), v5...)
+			copy(data[pointCount:], []byte{0xff, 0xff, 0xff, 0x7f})
+			return data
+		}()},
+		{"truncated v6", readGolden(t, "v6.rm")[:200]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.data); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("got error %v, want io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+}
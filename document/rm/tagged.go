@@ -0,0 +1,364 @@
+package rm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Tag types used by the v6 format, stored in the lower 4 bits of a tag.
+const (
+	tagByte1   = 0x1
+	tagByte4   = 0x4
+	tagByte8   = 0x8
+	tagLength4 = 0xC
+	tagId      = 0xF
+)
+
+var errUnexpectedTag = errors.New("unexpected tag")
+
+// CrdtId identifies an item in the CRDT data structures of a v6 file.
+type CrdtId struct {
+	Part1 uint8
+	Part2 uint64
+}
+
+func (id CrdtId) String() string {
+	return fmt.Sprintf("%d:%d", id.Part1, id.Part2)
+}
+
+// taggedReader reads the tagged values that make up the content of v6 blocks.
+type taggedReader struct {
+	data []byte
+	pos  int
+	// ends holds the end offsets of the currently open subblocks, the last one being the innermost.
+	ends []int
+}
+
+func newTaggedReader(data []byte) *taggedReader {
+	return &taggedReader{data: data, ends: []int{len(data)}}
+}
+
+func (r *taggedReader) end() int {
+	return r.ends[len(r.ends)-1]
+}
+
+func (r *taggedReader) remaining() int {
+	return r.end() - r.pos
+}
+
+func (r *taggedReader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > r.end() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *taggedReader) uint8() (uint8, error) {
+	b, err := r.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *taggedReader) uint16() (uint16, error) {
+	b, err := r.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (r *taggedReader) uint32() (uint32, error) {
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (r *taggedReader) float32() (float32, error) {
+	v, err := r.uint32()
+	return math.Float32frombits(v), err
+}
+
+func (r *taggedReader) float64() (float64, error) {
+	b, err := r.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
+
+func (r *taggedReader) varuint() (uint64, error) {
+	var res uint64
+	var shift uint
+	for {
+		b, err := r.uint8()
+		if err != nil {
+			return 0, err
+		}
+		res |= uint64(b&0x7F) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return res, nil
+		}
+		if shift > 63 {
+			return 0, errors.New("varuint overflows 64 bits")
+		}
+	}
+}
+
+func (r *taggedReader) crdtId() (CrdtId, error) {
+	p1, err := r.uint8()
+	if err != nil {
+		return CrdtId{}, err
+	}
+	p2, err := r.varuint()
+	if err != nil {
+		return CrdtId{}, err
+	}
+	return CrdtId{Part1: p1, Part2: p2}, nil
+}
+
+// peekTag reports whether the next tag in the current block is (index, tagType) without consuming it.
+func (r *taggedReader) peekTag(index int, tagType byte) bool {
+	if r.remaining() == 0 {
+		return false
+	}
+	pos := r.pos
+	defer func() { r.pos = pos }()
+	x, err := r.varuint()
+	if err != nil {
+		return false
+	}
+	return x == uint64(index)<<4|uint64(tagType)
+}
+
+func (r *taggedReader) tag(index int, tagType byte) error {
+	x, err := r.varuint()
+	if err != nil {
+		return err
+	}
+	if x != uint64(index)<<4|uint64(tagType) {
+		return fmt.Errorf("%w: got index %d type %#x, want index %d type %#x", errUnexpectedTag, x>>4, x&0xF, index, tagType)
+	}
+	return nil
+}
+
+func (r *taggedReader) readId(index int) (CrdtId, error) {
+	if err := r.tag(index, tagId); err != nil {
+		return CrdtId{}, err
+	}
+	return r.crdtId()
+}
+
+func (r *taggedReader) readBool(index int) (bool, error) {
+	if err := r.tag(index, tagByte1); err != nil {
+		return false, err
+	}
+	b, err := r.uint8()
+	return b != 0, err
+}
+
+func (r *taggedReader) readByte(index int) (uint8, error) {
+	if err := r.tag(index, tagByte1); err != nil {
+		return 0, err
+	}
+	return r.uint8()
+}
+
+func (r *taggedReader) readInt(index int) (uint32, error) {
+	if err := r.tag(index, tagByte4); err != nil {
+		return 0, err
+	}
+	return r.uint32()
+}
+
+func (r *taggedReader) readFloat(index int) (float32, error) {
+	if err := r.tag(index, tagByte4); err != nil {
+		return 0, err
+	}
+	return r.float32()
+}
+
+func (r *taggedReader) readDouble(index int) (float64, error) {
+	if err := r.tag(index, tagByte8); err != nil {
+		return 0, err
+	}
+	return r.float64()
+}
+
+// openSubblock reads the header of subblock index and makes it the current block until closeSubblock is called.
+func (r *taggedReader) openSubblock(index int) (int, error) {
+	if err := r.tag(index, tagLength4); err != nil {
+		return 0, err
+	}
+	l, err := r.uint32()
+	if err != nil {
+		return 0, err
+	}
+	if r.pos+int(l) > r.end() {
+		return 0, io.ErrUnexpectedEOF
+	}
+	r.ends = append(r.ends, r.pos+int(l))
+	return int(l), nil
+}
+
+// closeSubblock leaves the current subblock, which must have been read completely.
+func (r *taggedReader) closeSubblock() error {
+	end := r.end()
+	r.ends = r.ends[:len(r.ends)-1]
+	if r.pos != end {
+		return fmt.Errorf("%d unread bytes in subblock", end-r.pos)
+	}
+	return nil
+}
+
+func (r *taggedReader) readString(index int) (string, error) {
+	s, _, err := r.readStringWithFormat(index)
+	return s, err
+}
+
+// readStringWithFormat reads a string subblock, which may carry a trailing format code.
+func (r *taggedReader) readStringWithFormat(index int) (string, *uint32, error) {
+	if _, err := r.openSubblock(index); err != nil {
+		return "", nil, err
+	}
+	l, err := r.varuint()
+	if err != nil {
+		return "", nil, err
+	}
+	isAscii, err := r.uint8()
+	if err != nil {
+		return "", nil, err
+	}
+	if isAscii != 1 {
+		return "", nil, fmt.Errorf("unexpected string encoding flag %d", isAscii)
+	}
+	b, err := r.bytes(int(l))
+	if err != nil {
+		return "", nil, err
+	}
+	var format *uint32
+	if r.remaining() > 0 {
+		f, err := r.readInt(2)
+		if err != nil {
+			return "", nil, err
+		}
+		format = &f
+	}
+	return string(b), format, r.closeSubblock()
+}
+
+// taggedWriter is the counterpart of taggedReader.
+type taggedWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *taggedWriter) uint8(v uint8) {
+	w.buf.WriteByte(v)
+}
+
+func (w *taggedWriter) uint16(v uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *taggedWriter) uint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *taggedWriter) float32(v float32) {
+	w.uint32(math.Float32bits(v))
+}
+
+func (w *taggedWriter) float64(v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	w.buf.Write(b[:])
+}
+
+func (w *taggedWriter) varuint(v uint64) {
+	for v >= 0x80 {
+		w.buf.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	w.buf.WriteByte(byte(v))
+}
+
+func (w *taggedWriter) crdtId(id CrdtId) {
+	w.uint8(id.Part1)
+	w.varuint(id.Part2)
+}
+
+func (w *taggedWriter) tag(index int, tagType byte) {
+	w.varuint(uint64(index)<<4 | uint64(tagType))
+}
+
+func (w *taggedWriter) writeId(index int, id CrdtId) {
+	w.tag(index, tagId)
+	w.crdtId(id)
+}
+
+func (w *taggedWriter) writeBool(index int, v bool) {
+	w.tag(index, tagByte1)
+	if v {
+		w.uint8(1)
+	} else {
+		w.uint8(0)
+	}
+}
+
+func (w *taggedWriter) writeByte(index int, v uint8) {
+	w.tag(index, tagByte1)
+	w.uint8(v)
+}
+
+func (w *taggedWriter) writeInt(index int, v uint32) {
+	w.tag(index, tagByte4)
+	w.uint32(v)
+}
+
+func (w *taggedWriter) writeFloat(index int, v float32) {
+	w.tag(index, tagByte4)
+	w.float32(v)
+}
+
+func (w *taggedWriter) writeDouble(index int, v float64) {
+	w.tag(index, tagByte8)
+	w.float64(v)
+}
+
+// writeSubblock writes subblock index, whose content is produced by body.
+func (w *taggedWriter) writeSubblock(index int, body func(w *taggedWriter)) {
+	sub := &taggedWriter{}
+	body(sub)
+	w.tag(index, tagLength4)
+	w.uint32(uint32(sub.buf.Len()))
+	w.buf.Write(sub.buf.Bytes())
+}
+
+func (w *taggedWriter) writeStringWithFormat(index int, s string, format *uint32) {
+	w.writeSubblock(index, func(w *taggedWriter) {
+		w.varuint(uint64(len(s)))
+		w.uint8(1)
+		w.buf.WriteString(s)
+		if format != nil {
+			w.writeInt(2, *format)
+		}
+	})
+}
+
+func (w *taggedWriter) writeString(index int, s string) {
+	w.writeStringWithFormat(index, s, nil)
+}
@@ -0,0 +1,94 @@
+# gen.py writes the golden pages v3.rm, v5.rm and v6.rm used by rm_test.go:
+#
+#     cd document/rm/testdata && python3 gen.py .
+#
+# It encodes the pages directly from the published descriptions of the formats instead of using package rm, so the
+# tests compare package rm against an independent encoder: v3 and v5 follow the .lines layout documented by
+# https://github.com/ax3l/lines-are-beautiful, v6 follows the tagged block layout of
+# https://github.com/ricklupton/rmscene (scene tree, tree node, scene item, root text and author id blocks).
+import struct, sys
+out = sys.argv[1]
+
+def header(v):
+    h = "reMarkable .lines file, version=%d" % v
+    return (h + " " * (43 - len(h))).encode()
+
+# v3 / v5: layers of lines, points are 6 float32
+def v5(version):
+    b = bytearray(header(version))
+    layers = [
+        [(15, 0, 2.0, 12.5, [(100.5, 200.25, 0.5, 1.25, 2.0, 0.75), (110.0, 210.0, 0.75, 1.5, 2.25, 0.8)]),
+         (18, 3, 1.0, 0.0, [(50, 60, 0, 0, 30, 1), (70, 60, 0, 0, 30, 1), (90, 60, 0, 0, 30, 1)])],
+        [(2, 7, 1.875, 3.0, [(300, 400, 1, 3.14, 1.5, 0.5)])],
+    ]
+    b += struct.pack('<I', len(layers))
+    for layer in layers:
+        b += struct.pack('<I', len(layer))
+        for pen, color, size, start, points in layer:
+            b += struct.pack('<IIIf', pen, color, 0, size)
+            if version == 5:
+                b += struct.pack('<f', start)
+            b += struct.pack('<I', len(points))
+            for p in points:
+                b += struct.pack('<6f', *p)
+    return bytes(b)
+
+# v6: tagged blocks
+def varuint(v):
+    r = bytearray()
+    while v >= 0x80:
+        r.append((v & 0x7f) | 0x80); v >>= 7
+    r.append(v)
+    return bytes(r)
+def tag(i, t): return varuint(i << 4 | t)
+def crdt(a, b): return bytes([a]) + varuint(b)
+def tid(i, a, b): return tag(i, 0xF) + crdt(a, b)
+def tbool(i, v): return tag(i, 1) + bytes([1 if v else 0])
+def tint(i, v): return tag(i, 4) + struct.pack('<I', v)
+def tfloat(i, v): return tag(i, 4) + struct.pack('<f', v)
+def tdouble(i, v): return tag(i, 8) + struct.pack('<d', v)
+def sub(i, body): return tag(i, 0xC) + struct.pack('<I', len(body)) + body
+def tstr(i, s): return sub(i, varuint(len(s)) + b'\x01' + s.encode())
+def lwwstr(i, ts, s): return sub(i, tid(1, *ts) + tstr(2, s))
+def lwwbool(i, ts, v): return sub(i, tid(1, *ts) + tbool(2, v))
+def lwwid(i, ts, v): return sub(i, tid(1, *ts) + tid(2, *v))
+def block(typ, minv, v, body): return struct.pack('<IBBBB', len(body), 0, minv, v, typ) + body
+
+def item(parent, iid, left, right, deleted, value=None):
+    b = tid(1, *parent) + tid(2, *iid) + tid(3, *left) + tid(4, *right) + tint(5, deleted)
+    if value is not None:
+        b += sub(6, value)
+    return b
+
+def line(pen, color, size, start, points, ts):
+    pts = b''.join(struct.pack('<ffHHBB', *p) for p in points)
+    return bytes([3]) + tint(1, pen) + tint(2, color) + tdouble(3, size) + tfloat(4, start) + sub(5, pts) + tid(6, *ts)
+
+def v6():
+    b = bytearray(header(6))
+    author = sub(0, varuint(16) + bytes(range(16)) + struct.pack('<H', 1))
+    b += block(0x09, 1, 1, varuint(1) + author)
+    b += block(0x00, 1, 1, tid(1, 1, 1) + tbool(2, True))
+    b += block(0x0A, 0, 1, tint(1, 1) + tint(2, 0) + tint(3, 5) + tint(4, 1))
+    b += block(0x0D, 0, 1, lwwid(1, (0, 0), (0, 11)) + lwwbool(2, (0, 0), True))
+    b += block(0x01, 1, 1, tid(1, 0, 11) + tid(2, 0, 0) + tbool(3, True) + sub(4, tid(1, 0, 1)))
+    chars = sub(0, tid(2, 1, 16) + tid(3, 0, 0) + tid(4, 0, 0) + tint(5, 0) + tstr(6, "Hello"))
+    styles = crdt(0, 0) + tid(1, 1, 15) + sub(2, bytes([17, 1]))
+    text = tid(1, 0, 0) + sub(2, sub(1, sub(1, varuint(1) + chars)) + sub(2, sub(1, varuint(1) + styles)))
+    text += sub(3, struct.pack('<dd', -468.0, 234.0)) + tfloat(4, 936.0)
+    b += block(0x07, 0, 1, text)
+    b += block(0x02, 1, 1, tid(1, 0, 1) + lwwstr(2, (0, 0), "") + lwwbool(3, (0, 0), True))
+    b += block(0x02, 1, 1, tid(1, 0, 11) + lwwstr(2, (0, 12), "Layer 1") + lwwbool(3, (0, 0), True))
+    b += block(0x04, 1, 1, item((0, 1), (0, 13), (0, 0), (0, 0), 0, bytes([2]) + tid(2, 0, 11)))
+    b += block(0x05, 1, 2, item((0, 11), (1, 20), (0, 0), (0, 0), 0,
+        line(15, 0, 2.0, 0.0, [(100.5, 200.25, 4, 8, 64, 128), (110.0, 210.0, 6, 9, 70, 200)], (1, 21))))
+    b += block(0x05, 1, 2, item((0, 11), (1, 22), (1, 20), (0, 0), 1))
+    b += block(0x05, 1, 2, item((0, 11), (1, 23), (1, 22), (0, 0), 0,
+        line(18, 3, 1.0, 0.0, [(50, 60, 0, 120, 0, 255), (90, 60, 0, 120, 0, 255)], (1, 24))))
+    glyph = bytes([1]) + tint(2, 0) + tint(3, 5) + tint(4, 3) + sub(5, tstr(1, "Hello"))
+    b += block(0x03, 1, 1, item((0, 11), (1, 25), (1, 23), (0, 0), 0, glyph))
+    return bytes(b)
+
+open(out + "/v3.rm", "wb").write(v5(3))
+open(out + "/v5.rm", "wb").write(v5(5))
+open(out + "/v6.rm", "wb").write(v6())
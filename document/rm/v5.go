@@ -0,0 +1,109 @@
+package rm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Versions 3 and 5 store layers of lines directly, version 5 adds StartingLength to every line.
+
+func decodeV5(data []byte, version Version) (*Page, error) {
+	r := bytes.NewReader(data)
+	page := &Page{Version: version}
+
+	var nLayers uint32
+	if err := binary.Read(r, binary.LittleEndian, &nLayers); err != nil {
+		return nil, fmt.Errorf("reading layer count: %w", err)
+	}
+	for i := uint32(0); i < nLayers; i++ {
+		layer := &Layer{Name: fmt.Sprintf("Layer %d", i+1), Visible: true}
+		var nLines uint32
+		if err := binary.Read(r, binary.LittleEndian, &nLines); err != nil {
+			return nil, fmt.Errorf("reading line count of layer %d: %w", i, err)
+		}
+		for j := uint32(0); j < nLines; j++ {
+			line, err := decodeV5Line(r, version)
+			if err != nil {
+				return nil, fmt.Errorf("reading line %d of layer %d: %w", j, i, err)
+			}
+			layer.Lines = append(layer.Lines, line)
+		}
+		page.Layers = append(page.Layers, layer)
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes", r.Len())
+	}
+	return page, nil
+}
+
+// v5LineHeader is the fixed-size part of a line, StartingLength is only present in version 5.
+type v5LineHeader struct {
+	Pen       uint32
+	Color     uint32
+	Padding   uint32
+	BrushSize float32
+}
+
+// v5PointSize is the size of a serialized point, which has the same layout as Point.
+const v5PointSize = 24
+
+func decodeV5Line(r *bytes.Reader, version Version) (*Line, error) {
+	hdr := v5LineHeader{}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, err
+	}
+	line := &Line{
+		Pen:            Pen(hdr.Pen),
+		Color:          Color(hdr.Color),
+		ThicknessScale: float64(hdr.BrushSize),
+		padding:        hdr.Padding,
+	}
+	if version == V5 {
+		if err := binary.Read(r, binary.LittleEndian, &line.StartingLength); err != nil {
+			return nil, err
+		}
+	}
+
+	var nPoints uint32
+	if err := binary.Read(r, binary.LittleEndian, &nPoints); err != nil {
+		return nil, err
+	}
+	// The count is read from the file, so check it before allocating the points
+	if uint64(nPoints)*v5PointSize > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	line.Points = make([]Point, nPoints)
+	// Point has the same layout as the serialized points
+	if err := binary.Read(r, binary.LittleEndian, line.Points); err != nil {
+		return nil, err
+	}
+	return line, nil
+}
+
+func encodeV5(buf *bytes.Buffer, page *Page) {
+	write := func(v interface{}) {
+		// Writes to a bytes.Buffer do not fail
+		_ = binary.Write(buf, binary.LittleEndian, v)
+	}
+
+	write(uint32(len(page.Layers)))
+	for _, layer := range page.Layers {
+		write(uint32(len(layer.Lines)))
+		for _, line := range layer.Lines {
+			write(v5LineHeader{
+				Pen:       uint32(line.Pen),
+				Color:     uint32(line.Color),
+				Padding:   line.padding,
+				BrushSize: float32(line.ThicknessScale),
+			})
+			if page.Version == V5 {
+				write(line.StartingLength)
+			}
+			write(uint32(len(line.Points)))
+			write(line.Points)
+		}
+	}
+}
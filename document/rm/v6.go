@@ -0,0 +1,1139 @@
+package rm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Block types of the v6 format.
+const (
+	blockMigrationInfo  = 0x00
+	blockSceneTree      = 0x01
+	blockTreeNode       = 0x02
+	blockSceneGlyphItem = 0x03
+	blockSceneGroupItem = 0x04
+	blockSceneLineItem  = 0x05
+	blockSceneTextItem  = 0x06
+	blockRootText       = 0x07
+	blockSceneTombstone = 0x08
+	blockAuthorIds      = 0x09
+	blockPageInfo       = 0x0A
+	blockSceneInfo      = 0x0D
+)
+
+// Item types stored in the value subblock of scene items.
+const (
+	itemTypeGroup = 0x02
+	itemTypeLine  = 0x03
+)
+
+// rootId is the id of the root node of the scene tree, the parent of all layers.
+var rootId = CrdtId{0, 1}
+
+// block is a single block of a v6 file. Blocks of unknown types, or which cannot be reproduced
+// byte for byte, are kept as raw bytes.
+type block struct {
+	unknown    uint8
+	minVersion uint8
+	version    uint8
+	typ        uint8
+	body       blockBody
+	raw        []byte
+	// extra holds trailing content after the fields we know about.
+	extra []byte
+}
+
+type blockBody interface {
+	encode(w *taggedWriter, version uint8)
+}
+
+type blockDecoder func(r *taggedReader, version uint8) (blockBody, error)
+
+var blockDecoders = map[uint8]blockDecoder{
+	blockMigrationInfo:  decodeMigrationInfo,
+	blockSceneTree:      decodeSceneTree,
+	blockTreeNode:       decodeTreeNode,
+	blockSceneGroupItem: decodeSceneItem(itemTypeGroup),
+	blockSceneLineItem:  decodeSceneItem(itemTypeLine),
+	blockRootText:       decodeRootText,
+	blockAuthorIds:      decodeAuthorIds,
+	blockPageInfo:       decodePageInfo,
+	blockSceneInfo:      decodeSceneInfo,
+}
+
+func decodeV6(data []byte) (*Page, error) {
+	blocks := make([]*block, 0)
+	for pos := 0; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, fmt.Errorf("block header at offset %d: %w", pos, io.ErrUnexpectedEOF)
+		}
+		l := int(binary.LittleEndian.Uint32(data[pos:]))
+		b := &block{
+			unknown:    data[pos+4],
+			minVersion: data[pos+5],
+			version:    data[pos+6],
+			typ:        data[pos+7],
+		}
+		pos += 8
+		if pos+l > len(data) {
+			return nil, fmt.Errorf("block of type %#x at offset %d: %w", b.typ, pos, io.ErrUnexpectedEOF)
+		}
+		content := data[pos : pos+l]
+		pos += l
+
+		b.decodeContent(content)
+		blocks = append(blocks, b)
+	}
+
+	page := &Page{Version: V6, blocks: blocks}
+	page.buildScene()
+	return page, nil
+}
+
+// decodeContent decodes the content of b if its type is known, falling back to keeping it raw.
+func (b *block) decodeContent(content []byte) {
+	b.raw = content
+	dec, ok := blockDecoders[b.typ]
+	if !ok {
+		return
+	}
+	r := newTaggedReader(content)
+	body, err := dec(r, b.version)
+	if err != nil {
+		return
+	}
+	extra := content[r.pos:]
+
+	// Only keep the decoded form if it reproduces the input, so round trips are lossless
+	w := &taggedWriter{}
+	body.encode(w, b.version)
+	w.buf.Write(extra)
+	if !bytes.Equal(w.buf.Bytes(), content) {
+		return
+	}
+
+	b.body = body
+	b.extra = extra
+	b.raw = nil
+}
+
+func (b *block) content() []byte {
+	if b.body == nil {
+		return b.raw
+	}
+	w := &taggedWriter{}
+	b.body.encode(w, b.version)
+	w.buf.Write(b.extra)
+	return w.buf.Bytes()
+}
+
+func encodeV6(buf *bytes.Buffer, page *Page) error {
+	blocks := page.blocks
+	if blocks == nil {
+		blocks = synthesizeBlocks(page)
+	} else {
+		if err := page.syncBlocks(); err != nil {
+			return err
+		}
+		blocks = page.blocks
+	}
+
+	for _, b := range blocks {
+		content := b.content()
+		var hdr [8]byte
+		binary.LittleEndian.PutUint32(hdr[:], uint32(len(content)))
+		hdr[4] = b.unknown
+		hdr[5] = b.minVersion
+		hdr[6] = b.version
+		hdr[7] = b.typ
+		buf.Write(hdr[:])
+		buf.Write(content)
+	}
+	return nil
+}
+
+// buildScene reconstructs the layers and text of the page from its blocks.
+func (p *Page) buildScene() {
+	layers := make(map[CrdtId]*Layer)
+	order := make([]CrdtId, 0)
+
+	addLayer := func(id CrdtId) *Layer {
+		layer, ok := layers[id]
+		if !ok {
+			layer = &Layer{Visible: true}
+			layers[id] = layer
+		}
+		return layer
+	}
+
+	for _, b := range p.blocks {
+		switch body := b.body.(type) {
+		case *treeNode:
+			if body.nodeId == rootId {
+				continue
+			}
+			layer := addLayer(body.nodeId)
+			layer.Name = body.label.value
+			layer.Visible = body.visible.value
+		case *sceneItem:
+			if body.group != nil && body.parentId == rootId {
+				order = append(order, *body.group)
+			}
+		case *rootText:
+			p.Text = body.text
+		}
+	}
+
+	layerOf := topLevelGroups(p.blocks)
+	seen := make(map[CrdtId]bool)
+	for _, id := range order {
+		seen[id] = true
+	}
+	for _, b := range p.blocks {
+		body, ok := b.body.(*sceneItem)
+		if !ok || body.line == nil {
+			continue
+		}
+		id := layerOf(body.parentId)
+		layer := addLayer(id)
+		layer.Lines = append(layer.Lines, body.line.line)
+		if !seen[id] {
+			seen[id] = true
+			order = append(order, id)
+		}
+	}
+
+	p.Layers = make([]*Layer, 0, len(order))
+	p.layerIds = make(map[*Layer]CrdtId, len(order))
+	for _, id := range order {
+		layer := addLayer(id)
+		p.Layers = append(p.Layers, layer)
+		p.layerIds[layer] = id
+	}
+}
+
+// topLevelGroups returns a function mapping a group to the top-level group (the layer) containing it, since lines in
+// nested groups belong to the layer containing them.
+func topLevelGroups(blocks []*block) func(CrdtId) CrdtId {
+	parentOf := make(map[CrdtId]CrdtId)
+	for _, b := range blocks {
+		if body, ok := b.body.(*sceneItem); ok && body.group != nil {
+			parentOf[*body.group] = body.parentId
+		}
+	}
+	return func(id CrdtId) CrdtId {
+		for i := 0; i < len(parentOf); i++ {
+			parent, ok := parentOf[id]
+			if !ok || parent == rootId {
+				break
+			}
+			id = parent
+		}
+		return id
+	}
+}
+
+// synthesizeBlocks creates a block stream for a page which was not decoded from a v6 file.
+func synthesizeBlocks(page *Page) []*block {
+	var counter uint64
+	nextId := func() CrdtId {
+		counter++
+		return CrdtId{1, counter}
+	}
+	zero := CrdtId{}
+
+	blocks := []*block{
+		{minVersion: 1, version: 1, typ: blockMigrationInfo, body: &migrationInfo{id: nextId(), isDevice: true}},
+		{minVersion: 0, version: 1, typ: blockPageInfo, body: &pageInfo{loads: 1}},
+	}
+
+	layerIds := make([]CrdtId, len(page.Layers))
+	for i := range page.Layers {
+		layerIds[i] = nextId()
+		blocks = append(blocks, &block{minVersion: 1, version: 1, typ: blockSceneTree, body: &sceneTree{
+			treeId: layerIds[i], isUpdate: true, parentId: rootId,
+		}})
+	}
+	if page.Text != nil {
+		blocks = append(blocks, &block{minVersion: 0, version: 1, typ: blockRootText, body: &rootText{text: page.Text}})
+	}
+	blocks = append(blocks, &block{minVersion: 1, version: 1, typ: blockTreeNode, body: &treeNode{
+		nodeId: rootId, label: lwwString{}, visible: lwwBool{value: true},
+	}})
+	for i, layer := range page.Layers {
+		blocks = append(blocks, &block{minVersion: 1, version: 1, typ: blockTreeNode, body: &treeNode{
+			nodeId:  layerIds[i],
+			label:   lwwString{timestamp: nextId(), value: layer.Name},
+			visible: lwwBool{timestamp: nextId(), value: layer.Visible},
+		}})
+	}
+	left := zero
+	for i := range page.Layers {
+		itemId := nextId()
+		group := layerIds[i]
+		blocks = append(blocks, &block{minVersion: 1, version: 1, typ: blockSceneGroupItem, body: &sceneItem{
+			itemType: itemTypeGroup, parentId: rootId, itemId: itemId, leftId: left, group: &group,
+		}})
+		left = itemId
+	}
+	// Version 1 line blocks store points as floats, so lines of upgraded pages are not quantized
+	for i, layer := range page.Layers {
+		left = zero
+		for _, line := range layer.Lines {
+			itemId := nextId()
+			blocks = append(blocks, &block{minVersion: 1, version: 1, typ: blockSceneLineItem, body: &sceneItem{
+				itemType: itemTypeLine, parentId: layerIds[i], itemId: itemId, leftId: left,
+				line: &lineItem{line: line, timestamp: nextId()},
+			}})
+			left = itemId
+		}
+	}
+
+	return blocks
+}
+
+type lwwString struct {
+	timestamp CrdtId
+	value     string
+}
+
+type lwwBool struct {
+	timestamp CrdtId
+	value     bool
+}
+
+type lwwId struct {
+	timestamp CrdtId
+	value     CrdtId
+}
+
+type lwwByte struct {
+	timestamp CrdtId
+	value     uint8
+}
+
+type lwwFloat struct {
+	timestamp CrdtId
+	value     float32
+}
+
+func readLwwString(r *taggedReader, index int) (v lwwString, err error) {
+	if _, err = r.openSubblock(index); err != nil {
+		return
+	}
+	if v.timestamp, err = r.readId(1); err != nil {
+		return
+	}
+	if v.value, err = r.readString(2); err != nil {
+		return
+	}
+	err = r.closeSubblock()
+	return
+}
+
+func readLwwBool(r *taggedReader, index int) (v lwwBool, err error) {
+	if _, err = r.openSubblock(index); err != nil {
+		return
+	}
+	if v.timestamp, err = r.readId(1); err != nil {
+		return
+	}
+	if v.value, err = r.readBool(2); err != nil {
+		return
+	}
+	err = r.closeSubblock()
+	return
+}
+
+func readLwwId(r *taggedReader, index int) (v lwwId, err error) {
+	if _, err = r.openSubblock(index); err != nil {
+		return
+	}
+	if v.timestamp, err = r.readId(1); err != nil {
+		return
+	}
+	if v.value, err = r.readId(2); err != nil {
+		return
+	}
+	err = r.closeSubblock()
+	return
+}
+
+func readLwwByte(r *taggedReader, index int) (v lwwByte, err error) {
+	if _, err = r.openSubblock(index); err != nil {
+		return
+	}
+	if v.timestamp, err = r.readId(1); err != nil {
+		return
+	}
+	if v.value, err = r.readByte(2); err != nil {
+		return
+	}
+	err = r.closeSubblock()
+	return
+}
+
+func readLwwFloat(r *taggedReader, index int) (v lwwFloat, err error) {
+	if _, err = r.openSubblock(index); err != nil {
+		return
+	}
+	if v.timestamp, err = r.readId(1); err != nil {
+		return
+	}
+	if v.value, err = r.readFloat(2); err != nil {
+		return
+	}
+	err = r.closeSubblock()
+	return
+}
+
+func (v lwwString) write(w *taggedWriter, index int) {
+	w.writeSubblock(index, func(w *taggedWriter) {
+		w.writeId(1, v.timestamp)
+		w.writeString(2, v.value)
+	})
+}
+
+func (v lwwBool) write(w *taggedWriter, index int) {
+	w.writeSubblock(index, func(w *taggedWriter) {
+		w.writeId(1, v.timestamp)
+		w.writeBool(2, v.value)
+	})
+}
+
+func (v lwwId) write(w *taggedWriter, index int) {
+	w.writeSubblock(index, func(w *taggedWriter) {
+		w.writeId(1, v.timestamp)
+		w.writeId(2, v.value)
+	})
+}
+
+func (v lwwByte) write(w *taggedWriter, index int) {
+	w.writeSubblock(index, func(w *taggedWriter) {
+		w.writeId(1, v.timestamp)
+		w.writeByte(2, v.value)
+	})
+}
+
+func (v lwwFloat) write(w *taggedWriter, index int) {
+	w.writeSubblock(index, func(w *taggedWriter) {
+		w.writeId(1, v.timestamp)
+		w.writeFloat(2, v.value)
+	})
+}
+
+type migrationInfo struct {
+	id       CrdtId
+	isDevice bool
+	unknown  *bool
+}
+
+func decodeMigrationInfo(r *taggedReader, _ uint8) (blockBody, error) {
+	var err error
+	b := &migrationInfo{}
+	if b.id, err = r.readId(1); err != nil {
+		return nil, err
+	}
+	if b.isDevice, err = r.readBool(2); err != nil {
+		return nil, err
+	}
+	if r.peekTag(3, tagByte1) {
+		v, err := r.readBool(3)
+		if err != nil {
+			return nil, err
+		}
+		b.unknown = &v
+	}
+	return b, nil
+}
+
+func (b *migrationInfo) encode(w *taggedWriter, _ uint8) {
+	w.writeId(1, b.id)
+	w.writeBool(2, b.isDevice)
+	if b.unknown != nil {
+		w.writeBool(3, *b.unknown)
+	}
+}
+
+type sceneTree struct {
+	treeId   CrdtId
+	nodeId   CrdtId
+	isUpdate bool
+	parentId CrdtId
+}
+
+func decodeSceneTree(r *taggedReader, _ uint8) (blockBody, error) {
+	var err error
+	b := &sceneTree{}
+	if b.treeId, err = r.readId(1); err != nil {
+		return nil, err
+	}
+	if b.nodeId, err = r.readId(2); err != nil {
+		return nil, err
+	}
+	if b.isUpdate, err = r.readBool(3); err != nil {
+		return nil, err
+	}
+	if _, err = r.openSubblock(4); err != nil {
+		return nil, err
+	}
+	if b.parentId, err = r.readId(1); err != nil {
+		return nil, err
+	}
+	return b, r.closeSubblock()
+}
+
+func (b *sceneTree) encode(w *taggedWriter, _ uint8) {
+	w.writeId(1, b.treeId)
+	w.writeId(2, b.nodeId)
+	w.writeBool(3, b.isUpdate)
+	w.writeSubblock(4, func(w *taggedWriter) {
+		w.writeId(1, b.parentId)
+	})
+}
+
+type treeNode struct {
+	nodeId  CrdtId
+	label   lwwString
+	visible lwwBool
+	anchor  *treeNodeAnchor
+}
+
+// treeNodeAnchor attaches a group to a position in the typed text.
+type treeNodeAnchor struct {
+	id        lwwId
+	typ       lwwByte
+	threshold lwwFloat
+	originX   lwwFloat
+}
+
+func decodeTreeNode(r *taggedReader, _ uint8) (blockBody, error) {
+	var err error
+	b := &treeNode{}
+	if b.nodeId, err = r.readId(1); err != nil {
+		return nil, err
+	}
+	if b.label, err = readLwwString(r, 2); err != nil {
+		return nil, err
+	}
+	if b.visible, err = readLwwBool(r, 3); err != nil {
+		return nil, err
+	}
+	if r.peekTag(7, tagLength4) {
+		a := &treeNodeAnchor{}
+		if a.id, err = readLwwId(r, 7); err != nil {
+			return nil, err
+		}
+		if a.typ, err = readLwwByte(r, 8); err != nil {
+			return nil, err
+		}
+		if a.threshold, err = readLwwFloat(r, 9); err != nil {
+			return nil, err
+		}
+		if a.originX, err = readLwwFloat(r, 10); err != nil {
+			return nil, err
+		}
+		b.anchor = a
+	}
+	return b, nil
+}
+
+func (b *treeNode) encode(w *taggedWriter, _ uint8) {
+	w.writeId(1, b.nodeId)
+	b.label.write(w, 2)
+	b.visible.write(w, 3)
+	if b.anchor != nil {
+		b.anchor.id.write(w, 7)
+		b.anchor.typ.write(w, 8)
+		b.anchor.threshold.write(w, 9)
+		b.anchor.originX.write(w, 10)
+	}
+}
+
+// sceneItem is an element of the CRDT sequence of children of a group. Deleted items have no value.
+type sceneItem struct {
+	itemType      uint8
+	parentId      CrdtId
+	itemId        CrdtId
+	leftId        CrdtId
+	rightId       CrdtId
+	deletedLength uint32
+	// group is the value of group items: the node id of the child group.
+	group *CrdtId
+	// line is the value of line items.
+	line *lineItem
+}
+
+type lineItem struct {
+	line      *Line
+	timestamp CrdtId
+	moveId    *CrdtId
+}
+
+func decodeSceneItem(itemType uint8) blockDecoder {
+	return func(r *taggedReader, version uint8) (blockBody, error) {
+		var err error
+		b := &sceneItem{itemType: itemType}
+		if b.parentId, err = r.readId(1); err != nil {
+			return nil, err
+		}
+		if b.itemId, err = r.readId(2); err != nil {
+			return nil, err
+		}
+		if b.leftId, err = r.readId(3); err != nil {
+			return nil, err
+		}
+		if b.rightId, err = r.readId(4); err != nil {
+			return nil, err
+		}
+		if b.deletedLength, err = r.readInt(5); err != nil {
+			return nil, err
+		}
+		if !r.peekTag(6, tagLength4) {
+			return b, nil
+		}
+
+		if _, err = r.openSubblock(6); err != nil {
+			return nil, err
+		}
+		typ, err := r.uint8()
+		if err != nil {
+			return nil, err
+		}
+		if typ != itemType {
+			return nil, fmt.Errorf("item type %#x in block for item type %#x", typ, itemType)
+		}
+		switch itemType {
+		case itemTypeGroup:
+			id, err := r.readId(2)
+			if err != nil {
+				return nil, err
+			}
+			b.group = &id
+		case itemTypeLine:
+			if b.line, err = decodeLineItem(r, version); err != nil {
+				return nil, err
+			}
+		}
+		return b, r.closeSubblock()
+	}
+}
+
+func (b *sceneItem) encode(w *taggedWriter, version uint8) {
+	w.writeId(1, b.parentId)
+	w.writeId(2, b.itemId)
+	w.writeId(3, b.leftId)
+	w.writeId(4, b.rightId)
+	w.writeInt(5, b.deletedLength)
+	if b.group == nil && b.line == nil {
+		return
+	}
+	w.writeSubblock(6, func(w *taggedWriter) {
+		w.uint8(b.itemType)
+		if b.group != nil {
+			w.writeId(2, *b.group)
+		} else {
+			b.line.encode(w, version)
+		}
+	})
+}
+
+// pointSize returns the size of a serialized point in a line block of the given version.
+func pointSize(version uint8) int {
+	if version >= 2 {
+		return 14
+	}
+	return 24
+}
+
+func decodeLineItem(r *taggedReader, version uint8) (*lineItem, error) {
+	var err error
+	line := &Line{}
+	item := &lineItem{line: line}
+
+	pen, err := r.readInt(1)
+	if err != nil {
+		return nil, err
+	}
+	line.Pen = Pen(pen)
+	color, err := r.readInt(2)
+	if err != nil {
+		return nil, err
+	}
+	line.Color = Color(color)
+	if line.ThicknessScale, err = r.readDouble(3); err != nil {
+		return nil, err
+	}
+	if line.StartingLength, err = r.readFloat(4); err != nil {
+		return nil, err
+	}
+
+	l, err := r.openSubblock(5)
+	if err != nil {
+		return nil, err
+	}
+	size := pointSize(version)
+	if l%size != 0 {
+		return nil, fmt.Errorf("points subblock of %d bytes is not a multiple of %d", l, size)
+	}
+	line.Points = make([]Point, l/size)
+	for i := range line.Points {
+		if line.Points[i], err = decodePoint(r, version); err != nil {
+			return nil, err
+		}
+	}
+	if err = r.closeSubblock(); err != nil {
+		return nil, err
+	}
+
+	if item.timestamp, err = r.readId(6); err != nil {
+		return nil, err
+	}
+	if r.peekTag(7, tagId) {
+		id, err := r.readId(7)
+		if err != nil {
+			return nil, err
+		}
+		item.moveId = &id
+	}
+	return item, nil
+}
+
+func (item *lineItem) encode(w *taggedWriter, version uint8) {
+	line := item.line
+	w.writeInt(1, uint32(line.Pen))
+	w.writeInt(2, uint32(line.Color))
+	w.writeDouble(3, line.ThicknessScale)
+	w.writeFloat(4, line.StartingLength)
+	w.writeSubblock(5, func(w *taggedWriter) {
+		for _, p := range line.Points {
+			encodePoint(w, p, version)
+		}
+	})
+	w.writeId(6, item.timestamp)
+	if item.moveId != nil {
+		w.writeId(7, *item.moveId)
+	}
+}
+
+// directionScale converts radians into the byte-sized direction of version 2 points.
+const directionScale = 255 / (2 * math.Pi)
+
+func decodePoint(r *taggedReader, version uint8) (Point, error) {
+	var err error
+	p := Point{}
+	if p.X, err = r.float32(); err != nil {
+		return p, err
+	}
+	if p.Y, err = r.float32(); err != nil {
+		return p, err
+	}
+	if version < 2 {
+		if p.Speed, err = r.float32(); err != nil {
+			return p, err
+		}
+		if p.Direction, err = r.float32(); err != nil {
+			return p, err
+		}
+		if p.Width, err = r.float32(); err != nil {
+			return p, err
+		}
+		p.Pressure, err = r.float32()
+		return p, err
+	}
+
+	speed, err := r.uint16()
+	if err != nil {
+		return p, err
+	}
+	width, err := r.uint16()
+	if err != nil {
+		return p, err
+	}
+	direction, err := r.uint8()
+	if err != nil {
+		return p, err
+	}
+	pressure, err := r.uint8()
+	if err != nil {
+		return p, err
+	}
+	p.Speed = float32(speed) / 4
+	p.Width = float32(width) / 4
+	p.Direction = float32(float64(direction) / directionScale)
+	p.Pressure = float32(pressure) / 255
+	return p, nil
+}
+
+func encodePoint(w *taggedWriter, p Point, version uint8) {
+	w.float32(p.X)
+	w.float32(p.Y)
+	if version < 2 {
+		w.float32(p.Speed)
+		w.float32(p.Direction)
+		w.float32(p.Width)
+		w.float32(p.Pressure)
+		return
+	}
+	w.uint16(clampUint16(float64(p.Speed) * 4))
+	w.uint16(clampUint16(float64(p.Width) * 4))
+	w.uint8(clampUint8(float64(p.Direction) * directionScale))
+	w.uint8(clampUint8(float64(p.Pressure) * 255))
+}
+
+func clampUint16(v float64) uint16 {
+	return uint16(math.Max(0, math.Min(math.Round(v), math.MaxUint16)))
+}
+
+func clampUint8(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(math.Round(v), math.MaxUint8)))
+}
+
+type rootText struct {
+	blockId CrdtId
+	text    *Text
+}
+
+// textStyleMarker precedes the paragraph style code of a text style.
+const textStyleMarker = 17
+
+func decodeRootText(r *taggedReader, _ uint8) (blockBody, error) {
+	var err error
+	b := &rootText{text: &Text{}}
+	text := b.text
+	if b.blockId, err = r.readId(1); err != nil {
+		return nil, err
+	}
+
+	if _, err = r.openSubblock(2); err != nil {
+		return nil, err
+	}
+	// Characters
+	if _, err = r.openSubblock(1); err != nil {
+		return nil, err
+	}
+	if _, err = r.openSubblock(1); err != nil {
+		return nil, err
+	}
+	n, err := r.varuint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		item, err := decodeTextItem(r)
+		if err != nil {
+			return nil, err
+		}
+		text.Items = append(text.Items, item)
+	}
+	if err = r.closeSubblock(); err != nil {
+		return nil, err
+	}
+	if err = r.closeSubblock(); err != nil {
+		return nil, err
+	}
+	// Formatting
+	if _, err = r.openSubblock(2); err != nil {
+		return nil, err
+	}
+	if _, err = r.openSubblock(1); err != nil {
+		return nil, err
+	}
+	if n, err = r.varuint(); err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		style, err := decodeTextStyle(r)
+		if err != nil {
+			return nil, err
+		}
+		text.Styles = append(text.Styles, style)
+	}
+	if err = r.closeSubblock(); err != nil {
+		return nil, err
+	}
+	if err = r.closeSubblock(); err != nil {
+		return nil, err
+	}
+	if err = r.closeSubblock(); err != nil {
+		return nil, err
+	}
+
+	if _, err = r.openSubblock(3); err != nil {
+		return nil, err
+	}
+	if text.PosX, err = r.float64(); err != nil {
+		return nil, err
+	}
+	if text.PosY, err = r.float64(); err != nil {
+		return nil, err
+	}
+	if err = r.closeSubblock(); err != nil {
+		return nil, err
+	}
+	if text.Width, err = r.readFloat(4); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func decodeTextItem(r *taggedReader) (TextItem, error) {
+	var err error
+	item := TextItem{}
+	if _, err = r.openSubblock(0); err != nil {
+		return item, err
+	}
+	if item.ItemId, err = r.readId(2); err != nil {
+		return item, err
+	}
+	if item.LeftId, err = r.readId(3); err != nil {
+		return item, err
+	}
+	if item.RightId, err = r.readId(4); err != nil {
+		return item, err
+	}
+	if item.DeletedLength, err = r.readInt(5); err != nil {
+		return item, err
+	}
+	if r.remaining() > 0 {
+		item.hasValue = true
+		if item.Value, item.Format, err = r.readStringWithFormat(6); err != nil {
+			return item, err
+		}
+	}
+	return item, r.closeSubblock()
+}
+
+func decodeTextStyle(r *taggedReader) (TextStyle, error) {
+	var err error
+	style := TextStyle{}
+	if style.CharId, err = r.crdtId(); err != nil {
+		return style, err
+	}
+	if style.Timestamp, err = r.readId(1); err != nil {
+		return style, err
+	}
+	if _, err = r.openSubblock(2); err != nil {
+		return style, err
+	}
+	marker, err := r.uint8()
+	if err != nil {
+		return style, err
+	}
+	if marker != textStyleMarker {
+		return style, fmt.Errorf("unexpected text style marker %d", marker)
+	}
+	code, err := r.uint8()
+	if err != nil {
+		return style, err
+	}
+	style.Style = ParagraphStyle(code)
+	return style, r.closeSubblock()
+}
+
+func (b *rootText) encode(w *taggedWriter, _ uint8) {
+	text := b.text
+	w.writeId(1, b.blockId)
+	w.writeSubblock(2, func(w *taggedWriter) {
+		w.writeSubblock(1, func(w *taggedWriter) {
+			w.writeSubblock(1, func(w *taggedWriter) {
+				w.varuint(uint64(len(text.Items)))
+				for _, item := range text.Items {
+					encodeTextItem(w, item)
+				}
+			})
+		})
+		w.writeSubblock(2, func(w *taggedWriter) {
+			w.writeSubblock(1, func(w *taggedWriter) {
+				w.varuint(uint64(len(text.Styles)))
+				for _, style := range text.Styles {
+					w.crdtId(style.CharId)
+					w.writeId(1, style.Timestamp)
+					w.writeSubblock(2, func(w *taggedWriter) {
+						w.uint8(textStyleMarker)
+						w.uint8(uint8(style.Style))
+					})
+				}
+			})
+		})
+	})
+	w.writeSubblock(3, func(w *taggedWriter) {
+		w.float64(text.PosX)
+		w.float64(text.PosY)
+	})
+	w.writeFloat(4, text.Width)
+}
+
+func encodeTextItem(w *taggedWriter, item TextItem) {
+	w.writeSubblock(0, func(w *taggedWriter) {
+		w.writeId(2, item.ItemId)
+		w.writeId(3, item.LeftId)
+		w.writeId(4, item.RightId)
+		w.writeInt(5, item.DeletedLength)
+		if item.hasValue || item.Value != "" || item.Format != nil {
+			w.writeStringWithFormat(6, item.Value, item.Format)
+		}
+	})
+}
+
+type author struct {
+	uuid [16]byte
+	id   uint16
+}
+
+type authorIds struct {
+	authors []author
+}
+
+func decodeAuthorIds(r *taggedReader, _ uint8) (blockBody, error) {
+	b := &authorIds{}
+	n, err := r.varuint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		if _, err = r.openSubblock(0); err != nil {
+			return nil, err
+		}
+		l, err := r.varuint()
+		if err != nil {
+			return nil, err
+		}
+		if l != 16 {
+			return nil, fmt.Errorf("author uuid of length %d", l)
+		}
+		a := author{}
+		uuid, err := r.bytes(16)
+		if err != nil {
+			return nil, err
+		}
+		copy(a.uuid[:], uuid)
+		if a.id, err = r.uint16(); err != nil {
+			return nil, err
+		}
+		if err = r.closeSubblock(); err != nil {
+			return nil, err
+		}
+		b.authors = append(b.authors, a)
+	}
+	return b, nil
+}
+
+func (b *authorIds) encode(w *taggedWriter, _ uint8) {
+	w.varuint(uint64(len(b.authors)))
+	for _, a := range b.authors {
+		w.writeSubblock(0, func(w *taggedWriter) {
+			w.varuint(16)
+			w.buf.Write(a.uuid[:])
+			w.uint16(a.id)
+		})
+	}
+}
+
+type pageInfo struct {
+	loads          uint32
+	merges         uint32
+	textChars      uint32
+	textLines      uint32
+	typeFolioCount *uint32
+}
+
+func decodePageInfo(r *taggedReader, _ uint8) (blockBody, error) {
+	var err error
+	b := &pageInfo{}
+	if b.loads, err = r.readInt(1); err != nil {
+		return nil, err
+	}
+	if b.merges, err = r.readInt(2); err != nil {
+		return nil, err
+	}
+	if b.textChars, err = r.readInt(3); err != nil {
+		return nil, err
+	}
+	if b.textLines, err = r.readInt(4); err != nil {
+		return nil, err
+	}
+	if r.peekTag(5, tagByte4) {
+		v, err := r.readInt(5)
+		if err != nil {
+			return nil, err
+		}
+		b.typeFolioCount = &v
+	}
+	return b, nil
+}
+
+func (b *pageInfo) encode(w *taggedWriter, _ uint8) {
+	w.writeInt(1, b.loads)
+	w.writeInt(2, b.merges)
+	w.writeInt(3, b.textChars)
+	w.writeInt(4, b.textLines)
+	if b.typeFolioCount != nil {
+		w.writeInt(5, *b.typeFolioCount)
+	}
+}
+
+type sceneInfo struct {
+	currentLayer        lwwId
+	backgroundVisible   *lwwBool
+	rootDocumentVisible *lwwBool
+	paperSize           *[2]uint32
+}
+
+func decodeSceneInfo(r *taggedReader, _ uint8) (blockBody, error) {
+	var err error
+	b := &sceneInfo{}
+	if b.currentLayer, err = readLwwId(r, 1); err != nil {
+		return nil, err
+	}
+	if r.peekTag(2, tagLength4) {
+		v, err := readLwwBool(r, 2)
+		if err != nil {
+			return nil, err
+		}
+		b.backgroundVisible = &v
+	}
+	if r.peekTag(3, tagLength4) {
+		v, err := readLwwBool(r, 3)
+		if err != nil {
+			return nil, err
+		}
+		b.rootDocumentVisible = &v
+	}
+	if r.peekTag(5, tagLength4) {
+		if _, err = r.openSubblock(5); err != nil {
+			return nil, err
+		}
+		size := [2]uint32{}
+		if size[0], err = r.uint32(); err != nil {
+			return nil, err
+		}
+		if size[1], err = r.uint32(); err != nil {
+			return nil, err
+		}
+		if err = r.closeSubblock(); err != nil {
+			return nil, err
+		}
+		b.paperSize = &size
+	}
+	return b, nil
+}
+
+func (b *sceneInfo) encode(w *taggedWriter, _ uint8) {
+	b.currentLayer.write(w, 1)
+	if b.backgroundVisible != nil {
+		b.backgroundVisible.write(w, 2)
+	}
+	if b.rootDocumentVisible != nil {
+		b.rootDocumentVisible.write(w, 3)
+	}
+	if b.paperSize != nil {
+		w.writeSubblock(5, func(w *taggedWriter) {
+			w.uint32(b.paperSize[0])
+			w.uint32(b.paperSize[1])
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package rm
+
+import "fmt"
+
+// syncBlocks writes the layers and lines of a decoded page back to the block stream it was decoded from: added layers
+// and lines become new items of the scene tree, removed ones are deleted, and changed layer names and visibility are
+// set. Existing lines are stored in their items, so changes to their points need no syncing. Reordering existing
+// layers or lines, or removing the text of the page, returns ErrUnsupportedEdit.
+func (p *Page) syncBlocks() error {
+	ids := newIdSource(p.blocks)
+	layerOf := topLevelGroups(p.blocks)
+
+	// Where the layers and lines of the page are stored
+	pos := make(map[*block]int, len(p.blocks))
+	groupItems := make(map[CrdtId]*block)
+	nodes := make(map[CrdtId]*treeNode)
+	lineItems := make(map[*Line]*block)
+	var text *rootText
+	hasText := false
+	for i, b := range p.blocks {
+		pos[b] = i
+		switch body := b.body.(type) {
+		case *sceneItem:
+			if body.group != nil && body.parentId == rootId {
+				groupItems[*body.group] = b
+			}
+			if body.line != nil {
+				lineItems[body.line.line] = b
+			}
+		case *treeNode:
+			nodes[body.nodeId] = body
+		case *rootText:
+			text = body
+		}
+		hasText = hasText || b.typ == blockRootText
+	}
+
+	switch {
+	case text != nil && p.Text == nil:
+		return fmt.Errorf("%w: the text was removed", ErrUnsupportedEdit)
+	case text != nil:
+		text.text = p.Text
+	case p.Text != nil && hasText:
+		return fmt.Errorf("%w: the text of the page could not be decoded", ErrUnsupportedEdit)
+	case p.Text != nil:
+		p.blocks = append(p.blocks, &block{minVersion: 0, version: 1, typ: blockRootText, body: &rootText{text: p.Text}})
+	}
+
+	// Find the layers and lines which are still on the page. A line is kept if it is still in the layer it was decoded
+	// from, otherwise it is deleted there and added as a new line.
+	if p.layerIds == nil {
+		p.layerIds = make(map[*Layer]CrdtId)
+	}
+	keptLayers := make(map[CrdtId]bool)
+	keptLines := make(map[*Line]bool)
+	existing := make(map[*Layer][]bool)
+	lastLayer := -1
+	for _, layer := range p.Layers {
+		existing[layer] = make([]bool, len(layer.Lines))
+		id, ok := p.layerIds[layer]
+		if !ok {
+			continue
+		}
+		keptLayers[id] = true
+		if b, ok := groupItems[id]; ok {
+			if pos[b] < lastLayer {
+				return fmt.Errorf("%w: layer %q was moved", ErrUnsupportedEdit, layer.Name)
+			}
+			lastLayer = pos[b]
+		}
+
+		lastLine := -1
+		for j, line := range layer.Lines {
+			b, ok := lineItems[line]
+			if !ok || keptLines[line] || layerOf(b.body.(*sceneItem).parentId) != id {
+				continue
+			}
+			if pos[b] < lastLine {
+				return fmt.Errorf("%w: line %d of layer %q was moved", ErrUnsupportedEdit, j+1, layer.Name)
+			}
+			lastLine = pos[b]
+			keptLines[line] = true
+			existing[layer][j] = true
+		}
+	}
+
+	for line, b := range lineItems {
+		if !keptLines[line] {
+			deleteItem(b.body.(*sceneItem))
+		}
+	}
+	for id, b := range groupItems {
+		if !keptLayers[id] {
+			deleteItem(b.body.(*sceneItem))
+		}
+	}
+
+	for _, layer := range p.Layers {
+		id, ok := p.layerIds[layer]
+		if !ok {
+			continue
+		}
+		node, ok := nodes[id]
+		if !ok {
+			if layer.Name != "" || !layer.Visible {
+				p.blocks = append(p.blocks, &block{minVersion: 1, version: 1, typ: blockTreeNode, body: &treeNode{
+					nodeId:  id,
+					label:   lwwString{timestamp: ids.next(), value: layer.Name},
+					visible: lwwBool{timestamp: ids.next(), value: layer.Visible},
+				}})
+			}
+			continue
+		}
+		if node.label.value != layer.Name {
+			node.label = lwwString{timestamp: ids.next(), value: layer.Name}
+		}
+		if node.visible.value != layer.Visible {
+			node.visible = lwwBool{timestamp: ids.next(), value: layer.Visible}
+		}
+	}
+
+	// Add the new layers and lines next to their neighbours, so they keep their position in the page
+	var prevGroup *block
+	for i, layer := range p.Layers {
+		id, ok := p.layerIds[layer]
+		if !ok {
+			id = ids.next()
+			p.layerIds[layer] = id
+			var nextGroup *block
+			for _, next := range p.Layers[i+1:] {
+				if b, ok := groupItems[p.layerIds[next]]; ok && keptLayers[p.layerIds[next]] {
+					nextGroup = b
+					break
+				}
+			}
+			group := &block{minVersion: 1, version: 1, typ: blockSceneGroupItem, body: &sceneItem{
+				itemType: itemTypeGroup, parentId: rootId, itemId: ids.next(),
+				leftId: itemIdOf(prevGroup), rightId: itemIdOf(nextGroup), group: &id,
+			}}
+			p.insertBlocks(prevGroup, nextGroup,
+				&block{minVersion: 1, version: 1, typ: blockSceneTree, body: &sceneTree{
+					treeId: id, isUpdate: true, parentId: rootId,
+				}},
+				&block{minVersion: 1, version: 1, typ: blockTreeNode, body: &treeNode{
+					nodeId:  id,
+					label:   lwwString{timestamp: ids.next(), value: layer.Name},
+					visible: lwwBool{timestamp: ids.next(), value: layer.Visible},
+				}},
+				group,
+			)
+			groupItems[id] = group
+			keptLayers[id] = true
+		}
+		if b, ok := groupItems[id]; ok {
+			prevGroup = b
+		}
+
+		// nextLine[j] is the item of the first existing line after line j
+		nextLine := make([]*block, len(layer.Lines))
+		var next *block
+		for j := len(layer.Lines) - 1; j >= 0; j-- {
+			nextLine[j] = next
+			if existing[layer][j] {
+				next = lineItems[layer.Lines[j]]
+			}
+		}
+		var prevLine *block
+		for j, line := range layer.Lines {
+			if existing[layer][j] {
+				prevLine = lineItems[line]
+				continue
+			}
+			// Version 1 line blocks store points as floats, so added lines are not quantized
+			b := &block{minVersion: 1, version: 1, typ: blockSceneLineItem, body: &sceneItem{
+				itemType: itemTypeLine, parentId: id, itemId: ids.next(),
+				leftId: itemIdOf(prevLine), rightId: itemIdOf(nextLine[j]),
+				line: &lineItem{line: line, timestamp: ids.next()},
+			}}
+			p.insertBlocks(prevLine, nextLine[j], b)
+			prevLine = b
+		}
+	}
+	return nil
+}
+
+// insertBlocks inserts bs after the block after if it is not nil, else before the block before if it is not nil, and
+// else at the end of the block stream.
+func (p *Page) insertBlocks(after, before *block, bs ...*block) {
+	i := len(p.blocks)
+	for j, b := range p.blocks {
+		if after != nil && b == after {
+			i = j + 1
+			break
+		}
+		if after == nil && before != nil && b == before {
+			i = j
+			break
+		}
+	}
+	blocks := make([]*block, 0, len(p.blocks)+len(bs))
+	blocks = append(blocks, p.blocks[:i]...)
+	blocks = append(blocks, bs...)
+	p.blocks = append(blocks, p.blocks[i:]...)
+}
+
+// deleteItem turns item into a deleted item, which has no value.
+func deleteItem(item *sceneItem) {
+	item.group = nil
+	item.line = nil
+	item.deletedLength = 1
+}
+
+// itemIdOf returns the item id of the scene item b, the zero id if b is nil.
+func itemIdOf(b *block) CrdtId {
+	if b == nil {
+		return CrdtId{}
+	}
+	return b.body.(*sceneItem).itemId
+}
+
+// idSource hands out the ids of items added to a block stream, which must not be used by any existing item.
+type idSource struct {
+	last uint64
+}
+
+func newIdSource(blocks []*block) *idSource {
+	s := &idSource{}
+	for _, b := range blocks {
+		for _, id := range b.ids() {
+			if id.Part2 > s.last {
+				s.last = id.Part2
+			}
+		}
+	}
+	return s
+}
+
+func (s *idSource) next() CrdtId {
+	s.last++
+	return CrdtId{1, s.last}
+}
+
+// ids returns the ids used by b. Of blocks kept raw, only the ids of scene items are known.
+func (b *block) ids() []CrdtId {
+	switch body := b.body.(type) {
+	case *migrationInfo:
+		return []CrdtId{body.id}
+	case *sceneTree:
+		return []CrdtId{body.treeId, body.nodeId, body.parentId}
+	case *treeNode:
+		ids := []CrdtId{body.nodeId, body.label.timestamp, body.visible.timestamp}
+		if a := body.anchor; a != nil {
+			ids = append(ids, a.id.timestamp, a.id.value, a.typ.timestamp, a.threshold.timestamp, a.originX.timestamp)
+		}
+		return ids
+	case *sceneItem:
+		ids := []CrdtId{body.parentId, body.itemId, body.leftId, body.rightId}
+		if body.group != nil {
+			ids = append(ids, *body.group)
+		}
+		if body.line != nil {
+			ids = append(ids, body.line.timestamp)
+			if body.line.moveId != nil {
+				ids = append(ids, *body.line.moveId)
+			}
+		}
+		return ids
+	case *rootText:
+		ids := []CrdtId{body.blockId}
+		for _, item := range body.text.Items {
+			ids = append(ids, item.ItemId, item.LeftId, item.RightId)
+		}
+		for _, style := range body.text.Styles {
+			ids = append(ids, style.CharId, style.Timestamp)
+		}
+		return ids
+	case *sceneInfo:
+		ids := []CrdtId{body.currentLayer.timestamp, body.currentLayer.value}
+		if body.backgroundVisible != nil {
+			ids = append(ids, body.backgroundVisible.timestamp)
+		}
+		if body.rootDocumentVisible != nil {
+			ids = append(ids, body.rootDocumentVisible.timestamp)
+		}
+		return ids
+	}
+
+	if b.body != nil || b.typ < blockSceneGlyphItem || b.typ > blockSceneTextItem {
+		return nil
+	}
+	// All scene items start with their parent, item, left and right id
+	ids := make([]CrdtId, 0, 4)
+	r := newTaggedReader(b.raw)
+	for index := 1; index <= 4; index++ {
+		id, err := r.readId(index)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,245 @@
+package document
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"sort"
+)
+
+// Format is the layout of a document .zip.
+type Format int
+
+const (
+	// FormatZip is the layout of the reMarkable cloud as used by rmapi: the pages are listed in the content, their
+	// templates in the .pagedata, and the PDF has exactly one page per page.
+	FormatZip Format = iota
+	// FormatRmdoc is the layout of .rmdoc exports of the desktop app and the USB web interface: the pages, their
+	// templates and the PDF page they show are listed in the cPages of the content, and there is a .metadata file.
+	FormatRmdoc
+)
+
+// CString is a string value of the cPages, with the timestamp of its last change.
+type CString struct {
+	Timestamp string `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+// CInt is an integer value of the cPages, with the timestamp of its last change.
+type CInt struct {
+	Timestamp string `json:"timestamp"`
+	Value     int    `json:"value"`
+}
+
+// CPage is a page listed in the cPages.
+type CPage struct {
+	Id string `json:"id"`
+	// Idx orders the pages, they are sorted by its value.
+	Idx      CString  `json:"idx"`
+	Template *CString `json:"template,omitempty"`
+	// Redir is the index of the page of the PDF the page shows, it is missing for inserted pages.
+	Redir   *CInt `json:"redir,omitempty"`
+	Deleted *CInt `json:"deleted,omitempty"`
+}
+
+// CPages is the page list of the content of .rmdoc documents.
+type CPages struct {
+	Pages []CPage `json:"pages"`
+	// Original is the page count of the PDF.
+	Original   *CInt           `json:"original,omitempty"`
+	LastOpened json.RawMessage `json:"lastOpened,omitempty"`
+	Uuids      json.RawMessage `json:"uuids,omitempty"`
+}
+
+// LivePages returns the pages which are not deleted, in order.
+func (c *CPages) LivePages() []CPage {
+	pages := make([]CPage, 0, len(c.Pages))
+	for _, p := range c.Pages {
+		if p.Deleted == nil || p.Deleted.Value == 0 {
+			pages = append(pages, p)
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].Idx.Value < pages[j].Idx.Value
+	})
+	return pages
+}
+
+// Templates returns the template of every live page, "Blank" if it has none.
+func (c *CPages) Templates() []string {
+	pages := c.LivePages()
+	templates := make([]string, len(pages))
+	for i, p := range pages {
+		templates[i] = "Blank"
+		if p.Template != nil && p.Template.Value != "" {
+			templates[i] = p.Template.Value
+		}
+	}
+	return templates
+}
+
+// UseCPages sets the pages and page count of the content to the live pages of its cPages, if it has any.
+func (c *Content) UseCPages() {
+	if c.CPages == nil {
+		return
+	}
+	pages := c.CPages.LivePages()
+	c.Pages = make([]string, len(pages))
+	for i, p := range pages {
+		c.Pages[i] = p.Id
+	}
+	c.PageCount = len(pages)
+}
+
+// DetectFormat returns the Format of the document .zip r.
+func DetectFormat(r *zip.Reader) Format {
+	uuid, ok := ZipUuid(r)
+	if !ok {
+		return FormatZip
+	}
+	for _, f := range r.File {
+		if f.Name != uuid+".content" {
+			continue
+		}
+		content := struct {
+			CPages *json.RawMessage `json:"cPages"`
+		}{}
+		if readZipJson(f, &content) == nil && content.CPages != nil {
+			return FormatRmdoc
+		}
+	}
+	return FormatZip
+}
+
+// fromCPages converts a document read from an .rmdoc to the page model of FormatZip: the pages and pagedata are taken
+// from the cPages, and the PDF is rearranged so that it has exactly one page per page, with blank pages for inserted ones.
+func (pdfDoc *PdfDocument) fromCPages(env *Env) error {
+	pdfDoc.Format = FormatRmdoc
+	pages := pdfDoc.Content.CPages.LivePages()
+	pdfDoc.Pagedata = pdfDoc.Content.CPages.Templates()
+	pdfDoc.Content.UseCPages()
+
+	// Deleted pages may keep their page files
+	live := make(map[string]bool)
+	for _, p := range pages {
+		live[p.Id] = true
+	}
+	for pf := range pdfDoc.PageFiles {
+		if pf.Key.IsUuid() && !live[pf.Key.Uuid] {
+			delete(pdfDoc.PageFiles, pf)
+		}
+	}
+
+	if pdfDoc.Pdf == nil {
+		return nil
+	}
+	rearranged := env.NewBlob()
+	err := RearrangePdf(pdfDoc.Pdf.Reader(), rearranged, func(pageCount int) ([]PdfPage, error) {
+		res := make([]PdfPage, len(pages))
+		last := 1
+		for i, p := range pages {
+			if p.Redir != nil && p.Redir.Value >= 0 && p.Redir.Value < pageCount {
+				last = p.Redir.Value + 1
+				res[i] = PdfPage{SourcePage: last}
+			} else {
+				// Inserted pages take the size of the previous page
+				res[i] = PdfPage{SourcePage: last, Blank: true}
+			}
+		}
+		return res, nil
+	})
+	if err != nil {
+		rearranged.Close()
+		return err
+	}
+	pdfDoc.Pdf.Close()
+	pdfDoc.Pdf = rearranged
+	return nil
+}
+
+// toCPages returns the cPages of the document, with one page per PDF page.
+func (pdfDoc PdfDocument) toCPages() *CPages {
+	old := &CPages{}
+	if pdfDoc.Content.CPages != nil {
+		old = pdfDoc.Content.CPages
+	}
+	oldPages := make(map[string]CPage)
+	for _, p := range old.Pages {
+		oldPages[p.Id] = p
+	}
+
+	res := &CPages{LastOpened: old.LastOpened, Uuids: old.Uuids}
+	res.Pages = make([]CPage, len(pdfDoc.Content.Pages))
+	for i, pageUuid := range pdfDoc.Content.Pages {
+		p, ok := oldPages[pageUuid]
+		if !ok {
+			p = CPage{Id: pageUuid}
+		}
+		p.Idx = CString{Timestamp: "1:2", Value: cPageIdx(i, len(pdfDoc.Content.Pages))}
+		p.Deleted = nil
+		if i < len(pdfDoc.Pagedata) && pdfDoc.Pagedata[i] != "" {
+			p.Template = &CString{Timestamp: "1:2", Value: pdfDoc.Pagedata[i]}
+		}
+		p.Redir = nil
+		if pdfDoc.Content.FileType == "pdf" {
+			p.Redir = &CInt{Timestamp: "1:2", Value: i}
+		}
+		res.Pages[i] = p
+	}
+	if pdfDoc.Content.FileType == "pdf" {
+		res.Original = &CInt{Timestamp: "1:2", Value: len(pdfDoc.Content.Pages)}
+	}
+	return res
+}
+
+// cPageIdx returns the idx of page i of n pages, e.g. "ba", "bb", ..., which sort like the pages.
+func cPageIdx(i, n int) string {
+	width := 1
+	for m := 26; m < n; m *= 26 {
+		width++
+	}
+	digits := make([]byte, width)
+	for k := width - 1; k >= 0; k-- {
+		digits[k] = byte('a' + i%26)
+		i /= 26
+	}
+	return "b" + string(digits)
+}
+
+// writeRmdoc writes the entries of the document to w in FormatRmdoc.
+func (pdfDoc PdfDocument) writeRmdoc(w *zip.Writer) error {
+	if pdfDoc.Content.FileType == "pdf" {
+		err := writeBlobToZip(w, pdfDoc.Uuid+".pdf", pdfDoc.Pdf)
+		if err != nil {
+			return err
+		}
+	}
+
+	content := pdfDoc.Content
+	content.CPages = pdfDoc.toCPages()
+	if content.FormatVersion < 2 {
+		content.FormatVersion = 2
+	}
+	contentData, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	err = writeToZip(w, pdfDoc.Uuid+".content", contentData)
+	if err != nil {
+		return err
+	}
+
+	if pdfDoc.Metadata != nil {
+		err = writeToZip(w, pdfDoc.Uuid+".metadata", pdfDoc.Metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	for pf, data := range pdfDoc.PageFiles {
+		err = writeToZip(w, pf.Path(pdfDoc.Uuid), data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
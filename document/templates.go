@@ -0,0 +1,157 @@
+package document
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Size of notebook pages converted to PDF pages, in points. The reMarkable's screen is 1404x1872 pixels.
+const (
+	notebookPageWidth  = 445
+	notebookPageHeight = 594
+	// notebookPixel is the size of a screen pixel in points.
+	notebookPixel = notebookPageWidth / 1404.0
+)
+
+// templatePattern is the pattern drawn by a template.
+type templatePattern int
+
+const (
+	patternBlank templatePattern = iota
+	patternLines
+	patternGrid
+	patternDots
+)
+
+// template is a notebook background, distances are in screen pixels.
+type template struct {
+	pattern templatePattern
+	spacing float64
+	// top is the distance of the first line from the top, for patternLines.
+	top float64
+	// margin is the distance of a vertical margin line from the left, 0 if there is none.
+	margin float64
+	// landscape templates are drawn for the tablet being held sideways.
+	landscape bool
+}
+
+// templates are the built-in templates of the reMarkable by the name used in the pagedata, see builtinTemplates.
+var templates = builtinTemplates()
+
+// builtinTemplates returns the built-in templates of the reMarkable. The spacings approximate those of the tablet.
+func builtinTemplates() map[string]template {
+	sizes := []struct {
+		names               []string
+		lines, grid, margin float64
+	}{
+		{[]string{"small", "S"}, 52, 34, 130},
+		{[]string{"medium", "med", "M"}, 68, 52, 130},
+		{[]string{"large", "L"}, 88, 78, 130},
+	}
+
+	res := map[string]template{"Blank": {}}
+	for _, orientation := range []struct {
+		prefix    string
+		landscape bool
+	}{{"P", false}, {"LS", true}} {
+		add := func(name string, t template) {
+			t.landscape = orientation.landscape
+			res[orientation.prefix+" "+name] = t
+		}
+		add("Blank", template{})
+		add("Checklist", template{pattern: patternLines, spacing: 68, top: 160, margin: 130})
+		for _, size := range sizes {
+			for _, name := range size.names {
+				add("Lines "+name, template{pattern: patternLines, spacing: size.lines, top: 160})
+				add("Margin "+name, template{pattern: patternLines, spacing: size.lines, top: 160, margin: size.margin})
+				add("Grid "+name, template{pattern: patternGrid, spacing: size.grid})
+				add("Grid margin "+name, template{pattern: patternGrid, spacing: size.grid, margin: size.margin})
+				add("Dots "+name, template{pattern: patternDots, spacing: size.grid})
+			}
+		}
+	}
+	return res
+}
+
+// LandscapeTemplate returns the landscape variant of the built-in portrait template name, e.g. "LS Lines small" for
+// "P Lines small", or name if there is none.
+func LandscapeTemplate(name string) string {
+	if !strings.HasPrefix(name, "P ") {
+		return name
+	}
+	landscape := "LS " + strings.TrimPrefix(name, "P ")
+	if _, ok := templates[landscape]; !ok {
+		return name
+	}
+	return landscape
+}
+
+// content returns the content stream drawing the template on a page of width x height points.
+func (t template) content(width, height float64) string {
+	sb := strings.Builder{}
+	if t.landscape {
+		// Draw on a sideways page, rotated by 90 degrees
+		fmt.Fprintf(&sb, "0 1 -1 0 %s 0 cm\n", pdfNumber(width))
+		width, height = height, width
+	}
+
+	spacing := t.spacing * notebookPixel
+	line := func(x1, y1, x2, y2 float64) {
+		fmt.Fprintf(&sb, "%s %s m %s %s l\n", pdfNumber(x1), pdfNumber(y1), pdfNumber(x2), pdfNumber(y2))
+	}
+	switch t.pattern {
+	case patternLines:
+		sb.WriteString("0.6 G 0.5 w\n")
+		for y := height - t.top*notebookPixel; y > 0; y -= spacing {
+			line(0, y, width, y)
+		}
+		sb.WriteString("S\n")
+	case patternGrid:
+		sb.WriteString("0.75 G 0.4 w\n")
+		for x := spacing; x < width; x += spacing {
+			line(x, 0, x, height)
+		}
+		for y := height - spacing; y > 0; y -= spacing {
+			line(0, y, width, y)
+		}
+		sb.WriteString("S\n")
+	case patternDots:
+		sb.WriteString("0.5 g\n")
+		for x := spacing; x < width; x += spacing {
+			for y := height - spacing; y > 0; y -= spacing {
+				fmt.Fprintf(&sb, "%s %s 1 1 re\n", pdfNumber(x-0.5), pdfNumber(y-0.5))
+			}
+		}
+		sb.WriteString("f\n")
+	}
+	if t.margin > 0 {
+		x := t.margin * notebookPixel
+		sb.WriteString("0.6 G 0.5 w\n")
+		line(x, 0, x, height)
+		sb.WriteString("S\n")
+	}
+	return sb.String()
+}
+
+// templatePdfPages returns a PDF page of each template in pagedata, and pagedata with the rendered templates replaced
+// by "Blank". Unknown templates, e.g. custom ones, become blank pages and stay in the pagedata.
+func templatePdfPages(env *Env, pagedata []string, pageCount int) ([]rawPdfPage, []string) {
+	pages := make([]rawPdfPage, pageCount)
+	newPagedata := make([]string, pageCount)
+	for i := range pages {
+		name := "Blank"
+		if i < len(pagedata) && pagedata[i] != "" {
+			name = pagedata[i]
+		}
+		newPagedata[i] = name
+
+		t, ok := templates[name]
+		if ok {
+			newPagedata[i] = "Blank"
+		} else {
+			env.Logf("Unknown template %q on page %d, using a blank page", name, i+1)
+		}
+		pages[i] = rawPdfPage{width: notebookPageWidth, height: notebookPageHeight, content: t.content(notebookPageWidth, notebookPageHeight)}
+	}
+	return pages, newPagedata
+}
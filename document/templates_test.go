@@ -0,0 +1,92 @@
+package document
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+func TestToPdfDocTemplates(t *testing.T) {
+	doc := Document{Uuid: "notebook"}
+	doc.Content.FileType = "notebook"
+	doc.Content.PageCount = 5
+	// The pagedata may be shorter than the document, missing templates are blank
+	doc.Pagedata = []string{"P Lines small", "LS Grid medium", "My custom template", ""}
+
+	pdfDoc, err := doc.ToPdfDoc(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdfDoc.Close()
+
+	if pdfDoc.Content.FileType != "pdf" {
+		t.Errorf("file type %q, want pdf", pdfDoc.Content.FileType)
+	}
+	wantPagedata := []string{"Blank", "Blank", "My custom template", "Blank", "Blank"}
+	if fmt.Sprint(pdfDoc.Pagedata) != fmt.Sprint(wantPagedata) {
+		t.Errorf("pagedata %q, want %q", pdfDoc.Pagedata, wantPagedata)
+	}
+
+	dims, err := PdfPageDims(pdfDoc.Pdf.Reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dims) != doc.Content.PageCount {
+		t.Fatalf("%d pages, want %d", len(dims), doc.Content.PageCount)
+	}
+	for i, dim := range dims {
+		// Landscape templates are drawn sideways on a portrait page, like on the tablet
+		if dim != (pdfcpu.Dim{Width: notebookPageWidth, Height: notebookPageHeight}) {
+			t.Errorf("page %d is %v, want %vx%v", i+1, dim, notebookPageWidth, notebookPageHeight)
+		}
+	}
+}
+
+func TestTemplateContent(t *testing.T) {
+	tests := []struct {
+		name string
+		// want are substrings of the content stream, none if the page is empty
+		want []string
+	}{
+		{"Blank", nil},
+		{"P Lines small", []string{" l\n", "S\n"}},
+		{"P Margin medium", []string{" l\n", "S\n"}},
+		{"LS Grid large", []string{"0 1 -1 0 445 0 cm\n", " l\n"}},
+		{"P Dots S", []string{" re\n", "f\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, ok := templates[tt.name]
+			if !ok {
+				t.Fatalf("no template %q", tt.name)
+			}
+			content := tmpl.content(notebookPageWidth, notebookPageHeight)
+			if len(tt.want) == 0 && content != "" {
+				t.Errorf("content %q, want none", content)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(content, want) {
+					t.Errorf("content does not contain %q", want)
+				}
+			}
+		})
+	}
+}
+
+func TestLandscapeTemplate(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"P Lines small", "LS Lines small"},
+		{"LS Lines small", "LS Lines small"},
+		{"Blank", "Blank"},
+		{"P My custom template", "P My custom template"},
+	}
+	for _, tt := range tests {
+		if got := LandscapeTemplate(tt.name); got != tt.want {
+			t.Errorf("LandscapeTemplate(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
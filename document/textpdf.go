@@ -0,0 +1,69 @@
+package document
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Layout of text PDFs, in points. The page has the aspect ratio of the reMarkable's screen.
+const (
+	textPdfWidth    = 612
+	textPdfHeight   = 816
+	textPdfMargin   = 40
+	textPdfFontSize = 10
+	textPdfLeading  = 14
+	// Courier glyphs are 0.6 of the font size wide
+	textPdfLineLength   = (textPdfWidth - 2*textPdfMargin) * 10 / (6 * textPdfFontSize)
+	textPdfLinesPerPage = (textPdfHeight - 2*textPdfMargin) / textPdfLeading
+)
+
+// WriteTextPdf writes a PDF showing lines in a monospace font to w, e.g. for reports.
+// Long lines are wrapped and non-ASCII characters are replaced by '?'.
+func WriteTextPdf(w io.Writer, lines []string) error {
+	wrapped := make([]string, 0, len(lines))
+	for _, line := range lines {
+		runes := []rune(line)
+		for len(runes) > textPdfLineLength {
+			wrapped = append(wrapped, string(runes[:textPdfLineLength]))
+			runes = runes[textPdfLineLength:]
+		}
+		wrapped = append(wrapped, string(runes))
+	}
+
+	pages := make([][]string, 0)
+	for len(wrapped) > textPdfLinesPerPage {
+		pages = append(pages, wrapped[:textPdfLinesPerPage])
+		wrapped = wrapped[textPdfLinesPerPage:]
+	}
+	pages = append(pages, wrapped)
+
+	rawPages := make([]rawPdfPage, len(pages))
+	for i, page := range pages {
+		content := strings.Builder{}
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d TL %d %d Td\n", textPdfFontSize, textPdfLeading, textPdfMargin, textPdfHeight-textPdfMargin-textPdfFontSize)
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", escapePdfString(line))
+		}
+		content.WriteString("ET")
+		rawPages[i] = rawPdfPage{width: textPdfWidth, height: textPdfHeight, content: content.String()}
+	}
+	return writeRawPdf(w, rawPages)
+}
+
+// escapePdfString escapes s for use in a PDF string literal.
+func escapePdfString(s string) string {
+	sb := strings.Builder{}
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		case r < 32 || r > 126:
+			sb.WriteRune('?')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,159 @@
+package document
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Layout of generated title and contents pages, relative to the page width. Courier glyphs are 0.6 of the font size wide.
+const (
+	tocMarginRatio   = 0.09
+	tocFontRatio     = 0.025
+	tocLeadingRatio  = 1.8
+	titleFontRatio   = 0.05
+	headingFontRatio = 0.045
+)
+
+// TocEntry is an entry of a table of contents, see WriteContentsPdf.
+type TocEntry struct {
+	Title string
+	// Page is the page the entry starts on, starting at 1.
+	Page int
+}
+
+// PageLink is a link on page Page to page Target of a PDF, both starting at 1. Rect is the clickable area, as the lower
+// left and upper right corner in points.
+type PageLink struct {
+	Page   int
+	Rect   [4]float64
+	Target int
+}
+
+// tocLayout is the layout of contents pages of width x height points.
+type tocLayout struct {
+	width, height     float64
+	margin            float64
+	fontSize, leading float64
+	headingSize       float64
+	// lineLength is the number of characters fitting on a line, linesPerPage the number of entries on a page.
+	lineLength, linesPerPage int
+}
+
+func newTocLayout(width, height float64) tocLayout {
+	l := tocLayout{width: width, height: height}
+	l.margin = width * tocMarginRatio
+	l.fontSize = width * tocFontRatio
+	l.leading = l.fontSize * tocLeadingRatio
+	l.headingSize = width * headingFontRatio
+	l.lineLength = int((width - 2*l.margin) / (0.6 * l.fontSize))
+	l.linesPerPage = int((height - 2*l.margin - 2*l.headingSize) / l.leading)
+	if l.linesPerPage < 1 {
+		l.linesPerPage = 1
+	}
+	return l
+}
+
+// ContentsPageCount returns the number of pages of width x height points WriteContentsPdf needs for n entries.
+func ContentsPageCount(n int, width, height float64) int {
+	l := newTocLayout(width, height)
+	if n == 0 {
+		return 1
+	}
+	return (n + l.linesPerPage - 1) / l.linesPerPage
+}
+
+// WriteContentsPdf writes a table of contents listing entries with their page to w, on pages of width x height points.
+// It returns the links from the entries to their pages, to be added with AddPageLinks once the pages exist; the pages
+// of the links start at firstPage, the page of the PDF the contents end up on.
+func WriteContentsPdf(w io.Writer, entries []TocEntry, width, height float64, firstPage int) ([]PageLink, error) {
+	l := newTocLayout(width, height)
+	pageCount := ContentsPageCount(len(entries), width, height)
+	pages := make([]rawPdfPage, pageCount)
+	links := make([]PageLink, 0, len(entries))
+	for p := range pages {
+		content := strings.Builder{}
+		y := height - l.margin - l.headingSize
+		if p == 0 {
+			fmt.Fprintf(&content, "BT /F1 %s Tf %s %s Td (Contents) Tj ET\n", pdfNumber(l.headingSize), pdfNumber(l.margin), pdfNumber(y))
+		}
+		y -= 2 * l.headingSize
+
+		start := p * l.linesPerPage
+		end := start + l.linesPerPage
+		if end > len(entries) {
+			end = len(entries)
+		}
+		for _, entry := range entries[start:end] {
+			fmt.Fprintf(&content, "BT /F1 %s Tf %s %s Td (%s) Tj ET\n", pdfNumber(l.fontSize), pdfNumber(l.margin), pdfNumber(y), escapePdfString(tocLine(entry, l.lineLength)))
+			links = append(links, PageLink{
+				Page:   firstPage + p,
+				Rect:   [4]float64{l.margin, y - 0.3*l.fontSize, width - l.margin, y + l.fontSize},
+				Target: entry.Page,
+			})
+			y -= l.leading
+		}
+		pages[p] = rawPdfPage{width: width, height: height, content: content.String()}
+	}
+	return links, writeRawPdf(w, pages)
+}
+
+// tocLine returns the line of entry, the title and the page number separated by dots, of length n.
+func tocLine(entry TocEntry, n int) string {
+	page := fmt.Sprintf(" %d", entry.Page)
+	title := []rune(entry.Title)
+	if max := n - len(page) - 2; len(title) > max {
+		if max < 1 {
+			max = 1
+		}
+		title = append(title[:max-1], '~')
+	}
+	dots := n - len(title) - len(page)
+	if dots < 1 {
+		dots = 1
+	}
+	return string(title) + " " + strings.Repeat(".", dots-1) + page
+}
+
+// WriteTitlePdf writes a page of width x height points showing title to w, e.g. to separate merged documents.
+func WriteTitlePdf(w io.Writer, title string, width, height float64) error {
+	margin := width * tocMarginRatio
+	fontSize := width * titleFontRatio
+	lineLength := int((width - 2*margin) / (0.6 * fontSize))
+
+	// Wrap the title at spaces where possible
+	lines := make([]string, 0)
+	line := ""
+	for _, word := range strings.Fields(title) {
+		for len([]rune(word)) > lineLength {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, string([]rune(word)[:lineLength]))
+			word = string([]rune(word)[lineLength:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case len([]rune(line))+1+len([]rune(word)) <= lineLength:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	content := strings.Builder{}
+	leading := fontSize * 1.4
+	y := height/2 + float64(len(lines)-1)*leading/2
+	for _, line := range lines {
+		x := (width - float64(len([]rune(line)))*0.6*fontSize) / 2
+		fmt.Fprintf(&content, "BT /F1 %s Tf %s %s Td (%s) Tj ET\n", pdfNumber(fontSize), pdfNumber(x), pdfNumber(y), escapePdfString(line))
+		y -= leading
+	}
+	return writeRawPdf(w, []rawPdfPage{{width: width, height: height, content: content.String()}})
+}
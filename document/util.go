@@ -7,37 +7,50 @@ import (
 	"strings"
 )
 
-func getContentFromReader(r io.ReadCloser) Content {
-	buf := getBytesFromReader(r)
+func getContentFromReader(r io.ReadCloser) (Content, error) {
 	content := Content{}
-	err := json.Unmarshal(buf, &content)
+	buf, err := getBytesFromReader(r)
 	if err != nil {
-		panic(err)
+		return content, err
 	}
-	return content
+	err = json.Unmarshal(buf, &content)
+	if err != nil {
+		return content, documentError("parse content", err)
+	}
+	return content, nil
 }
 
-func getPagedataFromReader(r io.ReadCloser) []string {
-	buf := getBytesFromReader(r)
+func getPagedataFromReader(r io.ReadCloser) ([]string, error) {
+	buf, err := getBytesFromReader(r)
+	if err != nil {
+		return nil, err
+	}
 	pagedataRaw := string(buf)
-	return strings.Split(pagedataRaw[:len(pagedataRaw) - 1], "\n")
+	return strings.Split(strings.TrimSuffix(pagedataRaw, "\n"), "\n"), nil
 }
 
-func getBytesFromReader(r io.ReadCloser) []byte {
+func getBytesFromReader(r io.ReadCloser) ([]byte, error) {
 	buf, err := io.ReadAll(r)
 	if err != nil {
-		panic(err)
+		return nil, documentError("read .zip entry", err)
 	}
-	return buf
+	return buf, nil
 }
 
-func writeToZip(w *zip.Writer, fileName string, data []byte) {
+func writeToZip(w *zip.Writer, fileName string, data []byte) error {
 	fw, err := w.Create(fileName)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	_, err = fw.Write(data)
+	return err
+}
+
+func writeBlobToZip(w *zip.Writer, fileName string, b *Blob) error {
+	fw, err := w.Create(fileName)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	_, err = io.Copy(fw, b.Reader())
+	return err
 }
@@ -0,0 +1,193 @@
+package document
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"io"
+	"strings"
+)
+
+// ValidationError lists the problems found in an invalid document .zip.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid document: %s", strings.Join(e.Problems, "; "))
+}
+
+// Validate checks that the document .zip in fileName is consistent, returning a *ValidationError if it is not:
+// the page count of the content, its pages, the pagedata and the PDF must agree, page UUIDs must be unique,
+// every page file must belong to an existing page, and all JSON files must be well-formed.
+func Validate(env *Env, fileName string) error {
+	r, err := zip.OpenReader(fileName)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return ValidateZip(env, &r.Reader)
+}
+
+// ValidateZip is like Validate, but for an opened .zip.
+func ValidateZip(env *Env, r *zip.Reader) error {
+	problems := make([]string, 0)
+	addProblem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	uuid, ok := ZipUuid(r)
+	if !ok {
+		return &ValidationError{Problems: []string{"missing .content file"}}
+	}
+
+	var content *Content
+	var pagedata []string
+	hasPdf := false
+	pdfPageCount := -1
+	pageFiles := make([]PageFile, 0)
+
+	for _, f := range r.File {
+		entry := ClassifyEntry(f.Name, uuid)
+		switch entry.Kind {
+		case EntryContent:
+			c := Content{}
+			if err := readZipJson(f, &c); err != nil {
+				addProblem("%s is not valid JSON: %v", f.Name, err)
+				continue
+			}
+			content = &c
+		case EntryMetadata:
+			if err := readZipJson(f, &map[string]interface{}{}); err != nil {
+				addProblem("%s is not valid JSON: %v", f.Name, err)
+			}
+		case EntryPagedata:
+			data, err := readZipFile(f)
+			if err != nil {
+				addProblem("%s cannot be read: %v", f.Name, err)
+				continue
+			}
+			pagedata = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			if len(data) == 0 {
+				pagedata = []string{}
+			}
+		case EntryPdf:
+			hasPdf = true
+			n, err := zipPdfPageCount(env, f)
+			if err != nil {
+				addProblem("%s cannot be read: %v", f.Name, err)
+				continue
+			}
+			pdfPageCount = n
+		case EntryPageFile:
+			pageFiles = append(pageFiles, entry.PageFile)
+			switch entry.PageFile.Kind {
+			case PageFileMetadata, PageFileHighlights, PageFileTextConversion:
+				if err := readZipJson(f, &map[string]interface{}{}); err != nil {
+					addProblem("%s is not valid JSON: %v", f.Name, err)
+				}
+			}
+		}
+	}
+
+	if content == nil {
+		return &ValidationError{Problems: problems}
+	}
+
+	if content.CPages != nil {
+		// The pages of .rmdoc documents refer to PDF pages instead of matching them one to one
+		pageCount := content.PageCount
+		content.UseCPages()
+		if pageCount != content.PageCount {
+			addProblem("content has pageCount %d but %d pages", pageCount, content.PageCount)
+		}
+		for _, p := range content.CPages.LivePages() {
+			if p.Redir != nil && pdfPageCount != -1 && (p.Redir.Value < 0 || p.Redir.Value >= pdfPageCount) {
+				addProblem("page %s shows page %d of the PDF, which has %d pages", p.Id, p.Redir.Value+1, pdfPageCount)
+			}
+		}
+	} else {
+		if content.PageCount != len(content.Pages) {
+			addProblem("content has pageCount %d but %d pages", content.PageCount, len(content.Pages))
+		}
+		if pagedata != nil && len(pagedata) != content.PageCount {
+			addProblem("pagedata has %d lines but content has pageCount %d", len(pagedata), content.PageCount)
+		}
+		if pdfPageCount != -1 && pdfPageCount != content.PageCount {
+			addProblem("PDF has %d pages but content has pageCount %d", pdfPageCount, content.PageCount)
+		}
+	}
+	if content.FileType == "pdf" && !hasPdf {
+		addProblem("missing .pdf file")
+	}
+
+	pageUuids := make(map[string]bool)
+	for _, pageUuid := range content.Pages {
+		if pageUuids[pageUuid] {
+			addProblem("page UUID %s occurs more than once", pageUuid)
+		}
+		pageUuids[pageUuid] = true
+	}
+
+	if content.CPages != nil {
+		// Deleted pages of .rmdoc documents may keep their page files
+		for _, p := range content.CPages.Pages {
+			pageUuids[p.Id] = true
+		}
+	}
+
+	for _, pf := range pageFiles {
+		if pf.Key.IsUuid() && !pageUuids[pf.Key.Uuid] || !pf.Key.IsUuid() && pf.Key.Index >= len(content.Pages) {
+			addProblem("%s belongs to no page", pf.Path(uuid))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func readZipJson(f *zip.File, v interface{}) error {
+	data, err := readZipFile(f)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func zipPdfPageCount(env *Env, f *zip.File) (int, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	pdf := env.NewBlob()
+	defer pdf.Close()
+	_, err = io.Copy(pdf, rc)
+	rc.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, err := api.ReadContext(pdf.Reader(), pdfcpu.NewDefaultConfiguration())
+	if err != nil {
+		return 0, err
+	}
+	err = ctx.EnsurePageCount()
+	if err != nil {
+		return 0, err
+	}
+	return ctx.PageCount, nil
+}
@@ -0,0 +1,173 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// zipFile is a file of a test document .zip.
+type zipFile struct {
+	name string
+	data []byte
+}
+
+// testZipFiles returns the files of a valid document .zip of a PDF with 2 pages, and its UUID.
+func testZipFiles(t *testing.T) ([]zipFile, string) {
+	t.Helper()
+	pdfDoc, err := FromPdfReader(nil, bytes.NewReader(testPdf(t, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdfDoc.Close()
+	buf := bytes.Buffer{}
+	if err = pdfDoc.WriteZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make([]zipFile, 0)
+	for _, f := range r.File {
+		data, err := readZipFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, zipFile{f.Name, data})
+	}
+	return files, pdfDoc.Uuid
+}
+
+// testZip returns a .zip of files.
+func testZip(t *testing.T, files []zipFile) *zip.Reader {
+	t.Helper()
+	buf := bytes.Buffer{}
+	w := zip.NewWriter(&buf)
+	for _, f := range files {
+		fw, err := w.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = fw.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// setContent changes the content of the files of document uuid by modify.
+func setContent(t *testing.T, files []zipFile, uuid string, modify func(content map[string]interface{})) {
+	t.Helper()
+	for i, f := range files {
+		if f.name != uuid+".content" {
+			continue
+		}
+		content := make(map[string]interface{})
+		if err := json.Unmarshal(f.data, &content); err != nil {
+			t.Fatal(err)
+		}
+		modify(content)
+		data, err := json.Marshal(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[i].data = data
+	}
+}
+
+// withoutFile returns files without the file name.
+func withoutFile(files []zipFile, name string) []zipFile {
+	res := make([]zipFile, 0, len(files))
+	for _, f := range files {
+		if f.name != name {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+func TestValidateZip(t *testing.T) {
+	const unknownUuid = "c3a2f0d6-7b1e-4a5c-8e8a-0d5b5b0a9a11"
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, files []zipFile, uuid string) []zipFile
+		// problem is part of the only problem found, or empty if the document is valid
+		problem string
+	}{
+		{"valid", func(t *testing.T, files []zipFile, uuid string) []zipFile {
+			return files
+		}, ""},
+		{"page count mismatch", func(t *testing.T, files []zipFile, uuid string) []zipFile {
+			setContent(t, files, uuid, func(content map[string]interface{}) {
+				content["pages"] = content["pages"].([]interface{})[:1]
+			})
+			return files
+		}, "content has pageCount 2 but 1 pages"},
+		{"PDF page count mismatch", func(t *testing.T, files []zipFile, uuid string) []zipFile {
+			files = withoutFile(files, uuid+".pdf")
+			return append(files, zipFile{uuid + ".pdf", testPdf(t, 3)})
+		}, "PDF has 3 pages but content has pageCount 2"},
+		{"pagedata mismatch", func(t *testing.T, files []zipFile, uuid string) []zipFile {
+			files = withoutFile(files, uuid+".pagedata")
+			return append(files, zipFile{uuid + ".pagedata", []byte("Blank\n")})
+		}, "pagedata has 1 lines but content has pageCount 2"},
+		{"missing PDF", func(t *testing.T, files []zipFile, uuid string) []zipFile {
+			return withoutFile(files, uuid+".pdf")
+		}, "missing .pdf file"},
+		{"invalid PDF", func(t *testing.T, files []zipFile, uuid string) []zipFile {
+			files = withoutFile(files, uuid+".pdf")
+			return append(files, zipFile{uuid + ".pdf", []byte("%PDF-1.7")})
+		}, ".pdf cannot be read"},
+		{"dangling .rm", func(t *testing.T, files []zipFile, uuid string) []zipFile {
+			return append(files, zipFile{uuid + "/2.rm", []byte("rm")})
+		}, "/2.rm belongs to no page"},
+		{"unknown page UUID", func(t *testing.T, files []zipFile, uuid string) []zipFile {
+			return append(files, zipFile{uuid + "/" + unknownUuid + ".rm", []byte("rm")})
+		}, "/" + unknownUuid + ".rm belongs to no page"},
+		{"duplicate page UUID", func(t *testing.T, files []zipFile, uuid string) []zipFile {
+			setContent(t, files, uuid, func(content map[string]interface{}) {
+				pages := content["pages"].([]interface{})
+				pages[1] = pages[0]
+			})
+			return files
+		}, "occurs more than once"},
+		{"invalid JSON", func(t *testing.T, files []zipFile, uuid string) []zipFile {
+			return append(files, zipFile{uuid + "/0-metadata.json", []byte("{")})
+		}, "0-metadata.json is not valid JSON"},
+		{"missing content", func(t *testing.T, files []zipFile, uuid string) []zipFile {
+			return withoutFile(files, uuid+".content")
+		}, "missing .content file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, uuid := testZipFiles(t)
+			err := ValidateZip(nil, testZip(t, tt.modify(t, files, uuid)))
+			if tt.problem == "" {
+				if err != nil {
+					t.Fatalf("valid document: %v", err)
+				}
+				return
+			}
+
+			validationErr := &ValidationError{}
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("got error %v, want a ValidationError", err)
+			}
+			if len(validationErr.Problems) != 1 || !strings.Contains(validationErr.Problems[0], tt.problem) {
+				t.Errorf("got problems %q, want one containing %q", validationErr.Problems, tt.problem)
+			}
+		})
+	}
+}
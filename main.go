@@ -1,142 +1,881 @@
 package main
 
 import (
+	"archive/zip"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/juruen/rmapi/model"
 	"github.com/skius/rm-pdf-tools/actions"
 	"github.com/skius/rm-pdf-tools/cloud"
+	"github.com/skius/rm-pdf-tools/document"
+	"github.com/skius/rm-pdf-tools/rmpdf"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
+	"strings"
 )
 
 const remoteWorkDir = "/pdf-tools/"
 const remoteWatchDir = remoteWorkDir + "work/"
-const remoteMergeDirActive = remoteWorkDir + "merge!/"
 const remoteMergeDirPassive = remoteWorkDir + "merge/"
 const remoteOriginalDir = remoteWorkDir + "original/"
 const remoteProcessedDir = remoteWorkDir + "processed/"
 
+// mergeActivePrefix starts the name of a folder in remoteWorkDir whose documents are merged, i.e. merge/ after renaming
+// it. The rest of the name, if any, is the name of the merged document, e.g. "merge! Physics Week 3".
+const mergeActivePrefix = "merge!"
+
+// defaultMergedName is the name of a merged document if its merge folder doesn't name it.
+const defaultMergedName = "merged"
+
+// importPrefix starts the name of a document in a merge folder which stands for a PDF imported from the local import
+// directory, e.g. "import: handouts/week3.pdf".
+const importPrefix = "import:"
+
+// rebaseDirName is the name of the folder in remoteWatchDir in which an annotated document and its new PDF are rebased.
+const rebaseDirName = "rebase"
+
+// undoDirName is the name of the folder in remoteWatchDir in which the edits that produced documents are undone.
+const undoDirName = "undo"
+
+// previewSuffix marks a folder in remoteWatchDir whose actions are only previewed, not applied.
+const previewSuffix = "?"
+
+// applySuffix marks a folder in remoteWatchDir whose actions are applied, i.e. a previewed folder after renaming it.
+const applySuffix = "!"
+
+// Suffixes appended to the name of documents which could not be processed, see handleError.
+const (
+	invalidActionsSuffix = " (invalid actions)"
+	invalidResultSuffix  = " (invalid result)"
+	unsupportedSuffix    = " (unsupported document)"
+	failedSuffix         = " (failed)"
+)
+
+// usage lists the subcommands. Without one, rm-pdf-tools processes the documents in the work and merge folders.
+const usage = `usage: rm-pdf-tools [flags]
+       rm-pdf-tools edit <file.zip> <actions> -o <out.zip>
+       rm-pdf-tools merge [-title-pages] [-contents] <file.zip>... -o <out.zip>
+       rm-pdf-tools inspect <file.zip>...
+       rm-pdf-tools validate <file.zip>...
+       rm-pdf-tools dry-run <actions> <file.zip>...
+       rm-pdf-tools preview <actions> <file.zip> <preview.pdf>
+
+Without a subcommand, the documents in the work and merge folders of the cloud are processed.
+
+Flags:
+`
+
 func main() {
-	c, err := cloud.New()
+	env := &document.Env{Log: log.New(os.Stdout, "", 0)}
+	flag.Int64Var(&env.MemoryLimit, "memory-limit", document.DefaultMemoryLimit, "size in bytes up to which copies of a PDF are kept in memory, larger copies are buffered in temporary files; parsed PDFs are always held in memory")
+	flag.StringVar(&env.TempDir, "temp-dir", "", "directory for temporary files, the system default if empty")
+	mergeCfg := mergeConfig{}
+	flag.BoolVar(&mergeCfg.opts.TitlePages, "merge-title-pages", false, "add a title page before each document merged in the cloud")
+	flag.BoolVar(&mergeCfg.opts.Contents, "merge-contents", false, "add a table of contents to documents merged in the cloud")
+	flag.StringVar(&mergeCfg.importDir, "import-dir", "", "local directory of PDFs which can be imported into merges in the cloud")
+	localCloud := flag.String("local-cloud", "", "local directory of document .zip files used instead of the reMarkable cloud")
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var err error
+	switch flag.Arg(0) {
+	case "":
+		err = run(env, *localCloud, mergeCfg)
+	case "edit":
+		err = editFile(env, flag.Args()[1:])
+	case "merge":
+		err = mergeFiles(env, flag.Args()[1:])
+	case "inspect":
+		if flag.NArg() < 2 {
+			err = errors.New("usage: rm-pdf-tools inspect <file.zip>...")
+			break
+		}
+		err = inspectFiles(flag.Args()[1:])
+	case "validate":
+		if flag.NArg() < 2 {
+			err = errors.New("usage: rm-pdf-tools validate <file.zip>...")
+			break
+		}
+		err = validateFiles(env, flag.Args()[1:])
+	case "dry-run":
+		if flag.NArg() < 3 {
+			err = errors.New("usage: rm-pdf-tools dry-run <actions> <file.zip>...")
+			break
+		}
+		err = dryRunFiles(flag.Arg(1), flag.Args()[2:])
+	case "preview":
+		if flag.NArg() != 4 {
+			err = errors.New("usage: rm-pdf-tools preview <actions> <file.zip> <preview.pdf>")
+			break
+		}
+		err = previewFile(env, flag.Arg(1), flag.Arg(2), flag.Arg(3))
+	default:
+		flag.Usage()
+		err = fmt.Errorf("unknown subcommand %q", flag.Arg(0))
+	}
 	if err != nil {
-		panic(err)
+		fmt.Println("Error:", err)
+		os.Exit(1)
 	}
+}
+
+// mergeConfig configures the merges in the cloud.
+type mergeConfig struct {
+	opts rmpdf.MergeOptions
+	// importDir is the local directory PDFs are imported from, see importPrefix. Imports are disabled if it is empty.
+	importDir string
+}
 
-	docsToEdit := c.FindNewFilesEdit(remoteWatchDir)
+// run processes all documents in the work and merge folders of the cloud, or of the local directory localCloud if it
+// isn't empty, see cloud.Local.
+func run(env *document.Env, localCloud string, mergeCfg mergeConfig) error {
+	var c cloud.Backend
+	var err error
+	if localCloud != "" {
+		c, err = cloud.NewLocal(localCloud)
+	} else {
+		c, err = cloud.New()
+	}
+	if err != nil {
+		return err
+	}
+
+	docsToEdit, err := cloud.FindNewFilesEdit(c, remoteWatchDir)
+	if err != nil {
+		return err
+	}
+	docsToRebase := make([]*model.Node, 0)
 	if len(docsToEdit) == 0 {
 		fmt.Println("No docs to edit found!")
 	} else {
 		for _, f := range docsToEdit {
-			processDoc(c, f)
+			if f.Parent.Name() == rebaseDirName {
+				docsToRebase = append(docsToRebase, f)
+				continue
+			}
+			if f.Parent.Name() == undoDirName {
+				handleError(c, []*model.Node{f}, undoDoc(env, c, f))
+				continue
+			}
+			if strings.HasSuffix(f.Parent.Name(), previewSuffix) {
+				handleError(c, []*model.Node{f}, previewDoc(env, c, f))
+				continue
+			}
+			handleError(c, []*model.Node{f}, processDoc(env, c, f))
 		}
 	}
 
-	docsToMerge := c.FindNewFilesMerge(remoteMergeDirActive)
-	if len(docsToMerge) == 0 {
+	if len(docsToRebase) == 2 {
+		handleError(c, docsToRebase, rebaseDoc(env, c, docsToRebase))
+	} else if len(docsToRebase) > 0 {
+		fmt.Println("Waiting for exactly two docs to rebase, found", len(docsToRebase))
+	}
+
+	mergeDirs, err := cloud.FindDirs(c, remoteWorkDir, mergeActivePrefix)
+	if err != nil {
+		return err
+	}
+	if len(mergeDirs) == 0 {
 		fmt.Println("No docs to merge found!")
-	} else {
-		mergeDocs(c, docsToMerge)
-		md, err := c.FindFile(remoteMergeDirActive)
+	}
+	for _, md := range mergeDirs {
+		err = mergeDir(env, c, md, mergeCfg)
 		if err != nil {
-			panic(err)
+			return err
 		}
-		_, err = c.Move(md, remoteWorkDir, "merge")
-		if err != nil {
-			panic(err)
+	}
+
+	return nil
+}
+
+// mergeGroup is a set of documents which are merged into a document called name.
+type mergeGroup struct {
+	name  string
+	order mergeOrder
+	nodes []*model.Node
+	// manifest is the document listing the merge order, if any, see isManifest. It is not merged.
+	manifest *model.Node
+}
+
+// newMergeGroup returns the group of the documents in dir called folderName, the name of the merged document and its
+// order are taken from folderName, see parseMergeName. Without an order suffix, order applies.
+func newMergeGroup(dir *model.Node, folderName string, order mergeOrder) mergeGroup {
+	name, folderOrder, ok := parseMergeName(folderName)
+	if ok {
+		order = folderOrder
+	}
+	if name == "" {
+		name = defaultMergedName
+	}
+	group := mergeGroup{name: name, order: order}
+	for _, node := range dir.Children {
+		switch {
+		case node.IsDirectory():
+		case isManifest(node) && group.manifest == nil:
+			group.manifest = node
+			group.order, _ = parseOrder(node.Name())
+		default:
+			group.nodes = append(group.nodes, node)
 		}
 	}
+	return group
+}
 
+// allNodes returns the documents of the group, including the manifest.
+func (g mergeGroup) allNodes() []*model.Node {
+	if g.manifest == nil {
+		return g.nodes
+	}
+	return append(append([]*model.Node{}, g.nodes...), g.manifest)
 }
 
-// mergeDocs merges the given documents and uploads the resulting document (merge order is alphabetical in their names).
-func mergeDocs(c *cloud.Cloud, nodes []*model.Node) {
-	sort.Slice(nodes, func(i, j int) bool {
-		return nodes[i].Name() < nodes[j].Name()
+// mergeGroups returns the groups of documents to merge in the merge folder dir: the documents directly in dir, named
+// after dir, and the documents in each subfolder of dir, named after the subfolder. Subfolders without an order suffix
+// use the order of dir.
+func mergeGroups(dir *model.Node) []mergeGroup {
+	top := newMergeGroup(dir, strings.TrimPrefix(dir.Name(), mergeActivePrefix), mergeOrder{by: orderByName})
+	groups := make([]mergeGroup, 0)
+	for _, node := range dir.Children {
+		if !node.IsDirectory() {
+			continue
+		}
+		group := newMergeGroup(node, node.Name(), top.order)
+		if len(group.nodes) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return naturalLess(groups[i].name, groups[j].name)
 	})
-	mkFileName := func(i int, uuid string) string { return fmt.Sprintf("doc-%d-%s.zip", i, uuid) }
+	if len(top.nodes) > 0 {
+		groups = append([]mergeGroup{top}, groups...)
+	}
+	return groups
+}
 
-	fileNamesToMerge := make([]string, len(nodes))
-	uuids := make([]string, len(nodes))
-	for i, node := range nodes {
-		fn := mkFileName(i, node.Id())
-		err := c.Download(node, fn)
-		if err != nil {
-			panic(err)
+// mergeDir merges each group of documents in the merge folder dir independently, see mergeGroups, and renames dir back
+// to merge/ unless a group is to be retried.
+func mergeDir(env *document.Env, c cloud.Backend, dir *model.Node, cfg mergeConfig) error {
+	groups := mergeGroups(dir)
+	if len(groups) == 0 {
+		fmt.Println("No docs to merge found in", dir.Name())
+		return nil
+	}
+
+	retry := false
+	for _, group := range groups {
+		fmt.Println("Merging", len(group.nodes), "docs into:", group.name)
+		err := mergeDocs(env, c, group, cfg)
+		handleError(c, group.allNodes(), err)
+		if cloud.IsTemporary(err) {
+			retry = true
 		}
-		fileNamesToMerge[i] = fn
-		uuids[i] = node.Id()
+	}
+	if retry {
+		// Keep the merge folder to retry the failed groups
+		return nil
 	}
 
-	outDocName := "merged"
-	outFileName := outDocName + ".zip"
-	actions.MergeFiles(fileNamesToMerge, uuids, outFileName)
+	_, err := c.Move(dir, remoteWorkDir, "merge")
+	return err
+}
 
-	_, err := c.Upload(outFileName, remoteProcessedDir)
-	if err != nil {
-		panic(err)
+// handleError reports the error of processing nodes, if any. On temporary cloud errors the nodes are left where they are
+// to be retried. Otherwise, they are moved to remoteOriginalDir with a suffix describing the problem, so they are not
+// processed again.
+func handleError(c cloud.Backend, nodes []*model.Node, err error) {
+	if err == nil {
+		return
+	}
+	fmt.Println("Error:", err)
+
+	var actionErr *actions.ActionError
+	var validationErr *document.ValidationError
+	var documentErr *document.DocumentError
+	suffix := failedSuffix
+	switch {
+	case cloud.IsTemporary(err):
+		fmt.Println("Retrying later")
+		return
+	case errors.As(err, &actionErr):
+		suffix = invalidActionsSuffix
+	case errors.As(err, &validationErr):
+		suffix = invalidResultSuffix
+	case errors.As(err, &documentErr):
+		suffix = unsupportedSuffix
 	}
+
 	for _, node := range nodes {
-		_, err = c.Move(node, remoteOriginalDir, node.Name())
+		_, err := c.Move(node, remoteOriginalDir, node.Name()+suffix)
 		if err != nil {
-			panic(err)
+			fmt.Println("Error:", err)
 		}
 	}
+}
 
-	err = os.Remove(outFileName)
-	if err != nil {
-		panic(err)
+// mergeDocs merges the documents of group in the order of the group, see sortMergeDocs, and uploads the resulting
+// document. Documents standing for imports are replaced by the imported PDF, see importFileName.
+func mergeDocs(env *document.Env, c cloud.Backend, group mergeGroup, cfg mergeConfig) error {
+	mkFileName := func(i int, uuid string) string { return fmt.Sprintf("doc-%d-%s.zip", i, uuid) }
+
+	outFileName := localFileName(group.name) + "_merged.zip"
+	docs := make([]mergeDoc, len(group.nodes))
+	fileNames := []string{outFileName}
+	for i, node := range group.nodes {
+		docs[i] = mergeDoc{node: node, name: node.Name(), fileName: mkFileName(i, node.Id())}
+		if !isImport(node) {
+			fileNames = append(fileNames, docs[i].fileName)
+			continue
+		}
+		fileName, err := importFileName(cfg.importDir, node.Name())
+		if err != nil {
+			return err
+		}
+		docs[i].name = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+		docs[i].fileName = fileName
+		docs[i].imported = true
 	}
+	// Imported files are left as they are
+	defer removeFiles(fileNames...)
 
-	for _, fn := range fileNamesToMerge {
-		err = os.Remove(fn)
+	for _, doc := range docs {
+		if doc.imported {
+			continue
+		}
+		err := c.Download(doc.node, doc.fileName)
 		if err != nil {
-			panic(err)
+			return err
 		}
 	}
+
+	err := sortMergeDocs(group.order, docs)
+	if err != nil {
+		return err
+	}
+	fileNamesToMerge := make([]string, len(docs))
+	opts := cfg.opts
+	opts.Name = group.name
+	opts.Names = make([]string, len(docs))
+	for i, doc := range docs {
+		fmt.Println("Merge", i+1, ":", doc.name)
+		fileNamesToMerge[i] = doc.fileName
+		opts.Names[i] = doc.name
+	}
+
+	err = mergeDocFiles(env, fileNamesToMerge, outFileName, opts)
+	if err != nil {
+		return err
+	}
+
+	return uploadProcessed(c, outFileName, group.name, group.allNodes()...)
+}
+
+// isImport returns whether node stands for a PDF imported from the local import directory, see importPrefix.
+func isImport(node *model.Node) bool {
+	return !node.IsDirectory() && strings.HasPrefix(strings.ToLower(node.Name()), importPrefix)
+}
+
+// importFileName returns the local PDF the document called name stands for, see importPrefix. The PDF must be in
+// importDir or one of its subdirectories.
+func importFileName(importDir, name string) (string, error) {
+	rel := strings.TrimSpace(name[len(importPrefix):])
+	if importDir == "" {
+		return "", &document.DocumentError{Op: "import " + rel, Err: errors.New("imports are disabled, start rm-pdf-tools with -import-dir")}
+	}
+	dir := filepath.Clean(importDir)
+	fileName := filepath.Join(dir, rel)
+	if rel == "" || !strings.HasPrefix(fileName, dir+string(filepath.Separator)) {
+		return "", &document.DocumentError{Op: "import " + rel, Err: errors.New("not a file in the import directory")}
+	}
+	if _, err := os.Stat(fileName); err != nil {
+		return "", &document.DocumentError{Op: "import " + rel, Err: err}
+	}
+	if !document.IsPdfFile(fileName) {
+		return "", &document.DocumentError{Op: "import " + rel, Err: errors.New("not a PDF")}
+	}
+	return fileName, nil
+}
+
+// localFileName returns name with its path separators replaced, for naming local files after documents or folders of
+// the cloud, whose names may contain e.g. "/".
+func localFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == filepath.Separator {
+			return '_'
+		}
+		return r
+	}, name)
 }
 
 // processDoc extracts actions, runs them, and uploads the new document for the given node.
-func processDoc(c *cloud.Cloud, node *model.Node) {
+func processDoc(env *document.Env, c cloud.Backend, node *model.Node) error {
 	fmt.Println("Processing file:", node.Name())
 	docName := node.Name()
-	fileNameOriginal := docName + "_original.zip"
-	docNameProcessed := docName + "_processed"
-	fileNameProcessed := docNameProcessed + ".zip"
+	fileNameOriginal := localFileName(docName) + "_original.zip"
+	fileNameProcessed := localFileName(docName) + "_processed.zip"
+	defer removeFiles(fileNameOriginal, fileNameProcessed)
+
+	acts, err := actions.FromString(strings.TrimSuffix(node.Parent.Name(), applySuffix))
+	if err != nil {
+		return err
+	}
 
-	acts := actions.FromString(node.Parent.Name())
+	err = c.Download(node, fileNameOriginal)
+	if err != nil {
+		return err
+	}
 
-	err := c.Download(node, fileNameOriginal)
+	err = editDoc(env, fileNameOriginal, fileNameProcessed, acts)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	actions.RunFile(node.Id(), fileNameOriginal, fileNameProcessed, acts)
+	return uploadProcessed(c, fileNameProcessed, docName, node)
+}
 
-	_, err = c.Upload(fileNameProcessed, remoteProcessedDir)
+// previewDoc uploads a preview PDF of what processing the given node would do to remoteProcessedDir,
+// leaving the node where it is. Nothing is done if the preview already exists.
+func previewDoc(env *document.Env, c cloud.Backend, node *model.Node) error {
+	docName := node.Name()
+	actionsStr := strings.TrimSuffix(node.Parent.Name(), previewSuffix)
+	previewName := fmt.Sprintf("%s (preview %s)", docName, actionsStr)
+	// Looked up by name, as the name may contain a "/"
+	processed, err := c.Children(remoteProcessedDir)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	for _, n := range processed {
+		if n.Name() == previewName {
+			fmt.Println("Preview exists already:", previewName)
+			return nil
+		}
 	}
-	processed, err := c.FindFile(remoteProcessedDir + docNameProcessed)
+
+	fmt.Println("Previewing file:", docName)
+	fileNameOriginal := localFileName(docName) + "_original.zip"
+	fileNamePreview := localFileName(previewName) + ".pdf"
+	defer removeFiles(fileNameOriginal, fileNamePreview)
+
+	acts, err := actions.FromString(actionsStr)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	_, err = c.Move(processed, remoteProcessedDir, docName)
+
+	err = c.Download(node, fileNameOriginal)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	_, err = c.Move(node, remoteOriginalDir, docName)
+	file, err := os.Create(fileNamePreview)
+	if err != nil {
+		return err
+	}
+	report, err := actions.PreviewFile(env, node.Id(), fileNameOriginal, acts, fmt.Sprintf("Preview of %s on %s", actionsStr, docName), file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		panic(err)
+		return err
+	}
+	fmt.Print(report)
+
+	preview, err := c.Upload(fileNamePreview, remoteProcessedDir)
+	if err != nil || preview.Name() == previewName {
+		return err
 	}
+	_, err = c.Rename(preview, previewName)
+	return err
+}
 
-	err = os.Remove(fileNameOriginal)
+// rebaseDoc replaces the PDF of an annotated document by a new version of the PDF, keeping the annotations.
+// The one of the two nodes which is still as uploaded is taken to be the new PDF, see actions.IsPristine.
+func rebaseDoc(env *document.Env, c cloud.Backend, nodes []*model.Node) error {
+	fileNames := []string{nodes[0].Id() + "_rebase.zip", nodes[1].Id() + "_rebase.zip"}
+	defer removeFiles(fileNames...)
+	pristine := make([]bool, len(nodes))
+	for i, node := range nodes {
+		err := c.Download(node, fileNames[i])
+		if err != nil {
+			return err
+		}
+		pristine[i], err = actions.IsPristine(fileNames[i], node.Id())
+		if err != nil {
+			return err
+		}
+	}
+
+	// The new PDF is the document as uploaded, without annotations. If that doesn't tell them apart, e.g. because
+	// neither is annotated, the more recently modified document is taken to be the new PDF.
+	old, newPdf := 0, 1
+	switch {
+	case pristine[0] && !pristine[1]:
+		old, newPdf = 1, 0
+	case !pristine[0] && !pristine[1]:
+		return &document.DocumentError{Op: "rebase", Err: errors.New("both documents are annotated or edited, one of them must be the new PDF as uploaded")}
+	case pristine[0] && pristine[1]:
+		t0, _ := nodes[0].LastModified()
+		t1, _ := nodes[1].LastModified()
+		if t1.Before(t0) {
+			old, newPdf = 1, 0
+		}
+	}
+	node, newPdfNode := nodes[old], nodes[newPdf]
+	fileNameOriginal, fileNameNewPdf := fileNames[old], fileNames[newPdf]
+
+	fmt.Println("Rebasing file:", node.Name(), "onto:", newPdfNode.Name())
+	docName := node.Name()
+	fileNameProcessed := localFileName(docName) + "_processed.zip"
+	defer removeFiles(fileNameProcessed)
+
+	report, err := actions.RebaseFile(env, node.Id(), fileNameOriginal, newPdfNode.Id(), fileNameNewPdf, fileNameProcessed)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	err = os.Remove(fileNameProcessed)
+	fmt.Print(report)
+
+	// Make unmatched annotations visible on the tablet
+	docNameResult := docName
+	if len(report.UnmatchedAnnotated) > 0 {
+		docNameResult = fmt.Sprintf("%s (%d annotated pages unmatched)", docName, len(report.UnmatchedAnnotated))
+	}
+	return uploadProcessed(c, fileNameProcessed, docNameResult, node, newPdfNode)
+}
+
+// undoDoc undoes the edit which produced the given node, using the original document in remoteOriginalDir, and uploads
+// the result to remoteProcessedDir. Annotations made since the edit are kept.
+func undoDoc(env *document.Env, c cloud.Backend, node *model.Node) error {
+	fmt.Println("Undoing file:", node.Name())
+	docName := node.Name()
+	fileNameEdited := localFileName(docName) + "_edited.zip"
+	fileNameOriginal := localFileName(docName) + "_original.zip"
+	fileNameProcessed := localFileName(docName) + "_processed.zip"
+	defer removeFiles(fileNameEdited, fileNameOriginal, fileNameProcessed)
+
+	err := c.Download(node, fileNameEdited)
+	if err != nil {
+		return err
+	}
+	log, ok, err := actions.ReadEditLog(fileNameEdited, node.Id())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &document.DocumentError{Op: "undo", Err: fmt.Errorf("%s is not the result of an edit", docName)}
+	}
+	// The file tree is fetched before processing, so a missing original is missing for good and not retried
+	original, err := c.FindFileById(log.Original)
+	if err != nil {
+		return &document.DocumentError{Op: "undo", Err: fmt.Errorf("original of %s not found: %v", docName, err)}
+	}
+	err = c.Download(original, fileNameOriginal)
+	if err != nil {
+		return err
+	}
+
+	report, err := actions.UndoFile(env, node.Id(), fileNameEdited, original.Id(), fileNameOriginal, fileNameProcessed)
+	if err != nil {
+		return err
+	}
+	fmt.Print(report)
+
+	// Make dropped annotations visible on the tablet
+	docNameResult := docName
+	if len(report.DroppedAnnotated) > 0 {
+		docNameResult = fmt.Sprintf("%s (%d annotated pages dropped)", docName, len(report.DroppedAnnotated))
+	}
+	return uploadProcessed(c, fileNameProcessed, docNameResult, node)
+}
+
+// removeFiles removes the given local files, ignoring those which don't exist.
+func removeFiles(fileNames ...string) {
+	for _, fn := range fileNames {
+		err := os.Remove(fn)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Println("Error:", err)
+		}
+	}
+}
+
+// uploadProcessed uploads the processed document in fileNameProcessed to remoteProcessedDir, moves the documents it was
+// made from to remoteOriginalDir and renames it to docNameResult. Until then, the upload is named like
+// fileNameProcessed, so if a step fails and processing is retried, the upload of the failed attempt is found and
+// replaced instead of uploading the document twice.
+func uploadProcessed(c cloud.Backend, fileNameProcessed, docNameResult string, sources ...*model.Node) error {
+	uploadName := strings.TrimSuffix(filepath.Base(fileNameProcessed), filepath.Ext(fileNameProcessed))
+	stale, err := c.FindFile(remoteProcessedDir + uploadName)
+	switch {
+	case err == nil:
+		err = c.Delete(stale)
+		if err != nil {
+			return err
+		}
+	case !errors.Is(err, cloud.ErrNotFound):
+		return err
+	}
+
+	processed, err := c.Upload(fileNameProcessed, remoteProcessedDir)
+	if err != nil {
+		return err
+	}
+	for _, node := range sources {
+		_, err = c.Move(node, remoteOriginalDir, node.Name())
+		if err != nil {
+			return err
+		}
+	}
+	_, err = c.Rename(processed, docNameResult)
+	return err
+}
+
+// editDoc applies acts to the document .zip fileName and writes the validated result to fileNameProcessed, see
+// rmpdf.Edit.
+func editDoc(env *document.Env, fileName, fileNameProcessed string, acts []rmpdf.Action) error {
+	docs, err := openDocs(fileName)
+	if err != nil {
+		return err
+	}
+	defer closeDocs(docs)
+
+	return writeFile(fileNameProcessed, func(w io.Writer) error {
+		return rmpdf.Edit(context.Background(), docs[0].R, docs[0].Size, w, acts, rmpdfOptions(env)...)
+	})
+}
+
+// mergeDocFiles merges the document .zips or PDFs fileNames in order and writes the validated result to outFileName,
+// see rmpdf.Merge.
+func mergeDocFiles(env *document.Env, fileNames []string, outFileName string, opts rmpdf.MergeOptions) error {
+	docs, err := openDocs(fileNames...)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer closeDocs(docs)
+
+	return writeFile(outFileName, func(w io.Writer) error {
+		return rmpdf.Merge(context.Background(), docs, w, append(rmpdfOptions(env), rmpdf.WithMergeOptions(opts))...)
+	})
 }
 
+// rmpdfOptions configures rmpdf like env.
+func rmpdfOptions(env *document.Env) []rmpdf.Option {
+	return []rmpdf.Option{rmpdf.WithLogger(env.Log), rmpdf.WithTempDir(env.TempDir), rmpdf.WithMemoryLimit(env.MemoryLimit)}
+}
+
+// openDocs opens the given local files as inputs of rmpdf, they must be closed with closeDocs.
+func openDocs(fileNames ...string) ([]rmpdf.Doc, error) {
+	docs := make([]rmpdf.Doc, 0, len(fileNames))
+	for _, fileName := range fileNames {
+		file, err := os.Open(fileName)
+		if err == nil {
+			var info os.FileInfo
+			info, err = file.Stat()
+			if err == nil {
+				docs = append(docs, rmpdf.Doc{R: file, Size: info.Size()})
+				continue
+			}
+			file.Close()
+		}
+		closeDocs(docs)
+		return nil, &document.DocumentError{Op: "open document", Err: err}
+	}
+	return docs, nil
+}
+
+func closeDocs(docs []rmpdf.Doc) {
+	for _, doc := range docs {
+		if c, ok := doc.R.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// writeFile creates the local file fileName and writes it with write, removing it again if that fails.
+func writeFile(fileName string, write func(w io.Writer) error) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeFiles(fileName)
+	}
+	return err
+}
+
+// editFile applies actions to a local document .zip, args are "<file.zip> <actions> -o <out.zip>".
+func editFile(env *document.Env, args []string) error {
+	fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+	fileNameProcessed := fs.String("o", "", "the edited document .zip")
+	posArgs, err := subcommandArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(posArgs) != 2 || *fileNameProcessed == "" {
+		return errors.New("usage: rm-pdf-tools edit <file.zip> <actions> -o <out.zip>")
+	}
+	fileName, actionsStr := posArgs[0], posArgs[1]
+
+	acts, err := rmpdf.ParseActions(actionsStr)
+	if err != nil {
+		return err
+	}
+	return editDoc(env, fileName, *fileNameProcessed, acts)
+}
+
+// mergeFiles merges local document .zips in the given order, args are
+// "[-title-pages] [-contents] <file.zip>... -o <out.zip>".
+func mergeFiles(env *document.Env, args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	outFileName := fs.String("o", "", "the merged document .zip")
+	opts := rmpdf.MergeOptions{}
+	fs.BoolVar(&opts.TitlePages, "title-pages", false, "add a title page before each document")
+	fs.BoolVar(&opts.Contents, "contents", false, "add a table of contents")
+	fileNames, err := subcommandArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(fileNames) == 0 || *outFileName == "" {
+		return errors.New("usage: rm-pdf-tools merge [-title-pages] [-contents] <file.zip>... -o <out.zip>")
+	}
+
+	opts.Name = strings.TrimSuffix(filepath.Base(*outFileName), filepath.Ext(*outFileName))
+	opts.Names = make([]string, len(fileNames))
+	for i, fileName := range fileNames {
+		opts.Names[i] = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	}
+	return mergeDocFiles(env, fileNames, *outFileName, opts)
+}
+
+// inspectFiles prints a description of the given document .zip files.
+func inspectFiles(fileNames []string) error {
+	for _, fileName := range fileNames {
+		uuid, err := fileUuid(fileName)
+		if err != nil {
+			return err
+		}
+		info, err := actions.InspectFile(uuid, fileName)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s:\n", fileName)
+		fmt.Print(info)
+	}
+	return nil
+}
+
+// actionArg matches the arguments which are actions starting with "-", e.g. "-3" or "-3,1a1", rather than flags.
+var actionArg = regexp.MustCompile(`^-[0-9]`)
+
+// subcommandArgs parses the arguments of a subcommand with fs and returns its positional arguments, which may be
+// interleaved with flags, e.g. "in.zip -o out.zip 2a1". Actions starting with "-" are positional arguments as well.
+func subcommandArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	posArgs := make([]string, 0)
+	for {
+		for len(args) > 0 && actionArg.MatchString(args[0]) {
+			posArgs = append(posArgs, args[0])
+			args = args[1:]
+		}
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return posArgs, nil
+		}
+		posArgs = append(posArgs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// validateFiles validates the given document .zip files and returns an error if any of them is invalid.
+func validateFiles(env *document.Env, fileNames []string) error {
+	invalid := 0
+	for _, fileName := range fileNames {
+		err := document.Validate(env, fileName)
+		if err != nil {
+			invalid++
+			fmt.Printf("%s: %v\n", fileName, err)
+			continue
+		}
+		fmt.Printf("%s: valid\n", fileName)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d documents are invalid", invalid, len(fileNames))
+	}
+	return nil
+}
+
+// dryRunFiles prints what applying actionsStr to the given document .zip files would do.
+func dryRunFiles(actionsStr string, fileNames []string) error {
+	acts, err := actions.FromString(actionsStr)
+	if err != nil {
+		return err
+	}
+	for _, fileName := range fileNames {
+		uuid, err := fileUuid(fileName)
+		if err != nil {
+			return err
+		}
+
+		report, err := actions.DryRunFile(uuid, fileName, acts)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Dry run of %s on %s\n", actionsStr, fileName)
+		fmt.Print(report)
+	}
+	return nil
+}
+
+// previewFile writes a preview PDF of applying actionsStr to the document .zip fileName to fileNamePreview.
+func previewFile(env *document.Env, actionsStr, fileName, fileNamePreview string) error {
+	acts, err := actions.FromString(actionsStr)
+	if err != nil {
+		return err
+	}
+	uuid, err := fileUuid(fileName)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(fileNamePreview)
+	if err != nil {
+		return err
+	}
+	report, err := actions.PreviewFile(env, uuid, fileName, acts, fmt.Sprintf("Preview of %s on %s", actionsStr, fileName), file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Print(report)
+	return nil
+}
+
+// fileUuid returns the UUID of the document .zip fileName.
+func fileUuid(fileName string) (string, error) {
+	r, err := zip.OpenReader(fileName)
+	if err != nil {
+		return "", &document.DocumentError{Op: "open " + fileName, Err: err}
+	}
+	defer r.Close()
+
+	uuid, ok := document.ZipUuid(&r.Reader)
+	if !ok {
+		return "", &document.DocumentError{Op: "open " + fileName, Err: errors.New("not a document, missing .content file")}
+	}
+	return uuid, nil
+}
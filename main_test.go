@@ -0,0 +1,692 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/juruen/rmapi/model"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/skius/rm-pdf-tools/actions"
+	"github.com/skius/rm-pdf-tools/cloud"
+	"github.com/skius/rm-pdf-tools/document"
+	"github.com/skius/rm-pdf-tools/document/rm"
+	"github.com/skius/rm-pdf-tools/rmpdf"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testDoc describes a document put into the local cloud by a test.
+type testDoc struct {
+	// pages is the number of pages of a PDF, ignored if templates is set
+	pages int
+	// templates makes the document a notebook with a page for each template
+	templates []string
+	// annotated are the indices of the pages with an .rm file
+	annotated []int
+	rmdoc     bool
+}
+
+// wantDoc describes a document expected in the local cloud after a test.
+type wantDoc struct {
+	fileType string
+	format   document.Format
+	// templates are those of the pages, so there is one per page
+	templates []string
+	// annotated is the number of pages with an .rm file
+	annotated int
+}
+
+// want describes the document as written by writeTestDoc.
+func (d testDoc) want() wantDoc {
+	w := wantDoc{fileType: "pdf", templates: blanks(d.pages), annotated: len(d.annotated)}
+	if d.templates != nil {
+		w.fileType = "notebook"
+		w.templates = d.templates
+	}
+	if d.rmdoc {
+		w.format = document.FormatRmdoc
+	}
+	return w
+}
+
+// step puts documents into the local cloud, moves and removes documents in it and then runs rm-pdf-tools once.
+type step struct {
+	add    map[string]testDoc
+	move   map[string]string
+	remove []string
+}
+
+func blanks(n int) []string {
+	templates := make([]string, n)
+	for i := range templates {
+		templates[i] = "Blank"
+	}
+	return templates
+}
+
+func TestRun(t *testing.T) {
+	notebook := []string{"P Lines small", "P Grid medium", "P Dots S"}
+	tests := []struct {
+		name  string
+		steps []step
+		cfg   mergeConfig
+		// want are all documents of the local cloud afterwards, by path
+		want map[string]wantDoc
+	}{
+		{
+			name:  "edit",
+			steps: []step{{add: map[string]testDoc{"pdf-tools/work/2a1,-3/Notes.zip": {pages: 4, annotated: []int{0, 3}}}}},
+			want: map[string]wantDoc{
+				"pdf-tools/original/Notes.zip":  {fileType: "pdf", templates: blanks(4), annotated: 2},
+				"pdf-tools/processed/Notes.zip": {fileType: "pdf", templates: blanks(5), annotated: 2},
+			},
+		},
+		{
+			name:  "edit applied after preview",
+			steps: []step{{add: map[string]testDoc{"pdf-tools/work/-1!/Notes.zip": {pages: 2}}}},
+			want: map[string]wantDoc{
+				"pdf-tools/original/Notes.zip":  {fileType: "pdf", templates: blanks(2)},
+				"pdf-tools/processed/Notes.zip": {fileType: "pdf", templates: blanks(1)},
+			},
+		},
+		{
+			name:  "edit rmdoc",
+			steps: []step{{add: map[string]testDoc{"pdf-tools/work/1b1/Slides.zip": {pages: 2, annotated: []int{1}, rmdoc: true}}}},
+			want: map[string]wantDoc{
+				"pdf-tools/original/Slides.zip":  {fileType: "pdf", format: document.FormatRmdoc, templates: blanks(2), annotated: 1},
+				"pdf-tools/processed/Slides.zip": {fileType: "pdf", format: document.FormatRmdoc, templates: blanks(3), annotated: 1},
+			},
+		},
+		{
+			name:  "edit notebook",
+			steps: []step{{add: map[string]testDoc{"pdf-tools/work/-2/Journal.zip": {templates: notebook, annotated: []int{0, 1}}}}},
+			want: map[string]wantDoc{
+				"pdf-tools/original/Journal.zip":  {fileType: "notebook", templates: notebook, annotated: 2},
+				"pdf-tools/processed/Journal.zip": {fileType: "notebook", templates: []string{notebook[0], notebook[2]}, annotated: 1},
+			},
+		},
+		{
+			name:  "invalid actions",
+			steps: []step{{add: map[string]testDoc{"pdf-tools/work/1a9/Notes.zip": {pages: 2}}}},
+			want: map[string]wantDoc{
+				"pdf-tools/original/Notes (invalid actions).zip": {fileType: "pdf", templates: blanks(2)},
+			},
+		},
+		{
+			// Errors of the local cloud are permanent, so the document is not retried
+			name: "missing processed folder",
+			steps: []step{{
+				add:    map[string]testDoc{"pdf-tools/work/1a1/Notes.zip": {pages: 2}},
+				remove: []string{"pdf-tools/processed"},
+			}},
+			want: map[string]wantDoc{
+				"pdf-tools/original/Notes (failed).zip": {fileType: "pdf", templates: blanks(2)},
+			},
+		},
+		{
+			name: "preview",
+			steps: []step{
+				{add: map[string]testDoc{"pdf-tools/work/1a1,-2?/Notes.zip": {pages: 3}}},
+				// The preview exists already, so it is not made again
+				{},
+			},
+			want: map[string]wantDoc{
+				"pdf-tools/work/1a1,-2?/Notes.zip": {fileType: "pdf", templates: blanks(3)},
+				// The report and a page of thumbnails
+				"pdf-tools/processed/Notes (preview 1a1,-2).zip": {fileType: "pdf", templates: blanks(2)},
+			},
+		},
+		{
+			name: "undo",
+			steps: []step{
+				{add: map[string]testDoc{"pdf-tools/work/-2,1a3/Notes.zip": {pages: 3, annotated: []int{1}}}},
+				{move: map[string]string{"pdf-tools/processed/Notes.zip": "pdf-tools/work/undo/Notes.zip"}},
+			},
+			want: map[string]wantDoc{
+				"pdf-tools/original/Notes.zip":     {fileType: "pdf", templates: blanks(3), annotated: 1},
+				"pdf-tools/original/Notes (2).zip": {fileType: "pdf", templates: blanks(3)},
+				"pdf-tools/processed/Notes.zip":    {fileType: "pdf", templates: blanks(3), annotated: 1},
+			},
+		},
+		{
+			name: "undo notebook",
+			steps: []step{
+				{add: map[string]testDoc{"pdf-tools/work/-1/Journal.zip": {templates: notebook, annotated: []int{0, 2}}}},
+				{move: map[string]string{"pdf-tools/processed/Journal.zip": "pdf-tools/work/undo/Journal.zip"}},
+			},
+			want: map[string]wantDoc{
+				"pdf-tools/original/Journal.zip":     {fileType: "notebook", templates: notebook, annotated: 2},
+				"pdf-tools/original/Journal (2).zip": {fileType: "notebook", templates: notebook[1:], annotated: 1},
+				"pdf-tools/processed/Journal.zip":    {fileType: "notebook", templates: notebook, annotated: 2},
+			},
+		},
+		{
+			name: "undo without original",
+			steps: []step{
+				{add: map[string]testDoc{"pdf-tools/work/-1/Notes.zip": {pages: 2}}},
+				{
+					move:   map[string]string{"pdf-tools/processed/Notes.zip": "pdf-tools/work/undo/Notes.zip"},
+					remove: []string{"pdf-tools/original/Notes.zip"},
+				},
+			},
+			want: map[string]wantDoc{
+				"pdf-tools/original/Notes (unsupported document).zip": {fileType: "pdf", templates: blanks(1)},
+			},
+		},
+		{
+			name: "merge groups",
+			steps: []step{{add: map[string]testDoc{
+				"pdf-tools/merge! Week/1 Slides.zip":        {pages: 2, annotated: []int{1}},
+				"pdf-tools/merge! Week/2 Journal.zip":       {templates: notebook},
+				"pdf-tools/merge! Week/Day 2/b Journal.zip": {templates: notebook[:1], annotated: []int{0}},
+				"pdf-tools/merge! Week/Day 2/a Journal.zip": {templates: notebook[1:]},
+				"pdf-tools/merge! Week/Day 3/Exercises.zip": {pages: 1, rmdoc: true},
+			}}},
+			want: map[string]wantDoc{
+				"pdf-tools/original/1 Slides.zip":  {fileType: "pdf", templates: blanks(2), annotated: 1},
+				"pdf-tools/original/2 Journal.zip": {fileType: "notebook", templates: notebook},
+				"pdf-tools/original/a Journal.zip": {fileType: "notebook", templates: notebook[1:]},
+				"pdf-tools/original/b Journal.zip": {fileType: "notebook", templates: notebook[:1], annotated: 1},
+				"pdf-tools/original/Exercises.zip": {fileType: "pdf", format: document.FormatRmdoc, templates: blanks(1)},
+				"pdf-tools/processed/Week.zip":     {fileType: "pdf", templates: blanks(5), annotated: 1},
+				"pdf-tools/processed/Day 2.zip":    {fileType: "notebook", templates: []string{notebook[1], notebook[2], notebook[0]}, annotated: 1},
+				"pdf-tools/processed/Day 3.zip":    {fileType: "pdf", format: document.FormatRmdoc, templates: blanks(1)},
+			},
+		},
+		{
+			name: "merge order matching no document",
+			steps: []step{{add: map[string]testDoc{
+				"pdf-tools/merge! Week (order: Slids; Notes)/Slides.zip": {pages: 1},
+				"pdf-tools/merge! Week (order: Slids; Notes)/Notes.zip":  {pages: 2},
+			}}},
+			want: map[string]wantDoc{
+				"pdf-tools/original/Slides (invalid actions).zip": {fileType: "pdf", templates: blanks(1)},
+				"pdf-tools/original/Notes (invalid actions).zip":  {fileType: "pdf", templates: blanks(2)},
+			},
+		},
+		{
+			name: "merge title pages",
+			steps: []step{{add: map[string]testDoc{
+				"pdf-tools/merge!/a.zip": {pages: 1},
+				"pdf-tools/merge!/b.zip": {pages: 2, annotated: []int{0}},
+			}}},
+			cfg: mergeConfig{opts: rmpdf.MergeOptions{TitlePages: true}},
+			want: map[string]wantDoc{
+				"pdf-tools/original/a.zip":       {fileType: "pdf", templates: blanks(1)},
+				"pdf-tools/original/b.zip":       {fileType: "pdf", templates: blanks(2), annotated: 1},
+				"pdf-tools/processed/merged.zip": {fileType: "pdf", templates: blanks(5), annotated: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, dir := range []string{"work", "original", "processed"} {
+				mkdir(t, filepath.Join(root, "pdf-tools", dir))
+			}
+			chdir(t, t.TempDir())
+
+			for _, s := range tt.steps {
+				for path, doc := range s.add {
+					writeTestDoc(t, filepath.Join(root, path), doc)
+				}
+				for src, dst := range s.move {
+					mkdir(t, filepath.Dir(filepath.Join(root, dst)))
+					if err := os.Rename(filepath.Join(root, src), filepath.Join(root, dst)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				for _, path := range s.remove {
+					if err := os.Remove(filepath.Join(root, path)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := run(&document.Env{}, root, tt.cfg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := zipFiles(t, root)
+			want := make([]string, 0, len(tt.want))
+			for path := range tt.want {
+				want = append(want, path)
+			}
+			sort.Strings(want)
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Fatalf("documents are\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+			for path, w := range tt.want {
+				checkDoc(t, filepath.Join(root, path), w)
+			}
+			// Nothing is left behind in the working directory
+			if left := zipFiles(t, "."); len(left) > 0 {
+				t.Errorf("files left in the working directory: %v", left)
+			}
+		})
+	}
+}
+
+// flakyBackend fails the first move with a temporary error, like the reMarkable cloud does on network problems.
+type flakyBackend struct {
+	*cloud.Local
+	failed bool
+}
+
+func (b *flakyBackend) Move(node *model.Node, dstPath, dstName string) (*model.Node, error) {
+	if !b.failed {
+		b.failed = true
+		return nil, &cloud.CloudError{Op: "move " + node.Name(), Err: errors.New("connection reset"), Temporary: true}
+	}
+	return b.Local.Move(node, dstPath, dstName)
+}
+
+func TestRetryAfterUpload(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"work", "original", "processed"} {
+		mkdir(t, filepath.Join(root, "pdf-tools", dir))
+	}
+	writeTestDoc(t, filepath.Join(root, "pdf-tools/work/1a1/Notes.zip"), testDoc{pages: 2})
+	chdir(t, t.TempDir())
+
+	// The document is uploaded, but moving the original fails, so it is left in place and processed again
+	want := [][]string{
+		{"pdf-tools/processed/Notes_processed.zip", "pdf-tools/work/1a1/Notes.zip"},
+		{"pdf-tools/original/Notes.zip", "pdf-tools/processed/Notes.zip"},
+	}
+	for attempt := range want {
+		c, err := cloud.NewLocal(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node, err := c.FindFile(remoteWatchDir + "1a1/Notes")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &flakyBackend{Local: c, failed: attempt > 0}
+		err = processDoc(&document.Env{}, b, node)
+		if attempt == 0 && !cloud.IsTemporary(err) || attempt > 0 && err != nil {
+			t.Fatalf("attempt %d: got error %v", attempt+1, err)
+		}
+		handleError(b, []*model.Node{node}, err)
+
+		if got := zipFiles(t, root); strings.Join(got, "\n") != strings.Join(want[attempt], "\n") {
+			t.Errorf("after attempt %d documents are %v, want %v", attempt+1, got, want[attempt])
+		}
+	}
+	checkDoc(t, filepath.Join(root, "pdf-tools/processed/Notes.zip"), wantDoc{fileType: "pdf", templates: blanks(3)})
+}
+
+// slashBackend records the names documents are renamed to. The local cloud can't store names with a "/", like the
+// reMarkable cloud can, so it replaces them.
+type slashBackend struct {
+	*cloud.Local
+	renamed []string
+}
+
+func (b *slashBackend) Rename(node *model.Node, name string) (*model.Node, error) {
+	b.renamed = append(b.renamed, name)
+	return b.Local.Rename(node, strings.ReplaceAll(name, "/", " of "))
+}
+
+func TestMergeGroupNameWithSlash(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"work", "original", "processed"} {
+		mkdir(t, filepath.Join(root, "pdf-tools", dir))
+	}
+	writeTestDoc(t, filepath.Join(root, "pdf-tools/merge!/a.zip"), testDoc{pages: 1})
+	writeTestDoc(t, filepath.Join(root, "pdf-tools/merge!/b.zip"), testDoc{pages: 2})
+	chdir(t, t.TempDir())
+
+	c, err := cloud.NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := c.Children(remoteWorkDir + "merge!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &slashBackend{Local: c}
+	group := mergeGroup{name: "Week 1/2", order: mergeOrder{by: orderByName}, nodes: nodes}
+	if err := mergeDocs(&document.Env{}, b, group, mergeConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(b.renamed) != "[Week 1/2]" {
+		t.Errorf("merged document renamed to %q, want %q", b.renamed, group.name)
+	}
+	want := []string{"pdf-tools/original/a.zip", "pdf-tools/original/b.zip", "pdf-tools/processed/Week 1 of 2.zip"}
+	if got := zipFiles(t, root); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("documents are %v, want %v", got, want)
+	}
+	if left := zipFiles(t, "."); len(left) > 0 {
+		t.Errorf("files left in the working directory: %v", left)
+	}
+}
+
+func TestDryRunAndPreview(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     testDoc
+		actions string
+		// pages is the page count of the preview, 0 if the actions are invalid
+		pages int
+	}{
+		{"pdf", testDoc{pages: 3, annotated: []int{1}}, "1a1,-2", 2},
+		{"notebook", testDoc{templates: []string{"P Lines small", "P Dots S"}}, "2b2", 2},
+		{"rmdoc", testDoc{pages: 2, rmdoc: true}, "-1", 2},
+		{"page out of range", testDoc{pages: 2}, "1a3", 0},
+		{"invalid syntax", testDoc{pages: 2}, "1x1", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fileName := filepath.Join(dir, "Notes.zip")
+			writeTestDoc(t, fileName, tt.doc)
+			fileNamePreview := filepath.Join(dir, "preview.pdf")
+
+			err := dryRunFiles(tt.actions, []string{fileName})
+			var actionErr *actions.ActionError
+			if tt.pages == 0 {
+				if !errors.As(err, &actionErr) {
+					t.Errorf("dry run: got error %v, want an *actions.ActionError", err)
+				}
+				if err = previewFile(&document.Env{}, tt.actions, fileName, fileNamePreview); !errors.As(err, &actionErr) {
+					t.Errorf("preview: got error %v, want an *actions.ActionError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dry run: %v", err)
+			}
+			if err = previewFile(&document.Env{}, tt.actions, fileName, fileNamePreview); err != nil {
+				t.Fatalf("preview: %v", err)
+			}
+			pages, err := api.PageCountFile(fileNamePreview)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pages != tt.pages {
+				t.Errorf("preview has %d pages, want %d", pages, tt.pages)
+			}
+			// Neither changes the document
+			checkDoc(t, fileName, tt.doc.want())
+		})
+	}
+}
+
+func TestSubcommandArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+		out  string
+		err  bool
+	}{
+		{[]string{"in.zip", "2a1", "-o", "out.zip"}, []string{"in.zip", "2a1"}, "out.zip", false},
+		{[]string{"in.zip", "-o", "out.zip", "2a1"}, []string{"in.zip", "2a1"}, "out.zip", false},
+		{[]string{"in.zip", "-3", "-o", "out.zip"}, []string{"in.zip", "-3"}, "out.zip", false},
+		{[]string{"-o", "out.zip", "in.zip", "-3,1a1"}, []string{"in.zip", "-3,1a1"}, "out.zip", false},
+		{[]string{"in.zip", "-o", "out.zip", "--", "-3"}, []string{"in.zip", "-3"}, "out.zip", false},
+		{[]string{"in.zip", "-x", "-o", "out.zip"}, nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			out := fs.String("o", "", "")
+			got, err := subcommandArgs(fs, tt.args)
+			if tt.err {
+				if err == nil {
+					t.Errorf("got arguments %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") || *out != tt.out {
+				t.Errorf("got arguments %q and -o %q, want %q and -o %q", got, *out, tt.want, tt.out)
+			}
+		})
+	}
+}
+
+func TestImportFileName(t *testing.T) {
+	dir := t.TempDir()
+	mkdir(t, filepath.Join(dir, "sub"))
+	for _, name := range []string{"a.pdf", "sub/b.pdf"} {
+		if err := os.WriteFile(filepath.Join(dir, name), testPdf(t, 1), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// want is the imported file relative to dir, "" if name must be rejected
+		want string
+	}{
+		{"import: a.pdf", "a.pdf"},
+		{"import:a.pdf ", "a.pdf"},
+		{"import: sub/b.pdf", "sub/b.pdf"},
+		{"import: sub/../a.pdf", "a.pdf"},
+		{"import: ", ""},
+		{"import: /", ""},
+		{"import: sub/", ""},
+		{"import: ..", ""},
+		{"import: ../a.pdf", ""},
+		{"import: sub/../../a.pdf", ""},
+		{"import: /a.pdf", "a.pdf"},
+		{"import: missing.pdf", ""},
+		{"import: notes.txt", ""},
+	}
+	for _, tt := range tests {
+		got, err := importFileName(dir, tt.name)
+		if tt.want == "" {
+			var documentErr *document.DocumentError
+			if !errors.As(err, &documentErr) {
+				t.Errorf("importFileName(%q) = %q, %v, want a DocumentError", tt.name, got, err)
+			}
+			continue
+		}
+		if want := filepath.Join(dir, tt.want); got != want || err != nil {
+			t.Errorf("importFileName(%q) = %q, %v, want %q", tt.name, got, err, want)
+		}
+	}
+
+	// Imports are disabled without an import directory
+	if got, err := importFileName("", "import: a.pdf"); err == nil {
+		t.Errorf("got %q without an import directory, want an error", got)
+	}
+}
+
+func TestLocalFileName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"Week 1", "Week 1"},
+		{"Week 1/2", "Week 1_2"},
+		{"../Notes", ".._Notes"},
+		{"/", "_"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := localFileName(tt.name); got != tt.want {
+			t.Errorf("localFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		// Names of local files are in the working directory
+		if fileName := localFileName(tt.name) + "_merged.zip"; filepath.Base(fileName) != fileName {
+			t.Errorf("local file %q of %q is not in the working directory", fileName, tt.name)
+		}
+	}
+}
+
+func mkdir(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chdir changes the working directory to dir until the end of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// zipFiles returns the .zip files in root and its subdirectories, relative to root and sorted.
+func zipFiles(t *testing.T, root string) []string {
+	t.Helper()
+	files := make([]string, 0)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".zip" {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// testPdf returns a PDF with pageCount pages.
+func testPdf(t *testing.T, pageCount int) []byte {
+	t.Helper()
+	pages := make([]io.ReadSeeker, pageCount)
+	for i := range pages {
+		buf := bytes.Buffer{}
+		if err := document.WriteTextPdf(&buf, []string{fmt.Sprintf("Page %d", i+1)}); err != nil {
+			t.Fatal(err)
+		}
+		pages[i] = bytes.NewReader(buf.Bytes())
+	}
+	buf := bytes.Buffer{}
+	if err := api.Merge(pages, &buf, pdfcpu.NewDefaultConfiguration()); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testRmPage returns a page with a single line.
+func testRmPage() *rm.Page {
+	return &rm.Page{Version: rm.V5, Layers: []*rm.Layer{{Name: "Layer 1", Visible: true, Lines: []*rm.Line{{
+		Pen:            rm.Fineliner2,
+		Color:          rm.Black,
+		ThicknessScale: 2,
+		Points:         []rm.Point{{X: 100, Y: 100, Width: 2, Pressure: 0.5}, {X: 200, Y: 300, Width: 2, Pressure: 0.5}},
+	}}}}}
+}
+
+// writeTestDoc writes the document doc as .zip to fileName.
+func writeTestDoc(t *testing.T, fileName string, doc testDoc) {
+	t.Helper()
+	var pdfDoc document.PdfDocument
+	if doc.templates != nil {
+		pdfDoc.Uuid = uuid.New().String()
+		pdfDoc.PageFiles = make(map[document.PageFile][]byte)
+		pdfDoc.Content.FileType = "notebook"
+		pdfDoc.Content.Orientation = "portrait"
+		pdfDoc.Content.PageCount = len(doc.templates)
+		pdfDoc.Content.Pages = make([]string, len(doc.templates))
+		for i := range pdfDoc.Content.Pages {
+			pdfDoc.Content.Pages[i] = uuid.New().String()
+		}
+		pdfDoc.Pagedata = doc.templates
+	} else {
+		var err error
+		pdfDoc, err = document.FromPdfReader(nil, bytes.NewReader(testPdf(t, doc.pages)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pdfDoc.Close()
+	}
+
+	for _, i := range doc.annotated {
+		if err := pdfDoc.SetRmPage(document.PageKey{Uuid: pdfDoc.Content.Pages[i]}, testRmPage()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if doc.rmdoc {
+		pdfDoc.Format = document.FormatRmdoc
+		name := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+		pdfDoc.Metadata = []byte(fmt.Sprintf(`{"visibleName":%q,"type":"DocumentType","parent":""}`, name))
+	}
+
+	mkdir(t, filepath.Dir(fileName))
+	if err := pdfDoc.WriteToFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkDoc checks that the document .zip fileName is valid and as described by want.
+func checkDoc(t *testing.T, fileName string, want wantDoc) {
+	t.Helper()
+	if err := document.Validate(nil, fileName); err != nil {
+		t.Errorf("%s: %v", fileName, err)
+		return
+	}
+	r, err := zip.OpenReader(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	id, _ := document.ZipUuid(&r.Reader)
+	pdfDoc, err := document.FromZipReader(nil, &r.Reader, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdfDoc.Close()
+
+	if pdfDoc.Content.FileType != want.fileType {
+		t.Errorf("%s: file type %q, want %q", fileName, pdfDoc.Content.FileType, want.fileType)
+	}
+	if pdfDoc.Format != want.format {
+		t.Errorf("%s: format %d, want %d", fileName, pdfDoc.Format, want.format)
+	}
+	if want.format == document.FormatRmdoc {
+		var metadata struct {
+			VisibleName string `json:"visibleName"`
+		}
+		name := strings.TrimSuffix(filepath.Base(fileName), ".zip")
+		if err := json.Unmarshal(pdfDoc.Metadata, &metadata); err != nil || metadata.VisibleName != name {
+			t.Errorf("%s: named %q, want %q", fileName, metadata.VisibleName, name)
+		}
+	}
+	templates := pdfDoc.Pagedata
+	if len(templates) > 0 && templates[len(templates)-1] == "" {
+		templates = templates[:len(templates)-1]
+	}
+	if fmt.Sprint(templates) != fmt.Sprint(want.templates) || len(pdfDoc.Content.Pages) != len(want.templates) {
+		t.Errorf("%s: %d pages with templates %q, want %q", fileName, len(pdfDoc.Content.Pages), templates, want.templates)
+	}
+	annotated := 0
+	for pf := range pdfDoc.PageFiles {
+		if pf == document.RmFile(pf.Key) {
+			annotated++
+		}
+	}
+	if annotated != want.annotated {
+		t.Errorf("%s: %d annotated pages, want %d", fileName, annotated, want.annotated)
+	}
+}
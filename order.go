@@ -0,0 +1,242 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"github.com/juruen/rmapi/model"
+	"github.com/skius/rm-pdf-tools/actions"
+	"github.com/skius/rm-pdf-tools/document"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ways to order the documents of a merge, chosen by a suffix of the merge folder name, e.g. "merge! Notes (modified)".
+const (
+	// orderByName orders by name, numbers in names are compared by their value, e.g. "2 Notes" before "10 Notes".
+	orderByName = "name"
+	// orderByModified orders by last-modified time, oldest first.
+	orderByModified = "modified"
+	// orderByCreated orders by creation time, oldest first.
+	orderByCreated = "created"
+	// orderExplicit orders by a list of names, e.g. "(order: Slides; Notes)" or a document called "order: Slides; Notes".
+	orderExplicit = "order:"
+)
+
+// mergeOrder is the order in which the documents of a merge are merged.
+type mergeOrder struct {
+	by string
+	// names are the names of the documents for orderExplicit.
+	names []string
+}
+
+// parseMergeName splits the name of a merge folder into the name of the merged document and the order given by its
+// suffix, if any. ok is false if the name has no order suffix.
+func parseMergeName(s string) (name string, order mergeOrder, ok bool) {
+	s = strings.TrimSpace(s)
+	i := strings.LastIndex(s, "(")
+	if i < 0 || !strings.HasSuffix(s, ")") {
+		return s, mergeOrder{by: orderByName}, false
+	}
+	order, ok = parseOrder(s[i+1 : len(s)-1])
+	if !ok {
+		return s, mergeOrder{by: orderByName}, false
+	}
+	return strings.TrimSpace(s[:i]), order, true
+}
+
+// parseOrder parses an order, i.e. "name", "modified", "created" or "order: <name>; <name>...".
+func parseOrder(s string) (mergeOrder, bool) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case orderByName, orderByModified, orderByCreated:
+		return mergeOrder{by: strings.ToLower(s)}, true
+	}
+	if !strings.HasPrefix(strings.ToLower(s), orderExplicit) {
+		return mergeOrder{}, false
+	}
+	order := mergeOrder{by: orderExplicit}
+	for _, name := range strings.Split(s[len(orderExplicit):], ";") {
+		if name = strings.TrimSpace(name); name != "" {
+			order.names = append(order.names, name)
+		}
+	}
+	return order, len(order.names) > 0
+}
+
+// isManifest returns whether node is a document listing the merge order in its name, e.g. "order: Slides; Notes".
+func isManifest(node *model.Node) bool {
+	return !node.IsDirectory() && strings.HasPrefix(strings.ToLower(node.Name()), orderExplicit)
+}
+
+// mergeDoc is a document of a merge and the file it was downloaded or imported from.
+type mergeDoc struct {
+	node *model.Node
+	// name is the name the document is ordered by, the name of the imported file for imports.
+	name     string
+	fileName string
+	imported bool
+}
+
+// sortMergeDocs sorts docs by order. Documents not listed by an explicit order come last, ordered by name.
+func sortMergeDocs(order mergeOrder, docs []mergeDoc) error {
+	sort.SliceStable(docs, func(i, j int) bool {
+		return naturalLess(docs[i].name, docs[j].name)
+	})
+
+	switch order.by {
+	case orderByModified:
+		sortByTime(docs, func(doc mergeDoc) time.Time {
+			t, _ := doc.node.LastModified()
+			return t
+		})
+	case orderByCreated:
+		sortByTime(docs, func(doc mergeDoc) time.Time {
+			if t, ok := createdTime(doc.fileName); ok {
+				return t
+			}
+			// Documents without a creation time fall back to their last-modified time
+			t, _ := doc.node.LastModified()
+			return t
+		})
+	case orderExplicit:
+		rank := make([]int, len(docs))
+		for i := range rank {
+			rank[i] = len(order.names)
+		}
+		for k, name := range order.names {
+			i, err := findMergeDoc(docs, name)
+			if err != nil {
+				return err
+			}
+			rank[i] = k
+		}
+		ranked := make([]struct {
+			doc  mergeDoc
+			rank int
+		}, len(docs))
+		for i := range docs {
+			ranked[i].doc, ranked[i].rank = docs[i], rank[i]
+		}
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return ranked[i].rank < ranked[j].rank
+		})
+		for i := range ranked {
+			docs[i] = ranked[i].doc
+		}
+	}
+	return nil
+}
+
+// sortByTime sorts docs by the time returned by at, oldest first.
+func sortByTime(docs []mergeDoc, at func(doc mergeDoc) time.Time) {
+	times := make(map[string]time.Time, len(docs))
+	for _, doc := range docs {
+		times[doc.node.Id()] = at(doc)
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return times[docs[i].node.Id()].Before(times[docs[j].node.Id()])
+	})
+}
+
+// findMergeDoc returns the index of the document called name, ignoring case, or else of the only document whose name
+// starts with name. A name matching no or several documents is an *actions.ActionError, the user needs to fix the order.
+func findMergeDoc(docs []mergeDoc, name string) (int, error) {
+	lower := strings.ToLower(name)
+	found := -1
+	for i, doc := range docs {
+		docName := strings.ToLower(doc.name)
+		if docName == lower {
+			return i, nil
+		}
+		if strings.HasPrefix(docName, lower) {
+			if found >= 0 {
+				return -1, &actions.ActionError{Action: orderExplicit + " " + name, Msg: "matches several documents"}
+			}
+			found = i
+		}
+	}
+	if found < 0 {
+		return -1, &actions.ActionError{Action: orderExplicit + " " + name, Msg: "matches no document"}
+	}
+	return found, nil
+}
+
+// createdTime returns the creation time in the .metadata of the document .zip fileName, if it has one.
+func createdTime(fileName string) (time.Time, bool) {
+	r, err := zip.OpenReader(fileName)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer r.Close()
+
+	uuid, ok := document.ZipUuid(&r.Reader)
+	if !ok {
+		return time.Time{}, false
+	}
+	for _, f := range r.File {
+		if f.Name != uuid+".metadata" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return time.Time{}, false
+		}
+		metadata := struct {
+			CreatedTime string `json:"createdTime"`
+		}{}
+		err = json.NewDecoder(rc).Decode(&metadata)
+		rc.Close()
+		if err != nil {
+			return time.Time{}, false
+		}
+		// The creation time is in milliseconds since the epoch
+		ms, err := strconv.ParseInt(metadata.CreatedTime, 10, 64)
+		if err != nil || ms == 0 {
+			return time.Time{}, false
+		}
+		return time.Unix(0, ms*int64(time.Millisecond)), true
+	}
+	return time.Time{}, false
+}
+
+// naturalLess compares a and b ignoring case, with runs of digits compared by their value, so "2 Notes" comes before
+// "10 Notes".
+func naturalLess(a, b string) bool {
+	la, lb := strings.ToLower(a), strings.ToLower(b)
+	i, j := 0, 0
+	for i < len(la) && j < len(lb) {
+		if isDigit(la[i]) && isDigit(lb[j]) {
+			si, sj := i, j
+			for i < len(la) && isDigit(la[i]) {
+				i++
+			}
+			for j < len(lb) && isDigit(lb[j]) {
+				j++
+			}
+			na := strings.TrimLeft(la[si:i], "0")
+			nb := strings.TrimLeft(lb[sj:j], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		if la[i] != lb[j] {
+			return la[i] < lb[j]
+		}
+		i++
+		j++
+	}
+	if len(la)-i != len(lb)-j {
+		return len(la)-i < len(lb)-j
+	}
+	return a < b
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/juruen/rmapi/model"
+	"github.com/skius/rm-pdf-tools/actions"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"2 Notes", "10 Notes", true},
+		{"10 Notes", "2 Notes", false},
+		{"Notes 2", "Notes 10", true},
+		{"Notes 02", "Notes 10", true},
+		// Names equal but for leading zeros are ordered by their bytes, so the order is total
+		{"Notes 010", "Notes 10", true},
+		{"Notes", "Notes 1", true},
+		{"a", "B", true},
+		{"B", "a", false},
+		{"Notes", "notes", true},
+		{"notes", "Notes", false},
+		{"Day 1 part 2", "Day 1 part 10", true},
+		{"Day", "Day", false},
+	}
+	for _, tt := range tests {
+		if got := naturalLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalLess(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParseMergeName(t *testing.T) {
+	tests := []struct {
+		s     string
+		name  string
+		order mergeOrder
+		ok    bool
+	}{
+		{"Week", "Week", mergeOrder{by: orderByName}, false},
+		{"Week (modified)", "Week", mergeOrder{by: orderByModified}, true},
+		{" Week (Created) ", "Week", mergeOrder{by: orderByCreated}, true},
+		{"Week (name)", "Week", mergeOrder{by: orderByName}, true},
+		{"Week (order: Slides; Notes)", "Week", mergeOrder{by: orderExplicit, names: []string{"Slides", "Notes"}}, true},
+		{"Week (draft)", "Week (draft)", mergeOrder{by: orderByName}, false},
+		{"Week (order:)", "Week (order:)", mergeOrder{by: orderByName}, false},
+		{"Week (modified", "Week (modified", mergeOrder{by: orderByName}, false},
+		{"(modified)", "", mergeOrder{by: orderByModified}, true},
+	}
+	for _, tt := range tests {
+		name, order, ok := parseMergeName(tt.s)
+		if name != tt.name || fmt.Sprint(order) != fmt.Sprint(tt.order) || ok != tt.ok {
+			t.Errorf("parseMergeName(%q) = %q, %v, %v, want %q, %v, %v", tt.s, name, order, ok, tt.name, tt.order, tt.ok)
+		}
+	}
+}
+
+func TestParseOrder(t *testing.T) {
+	tests := []struct {
+		s     string
+		order mergeOrder
+		ok    bool
+	}{
+		{"name", mergeOrder{by: orderByName}, true},
+		{"MODIFIED", mergeOrder{by: orderByModified}, true},
+		{" created ", mergeOrder{by: orderByCreated}, true},
+		{"order: Slides", mergeOrder{by: orderExplicit, names: []string{"Slides"}}, true},
+		{"Order: Slides ; ; Notes;", mergeOrder{by: orderExplicit, names: []string{"Slides", "Notes"}}, true},
+		{"order: ;", mergeOrder{by: orderExplicit}, false},
+		{"size", mergeOrder{}, false},
+		{"", mergeOrder{}, false},
+	}
+	for _, tt := range tests {
+		order, ok := parseOrder(tt.s)
+		if fmt.Sprint(order) != fmt.Sprint(tt.order) || ok != tt.ok {
+			t.Errorf("parseOrder(%q) = %v, %v, want %v, %v", tt.s, order, ok, tt.order, tt.ok)
+		}
+	}
+}
+
+// testMergeDocs returns a mergeDoc for each name, the nth modified n hours after the first.
+func testMergeDocs(names ...string) []mergeDoc {
+	docs := make([]mergeDoc, len(names))
+	for i, name := range names {
+		node := &model.Node{Document: &model.Document{
+			ID:             fmt.Sprint("id-", i),
+			VissibleName:   name,
+			ModifiedClient: fmt.Sprintf("2026-10-19T%02d:00:00Z", i),
+		}}
+		// fileName does not exist, so the creation time falls back to the modification time
+		docs[i] = mergeDoc{node: node, name: name, fileName: name + ".zip"}
+	}
+	return docs
+}
+
+func docNames(docs []mergeDoc) []string {
+	names := make([]string, len(docs))
+	for i, doc := range docs {
+		names[i] = doc.name
+	}
+	return names
+}
+
+func TestSortMergeDocs(t *testing.T) {
+	tests := []struct {
+		name  string
+		order mergeOrder
+		docs  []string
+		want  []string
+	}{
+		{"name", mergeOrder{by: orderByName}, []string{"10 Notes", "Slides", "2 Notes"}, []string{"2 Notes", "10 Notes", "Slides"}},
+		{"modified", mergeOrder{by: orderByModified}, []string{"b", "c", "a"}, []string{"b", "c", "a"}},
+		{"created", mergeOrder{by: orderByCreated}, []string{"b", "c", "a"}, []string{"b", "c", "a"}},
+		{
+			name:  "explicit",
+			order: mergeOrder{by: orderExplicit, names: []string{"slides", "Exer"}},
+			docs:  []string{"Notes 10", "Exercises", "Notes 2", "Slides"},
+			// Documents not in the order come last, by name
+			want: []string{"Slides", "Exercises", "Notes 2", "Notes 10"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs := testMergeDocs(tt.docs...)
+			if err := sortMergeDocs(tt.order, docs); err != nil {
+				t.Fatal(err)
+			}
+			if got := docNames(docs); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("sorted %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortMergeDocsUnknownName(t *testing.T) {
+	docs := testMergeDocs("Slides", "Notes")
+	err := sortMergeDocs(mergeOrder{by: orderExplicit, names: []string{"Slids"}}, docs)
+	var actionErr *actions.ActionError
+	if !errors.As(err, &actionErr) {
+		t.Errorf("got error %v, want an ActionError", err)
+	}
+}
+
+func TestFindMergeDoc(t *testing.T) {
+	docs := testMergeDocs("Notes", "Notes 2", "Slides", "Slides old", "Exercises")
+	tests := []struct {
+		name string
+		want int
+		// err is whether name is unknown or ambiguous
+		err bool
+	}{
+		{"Notes", 0, false},
+		{"notes 2", 1, false},
+		// An exact name wins over the other documents it is a prefix of
+		{"slides", 2, false},
+		{"Exer", 4, false},
+		{"Not", -1, true},
+		{"Slides o", 3, false},
+		{"Homework", -1, true},
+		{"Notes 23", -1, true},
+	}
+	for _, tt := range tests {
+		i, err := findMergeDoc(docs, tt.name)
+		var actionErr *actions.ActionError
+		if i != tt.want || (err != nil) != tt.err || err != nil && !errors.As(err, &actionErr) {
+			t.Errorf("findMergeDoc(%q) = %d, %v, want %d and error %v", tt.name, i, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Package rmpdf edits and merges reMarkable documents, given as the .zip bundles the reMarkable cloud stores them as.
+// It is the library interface of rm-pdf-tools: documents are read from and written to streams, and nothing but
+// temporary files for large PDFs touches the file system.
+package rmpdf
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"github.com/skius/rm-pdf-tools/actions"
+	"github.com/skius/rm-pdf-tools/document"
+	"io"
+	"log"
+)
+
+// Action is an edit of the pages of a document, see ParseActions.
+type Action = actions.Action
+
+// MergeOptions adds generated pages to merged documents and names the merged documents, see WithMergeOptions.
+type MergeOptions = actions.MergeOptions
+
+// Doc is a document .zip of Size bytes.
+type Doc struct {
+	R    io.ReaderAt
+	Size int64
+}
+
+// Option configures Edit and Merge.
+type Option func(c *config)
+
+type config struct {
+	env   document.Env
+	merge MergeOptions
+}
+
+// WithLogger logs progress messages to l, they are discarded by default.
+func WithLogger(l *log.Logger) Option {
+	return func(c *config) {
+		c.env.Log = l
+	}
+}
+
+// WithTempDir creates temporary files in dir instead of the default directory for temporary files.
+func WithTempDir(dir string) Option {
+	return func(c *config) {
+		c.env.TempDir = dir
+	}
+}
+
+// WithMemoryLimit keeps copies of PDFs of up to n bytes in memory, larger copies are buffered in temporary files.
+// The default is document.DefaultMemoryLimit. PDFs are still parsed into memory while they are processed.
+func WithMemoryLimit(n int64) Option {
+	return func(c *config) {
+		c.env.MemoryLimit = n
+	}
+}
+
+// WithMergeOptions makes Merge add the generated pages of opts, e.g. a title page before each input. Inputs are named
+// by their .metadata, inputs without one, e.g. plain PDFs, by opts.Names in the order of the inputs. The merged
+// document is named opts.Name.
+func WithMergeOptions(opts MergeOptions) Option {
+	return func(c *config) {
+		c.merge = opts
+	}
+}
+
+// newConfig applies opts. Processing stops with the error of ctx once it is done.
+func newConfig(ctx context.Context, opts []Option) *config {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.env.Context = ctx
+	return c
+}
+
+// ParseActions parses a comma-separated list of actions, e.g. "2a1,-3" inserts 2 pages after page 1 and deletes
+// page 3. It returns an *actions.ActionError if s is invalid.
+func ParseActions(s string) ([]Action, error) {
+	return actions.FromString(s)
+}
+
+// Edit applies acts to the document .zip in, which is size bytes long, and writes the resulting document .zip to out.
+// The result gets a fresh UUID and is validated before anything is written to out; errors are an
+// *actions.ActionError if acts don't fit the document, a *document.ValidationError if the result is invalid, or a
+// *document.DocumentError if the document can't be processed. Once ctx is done, Edit stops with its error.
+func Edit(ctx context.Context, in io.ReaderAt, size int64, out io.Writer, acts []Action, opts ...Option) error {
+	env := &newConfig(ctx, opts).env
+
+	r, uuid, err := openDoc(Doc{R: in, Size: size})
+	if err != nil {
+		return err
+	}
+
+	res := env.NewBlob()
+	defer res.Close()
+	w := zip.NewWriter(res)
+	err = actions.RunZip(env, uuid, r, w, acts)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return writeValidated(env, res, out)
+}
+
+// Merge merges inputs in order and writes the merged document .zip to out. Inputs are document .zips or plain PDFs. If
+// all inputs are notebooks, the result is a notebook, otherwise it is a PDF and the pages of notebooks show their
+// templates. See WithMergeOptions for adding title pages and a table of contents.
+// Like Edit, the result gets a fresh UUID and is validated before anything is written to out.
+func Merge(ctx context.Context, inputs []Doc, out io.Writer, opts ...Option) error {
+	c := newConfig(ctx, opts)
+	env := &c.env
+	if len(inputs) == 0 {
+		return &document.DocumentError{Op: "merge", Err: errors.New("no documents to merge")}
+	}
+
+	pdfDocs := make([]document.PdfDocument, 0, len(inputs))
+	defer func() {
+		for _, pdfDoc := range pdfDocs {
+			pdfDoc.Close()
+		}
+	}()
+	for _, in := range inputs {
+		if err := env.Err(); err != nil {
+			return err
+		}
+		pdfDoc, err := openMergeDoc(env, in)
+		if err != nil {
+			return err
+		}
+		pdfDocs = append(pdfDocs, pdfDoc)
+	}
+
+	merged, err := actions.Merge(env, pdfDocs, c.merge)
+	if err != nil {
+		return err
+	}
+	defer merged.Close()
+
+	res := env.NewBlob()
+	defer res.Close()
+	err = merged.WriteZip(res)
+	if err != nil {
+		return err
+	}
+
+	return writeValidated(env, res, out)
+}
+
+// Validate checks that the document .zip in is consistent, returning a *document.ValidationError if it is not.
+func Validate(in io.ReaderAt, size int64, opts ...Option) error {
+	r, err := zip.NewReader(in, size)
+	if err != nil {
+		return &document.DocumentError{Op: "open document", Err: err}
+	}
+	return document.ValidateZip(&newConfig(context.Background(), opts).env, r)
+}
+
+// openDoc opens the document .zip doc and returns its UUID.
+func openDoc(doc Doc) (*zip.Reader, string, error) {
+	r, err := zip.NewReader(doc.R, doc.Size)
+	if err != nil {
+		return nil, "", &document.DocumentError{Op: "open document", Err: err}
+	}
+	uuid, ok := document.ZipUuid(r)
+	if !ok {
+		return nil, "", &document.DocumentError{Op: "open document", Err: errors.New("not a document, missing .content file")}
+	}
+	return r, uuid, nil
+}
+
+// openMergeDoc reads the input doc of Merge, a document .zip or a plain PDF.
+func openMergeDoc(env *document.Env, doc Doc) (document.PdfDocument, error) {
+	if document.IsPdf(doc.R) {
+		return document.FromPdfReader(env, io.NewSectionReader(doc.R, 0, doc.Size))
+	}
+	r, uuid, err := openDoc(doc)
+	if err != nil {
+		return document.PdfDocument{}, err
+	}
+	return document.FromZipReader(env, r, uuid)
+}
+
+// writeValidated validates the document .zip res and copies it to out.
+func writeValidated(env *document.Env, res *document.Blob, out io.Writer) error {
+	r, err := zip.NewReader(res, res.Size())
+	if err != nil {
+		return err
+	}
+	err = document.ValidateZip(env, r)
+	if err != nil {
+		return err
+	}
+	if err := env.Err(); err != nil {
+		return err
+	}
+	_, err = io.Copy(out, res.Reader())
+	return err
+}
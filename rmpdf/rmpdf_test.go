@@ -0,0 +1,104 @@
+package rmpdf
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/skius/rm-pdf-tools/document"
+	"testing"
+)
+
+// testDoc returns a document .zip of a PDF with a single page.
+func testDoc(t *testing.T) Doc {
+	t.Helper()
+	pdf := bytes.Buffer{}
+	if err := document.WriteTextPdf(&pdf, []string{"Hello"}); err != nil {
+		t.Fatal(err)
+	}
+	pdfDoc, err := document.FromPdfReader(nil, &pdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdfDoc.Close()
+	buf := bytes.Buffer{}
+	if err = pdfDoc.WriteZip(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return Doc{R: bytes.NewReader(buf.Bytes()), Size: int64(buf.Len())}
+}
+
+// pageCount returns the number of pages of the document .zip data.
+func pageCount(t *testing.T, data []byte) int {
+	t.Helper()
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := document.ZipUuid(r)
+	pdfDoc, err := document.FromZipReader(nil, r, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pdfDoc.Close()
+	return len(pdfDoc.Content.Pages)
+}
+
+func TestEdit(t *testing.T) {
+	in := testDoc(t)
+	acts, err := ParseActions("2a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := bytes.Buffer{}
+	if err = Edit(context.Background(), in.R, in.Size, &out, acts); err != nil {
+		t.Fatal(err)
+	}
+	if n := pageCount(t, out.Bytes()); n != 3 {
+		t.Errorf("%d pages, want 3", n)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		pages int
+	}{
+		{"plain", nil, 2},
+		{"title pages", []Option{WithMergeOptions(MergeOptions{TitlePages: true})}, 4},
+		{"title pages and contents", []Option{WithMergeOptions(MergeOptions{TitlePages: true, Contents: true, Names: []string{"a", "b"}})}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := bytes.Buffer{}
+			if err := Merge(context.Background(), []Doc{testDoc(t), testDoc(t)}, &out, tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+			if n := pageCount(t, out.Bytes()); n != tt.pages {
+				t.Errorf("%d pages, want %d", n, tt.pages)
+			}
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	in := testDoc(t)
+	acts, err := ParseActions("1a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := bytes.Buffer{}
+	if err = Edit(ctx, in.R, in.Size, &out, acts); !errors.Is(err, context.Canceled) {
+		t.Errorf("edit: got error %v, want context.Canceled", err)
+	}
+	if err = Merge(ctx, []Doc{in, testDoc(t)}, &out); !errors.Is(err, context.Canceled) {
+		t.Errorf("merge: got error %v, want context.Canceled", err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("%d bytes written after cancelling", out.Len())
+	}
+}